@@ -0,0 +1,380 @@
+/*
+Package adapters provides composable middleware for gokismet
+Clients.
+
+An Adapter takes an existing Client and returns a new Client
+that supplements it with additional functionality. Use Chain
+to apply a series of Adapters:
+
+	client := adapters.Chain(http.DefaultClient,
+		adapters.WithHeader("Cache-Control", "no-cache"),
+		adapters.WithTimeout(5*time.Second),
+		adapters.WithRetries(2, 100*time.Millisecond),
+	)
+
+	ch := gokismet.NewCheckerClient("YOUR-API-KEY", "http://your-website.com", client)
+
+Adapters are applied in order, so the first Adapter wraps the
+original Client and the last Adapter sees each request first.
+*/
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deepilla/gokismet"
+)
+
+// An Adapter is a function that takes an existing Client and
+// supplements it with additional functionality.
+type Adapter func(gokismet.Client) gokismet.Client
+
+// Chain applies a series of Adapters to a Client.
+func Chain(client gokismet.Client, adapters ...Adapter) gokismet.Client {
+	for _, adapter := range adapters {
+		client = adapter(client)
+	}
+	return client
+}
+
+// WithHeader returns an Adapter that sets a header on
+// outgoing HTTP requests, replacing any existing value.
+func WithHeader(key, value string) Adapter {
+	return func(client gokismet.Client) gokismet.Client {
+		return gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+			req.Header.Set(key, value)
+			return client.Do(req)
+		})
+	}
+}
+
+// WithTimeout returns an Adapter that cancels HTTP requests
+// that take longer than the given duration. The timeout
+// covers the whole exchange, including reading the response
+// body.
+func WithTimeout(d time.Duration) Adapter {
+	return func(client gokismet.Client) gokismet.Client {
+		return gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+
+			ctx, cancel := context.WithTimeout(req.Context(), d)
+
+			resp, err := client.Do(req.WithContext(ctx))
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+
+			// Keep the Context alive until the caller has
+			// finished with the response body.
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		})
+	}
+}
+
+// A cancelBody is a response body that cancels a Context
+// when it is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// WithRetries returns an Adapter that retries requests that
+// fail with a network error or a 5xx response, up to the
+// given number of times. The delay between attempts starts
+// at backoff and doubles after each failure.
+//
+// Requests can only be retried if their body can be recreated
+// (see http.Request.GetBody). Requests created by gokismet
+// always satisfy this condition.
+func WithRetries(retries int, backoff time.Duration) Adapter {
+	return func(client gokismet.Client) gokismet.Client {
+		return gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+
+			for attempt := 0; ; attempt++ {
+
+				if attempt > 0 && req.GetBody != nil {
+					body, err := req.GetBody()
+					if err != nil {
+						return nil, err
+					}
+					req.Body = body
+				}
+
+				resp, err := client.Do(req)
+
+				retryable := err != nil || resp.StatusCode >= http.StatusInternalServerError
+				if !retryable || attempt >= retries || (req.Body != nil && req.GetBody == nil) {
+					return resp, err
+				}
+
+				if resp != nil {
+					resp.Body.Close()
+				}
+
+				select {
+				case <-req.Context().Done():
+					return nil, req.Context().Err()
+				case <-time.After(backoff << uint(attempt)):
+				}
+			}
+		})
+	}
+}
+
+// WithLogging returns an Adapter that logs each HTTP request
+// and its outcome to the given Logger. API keys in request
+// URLs and error messages are masked.
+func WithLogging(logger *slog.Logger) Adapter {
+	return func(client gokismet.Client) gokismet.Client {
+		return gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+
+			start := time.Now()
+			resp, err := client.Do(req)
+
+			attrs := []slog.Attr{
+				slog.String("method", req.Method),
+				slog.String("url", redactURL(req)),
+				slog.Duration("duration", time.Since(start)),
+			}
+
+			if err != nil {
+				attrs = append(attrs, slog.String("error", redactError(req, err)))
+				logger.LogAttrs(req.Context(), slog.LevelWarn, "http request failed", attrs...)
+				return nil, err
+			}
+
+			attrs = append(attrs, slog.Int("status", resp.StatusCode))
+			logger.LogAttrs(req.Context(), slog.LevelInfo, "http request", attrs...)
+
+			return resp, nil
+		})
+	}
+}
+
+// WithDump returns an Adapter that writes a dump of each
+// HTTP request and response to the given function, e.g.
+// log.Print. If body is true, the dumps include request and
+// response bodies. API keys are masked.
+func WithDump(write func(...interface{}), body bool) Adapter {
+	return func(client gokismet.Client) gokismet.Client {
+		return gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+
+			if dump, err := httputil.DumpRequestOut(req, body); err == nil {
+				write(redactDump(req, string(dump)))
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				return nil, err
+			}
+
+			if dump, err := httputil.DumpResponse(resp, body); err == nil {
+				write(string(dump))
+			}
+
+			return resp, nil
+		})
+	}
+}
+
+// WithRateLimit returns an Adapter that limits outgoing
+// requests to rate requests per second, with bursts of up
+// to burst requests. Requests over the limit wait for a slot
+// to become available, or until their Context is done.
+//
+// A burst of less than 1 is treated as 1, i.e. requests are
+// evenly spaced with no bursts. WithRateLimit panics if rate
+// is not positive.
+func WithRateLimit(rate float64, burst int) Adapter {
+
+	if !(rate > 0) {
+		panic(fmt.Sprintf("adapters: non-positive rate %v for WithRateLimit", rate))
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	limiter := &rateLimiter{
+		interval: time.Duration(float64(time.Second) / rate),
+		burst:    burst,
+	}
+
+	return func(client gokismet.Client) gokismet.Client {
+		return gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+			if err := limiter.wait(req.Context()); err != nil {
+				return nil, err
+			}
+			return client.Do(req)
+		})
+	}
+}
+
+// A rateLimiter is a token bucket that schedules requests
+// at fixed intervals.
+type rateLimiter struct {
+	interval time.Duration
+	burst    int
+
+	mu sync.Mutex
+	// next is the time at which the bucket will be full.
+	next time.Time
+}
+
+// wait blocks until a request is allowed to proceed.
+func (rl *rateLimiter) wait(ctx context.Context) error {
+
+	rl.mu.Lock()
+
+	now := time.Now()
+
+	// The earliest the bucket can be full is now. Each
+	// request pushes it back by one interval. Requests
+	// have to wait once it's more than a burst ahead.
+	if rl.next.Before(now) {
+		rl.next = now
+	}
+	rl.next = rl.next.Add(rl.interval)
+
+	delay := rl.next.Sub(now) - time.Duration(rl.burst)*rl.interval
+
+	rl.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		// Give back the slot we reserved.
+		rl.mu.Lock()
+		rl.next = rl.next.Add(-rl.interval)
+		rl.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// RequestStats describes a completed HTTP request.
+type RequestStats struct {
+	// The Akismet method, e.g. "comment-check".
+	Method string
+	// The HTTP status code, or zero if the request failed.
+	StatusCode int
+	// The time taken to receive a response.
+	Duration time.Duration
+	// The error returned by the Client, if any.
+	Err error
+}
+
+// WithMetrics returns an Adapter that passes statistics for
+// each HTTP request to the given function. The function is
+// called synchronously so it should return quickly.
+func WithMetrics(record func(*RequestStats)) Adapter {
+	return func(client gokismet.Client) gokismet.Client {
+		return gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+
+			start := time.Now()
+			resp, err := client.Do(req)
+
+			stats := &RequestStats{
+				Method:   path.Base(req.URL.Path),
+				Duration: time.Since(start),
+				Err:      err,
+			}
+			if resp != nil {
+				stats.StatusCode = resp.StatusCode
+			}
+
+			record(stats)
+
+			return resp, err
+		})
+	}
+}
+
+// akismetHost is the hostname of the Akismet API. Calls that
+// need an API key use a subdomain of this host.
+const akismetHost = "rest.akismet.com"
+
+// hostKey returns the API key in a request's hostname, if
+// any.
+func hostKey(req *http.Request) string {
+
+	if key := strings.TrimSuffix(req.URL.Host, "."+akismetHost); key != req.URL.Host {
+		return key
+	}
+
+	return ""
+}
+
+// redactURL returns a request's URL with any API key in the
+// hostname masked.
+func redactURL(req *http.Request) string {
+
+	u := *req.URL
+
+	if key := hostKey(req); key != "" {
+		u.Host = gokismet.MaskKey(key) + "." + akismetHost
+	}
+
+	return u.String()
+}
+
+// redactError returns the message of an error from a request
+// with any API key in the hostname masked. Errors returned by
+// an http.Client include the request URL.
+func redactError(req *http.Request, err error) string {
+
+	msg := err.Error()
+
+	if key := hostKey(req); key != "" {
+		msg = strings.Replace(msg, key, gokismet.MaskKey(key), -1)
+	}
+
+	return msg
+}
+
+// keyParam matches the key parameter in a URL-encoded body.
+var keyParam = regexp.MustCompile(`(^|&|\n)(key|api_key)=([^&\s]*)`)
+
+// bearerHeader matches a bearer token in a request dump.
+var bearerHeader = regexp.MustCompile(`(?im)^(Authorization: Bearer )(\S+)`)
+
+// redactDump masks any API keys in a request dump.
+func redactDump(req *http.Request, dump string) string {
+
+	if key := hostKey(req); key != "" {
+		dump = strings.Replace(dump, key+"."+akismetHost, gokismet.MaskKey(key)+"."+akismetHost, -1)
+	}
+
+	dump = bearerHeader.ReplaceAllStringFunc(dump, func(s string) string {
+		m := bearerHeader.FindStringSubmatch(s)
+		return m[1] + gokismet.MaskKey(m[2])
+	})
+
+	return keyParam.ReplaceAllStringFunc(dump, func(s string) string {
+		m := keyParam.FindStringSubmatch(s)
+		return m[1] + m[2] + "=" + gokismet.MaskKey(m[3])
+	})
+}
@@ -0,0 +1,365 @@
+package adapters_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepilla/gokismet"
+	"github.com/deepilla/gokismet/adapters"
+)
+
+const testURL = "https://123456789abc.rest.akismet.com/1.1/comment-check"
+
+// newRequest returns a request like the ones gokismet sends
+// to Akismet.
+func newRequest(t *testing.T) *http.Request {
+	req, err := http.NewRequest("POST", testURL, strings.NewReader("blog=http%3A%2F%2Fexample.com&key=123456789abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+// respond returns a ClientFunc that always returns a response
+// with the given status code and body.
+func respond(status int, body string) gokismet.ClientFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: status,
+			Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{"X-Akismet-Pro-Tip": {"discard"}},
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+		}, nil
+	}
+}
+
+// TestChain verifies that Adapters are applied in order.
+func TestChain(t *testing.T) {
+
+	var order []string
+
+	tag := func(name string) adapters.Adapter {
+		return func(client gokismet.Client) gokismet.Client {
+			return gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return client.Do(req)
+			})
+		}
+	}
+
+	client := adapters.Chain(respond(http.StatusOK, "true"), tag("first"), tag("second"), tag("third"))
+	client.Do(newRequest(t))
+
+	if got := strings.Join(order, ","); got != "third,second,first" {
+		t.Errorf("Expected Adapters to run in the order %q, got %q", "third,second,first", got)
+	}
+}
+
+// TestWithHeader verifies that WithHeader sets request headers.
+func TestWithHeader(t *testing.T) {
+
+	var got string
+
+	client := adapters.Chain(gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+		got = req.Header.Get("User-Agent")
+		return respond(http.StatusOK, "true")(req)
+	}), adapters.WithHeader("User-Agent", "YourApp/1.0"))
+
+	req := newRequest(t)
+	req.Header.Set("User-Agent", gokismet.UserAgent)
+	client.Do(req)
+
+	if got != "YourApp/1.0" {
+		t.Errorf("Expected User-Agent %q, got %q", "YourApp/1.0", got)
+	}
+}
+
+// TestWithTimeout verifies that WithTimeout cancels slow
+// requests.
+func TestWithTimeout(t *testing.T) {
+
+	slow := gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(time.Second):
+			return respond(http.StatusOK, "true")(req)
+		}
+	})
+
+	client := adapters.Chain(slow, adapters.WithTimeout(10*time.Millisecond))
+
+	if _, err := client.Do(newRequest(t)); err != context.DeadlineExceeded {
+		t.Errorf("Expected error %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	client = adapters.Chain(respond(http.StatusOK, "true"), adapters.WithTimeout(time.Second))
+
+	resp, err := client.Do(newRequest(t))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "true" {
+		t.Errorf("Expected body %q, got %q", "true", body)
+	}
+}
+
+// TestWithRetries verifies that failed requests are retried
+// with their original body.
+func TestWithRetries(t *testing.T) {
+
+	var bodies []string
+
+	flaky := gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+
+		switch len(bodies) {
+		case 1:
+			return nil, errors.New("connection refused")
+		case 2:
+			return respond(http.StatusServiceUnavailable, "")(req)
+		default:
+			return respond(http.StatusOK, "true")(req)
+		}
+	})
+
+	resp, err := adapters.Chain(flaky, adapters.WithRetries(2, 0)).Do(newRequest(t))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected HTTP Status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	if len(bodies) != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", len(bodies))
+	}
+
+	for i, body := range bodies {
+		if body != bodies[0] || body == "" {
+			t.Errorf("Attempt %d: Expected body %q, got %q", i+1, bodies[0], body)
+		}
+	}
+
+	// Client errors are not retried.
+	bodies = nil
+	client := adapters.Chain(gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+		bodies = append(bodies, "")
+		return respond(http.StatusBadRequest, "")(req)
+	}), adapters.WithRetries(2, 0))
+
+	client.Do(newRequest(t))
+
+	if len(bodies) != 1 {
+		t.Errorf("Expected 1 attempt, got %d", len(bodies))
+	}
+}
+
+// TestWithLogging verifies that WithLogging logs requests
+// without revealing the API key.
+func TestWithLogging(t *testing.T) {
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	client := adapters.Chain(respond(http.StatusOK, "true"), adapters.WithLogging(logger))
+	client.Do(newRequest(t))
+
+	out := buf.String()
+
+	for _, s := range []string{
+		`msg="http request"`,
+		`url=https://********9abc.rest.akismet.com/1.1/comment-check`,
+		`status=200`,
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Expected log output to contain %q, got %q", s, out)
+		}
+	}
+
+	if strings.Contains(out, "123456789abc") {
+		t.Errorf("Expected log output not to contain the API key")
+	}
+
+	// Errors from an http.Client include the request URL.
+	buf.Reset()
+
+	failing := gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, &url.Error{Op: "Post", URL: req.URL.String(), Err: errors.New("connection refused")}
+	})
+
+	client = adapters.Chain(failing, adapters.WithLogging(logger))
+	client.Do(newRequest(t))
+
+	out = buf.String()
+
+	if !strings.Contains(out, `error="Post \"https://********9abc.rest.akismet.com/1.1/comment-check\": connection refused"`) {
+		t.Errorf("Expected log output to contain the masked error, got %q", out)
+	}
+
+	if strings.Contains(out, "123456789abc") {
+		t.Errorf("Expected log output not to contain the API key")
+	}
+}
+
+// TestWithDump verifies that WithDump dumps requests and
+// responses without revealing the API key.
+func TestWithDump(t *testing.T) {
+
+	var dumps []string
+	write := func(args ...interface{}) {
+		dumps = append(dumps, fmt.Sprint(args...))
+	}
+
+	client := adapters.Chain(respond(http.StatusOK, "true"), adapters.WithDump(write, true))
+
+	// Some providers send the key as a bearer token.
+	req := newRequest(t)
+	req.Header.Set("Authorization", "Bearer 123456789abc")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if len(dumps) != 2 {
+		t.Fatalf("Expected 2 dumps, got %d", len(dumps))
+	}
+
+	for _, s := range []string{
+		"POST /1.1/comment-check HTTP/1.1",
+		"Host: ********9abc.rest.akismet.com",
+		"key=********9abc",
+		"Authorization: Bearer ********9abc",
+	} {
+		if !strings.Contains(dumps[0], s) {
+			t.Errorf("Expected request dump to contain %q, got %q", s, dumps[0])
+		}
+	}
+
+	if strings.Contains(dumps[0], "123456789abc") {
+		t.Errorf("Expected request dump not to contain the API key")
+	}
+
+	for _, s := range []string{
+		"HTTP/1.1 200 OK",
+		"X-Akismet-Pro-Tip: discard",
+		"true",
+	} {
+		if !strings.Contains(dumps[1], s) {
+			t.Errorf("Expected response dump to contain %q, got %q", s, dumps[1])
+		}
+	}
+
+	// The response body should still be readable.
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "true" {
+		t.Errorf("Expected body %q, got %q", "true", body)
+	}
+}
+
+// TestWithRateLimit verifies that WithRateLimit delays
+// requests over the limit.
+func TestWithRateLimit(t *testing.T) {
+
+	client := adapters.Chain(respond(http.StatusOK, "true"), adapters.WithRateLimit(20, 2))
+
+	start := time.Now()
+
+	// The first two requests use up the burst. The next
+	// two should be spaced 50ms apart.
+	for i := 0; i < 4; i++ {
+		if _, err := client.Do(newRequest(t)); err != nil {
+			t.Fatalf("Request %d: Unexpected error %s", i+1, err)
+		}
+	}
+
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("Expected requests to take at least %s, took %s", 90*time.Millisecond, d)
+	}
+
+	// Requests waiting for a slot give up when their
+	// Context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	if _, err := client.Do(newRequest(t).WithContext(ctx)); err != context.DeadlineExceeded {
+		t.Errorf("Expected error %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+// TestWithRateLimit_Args verifies that WithRateLimit rejects
+// non-positive rates and allows at least one request at a
+// time.
+func TestWithRateLimit_Args(t *testing.T) {
+
+	for _, rate := range []float64{0, -1, math.NaN()} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Rate %v: Expected a panic", rate)
+				}
+			}()
+			adapters.WithRateLimit(rate, 1)
+		}()
+	}
+
+	// A burst of 0 behaves like a burst of 1, so the first
+	// request doesn't wait.
+	client := adapters.Chain(respond(http.StatusOK, "true"), adapters.WithRateLimit(0.1, 0))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err := client.Do(newRequest(t).WithContext(ctx)); err != nil {
+		t.Errorf("Unexpected error %s", err)
+	}
+}
+
+// TestWithMetrics verifies that WithMetrics reports request
+// statistics.
+func TestWithMetrics(t *testing.T) {
+
+	var stats []*adapters.RequestStats
+	record := func(s *adapters.RequestStats) {
+		stats = append(stats, s)
+	}
+
+	client := adapters.Chain(respond(http.StatusOK, "true"), adapters.WithMetrics(record))
+	client.Do(newRequest(t))
+
+	if len(stats) != 1 {
+		t.Fatalf("Expected 1 set of stats, got %d", len(stats))
+	}
+
+	if stats[0].Method != "comment-check" {
+		t.Errorf("Expected Method %q, got %q", "comment-check", stats[0].Method)
+	}
+
+	if stats[0].StatusCode != http.StatusOK {
+		t.Errorf("Expected HTTP Status %d, got %d", http.StatusOK, stats[0].StatusCode)
+	}
+
+	if stats[0].Err != nil {
+		t.Errorf("Unexpected error %s", stats[0].Err)
+	}
+}
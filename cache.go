@@ -0,0 +1,197 @@
+package gokismet
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Cache stores the results of spam checks so that repeated
+// checks of the same content don't require a call to Akismet.
+// Cache keys are generated by HashValues. Implementations must
+// be safe for concurrent use.
+type Cache interface {
+	// Get returns the result stored under the given key,
+	// if any.
+	Get(key string) (*CheckResult, bool)
+	// Add stores a result under the given key.
+	Add(key string, result *CheckResult)
+}
+
+// WithCache returns an Option that adds a Cache to a Checker.
+// Before calling Akismet, the Checker's Check methods look for
+// a previous result for the same key-value pairs in the Cache.
+// Successful results are added to the Cache. Errors are never
+// cached.
+//
+// The filter determines which keys are used to identify
+// content (see HashValues). If filter is nil, all keys are
+// used. The same filter is used to detect concurrent checks
+// of the same content.
+func WithCache(cache Cache, filter *KeyFilter) Option {
+	return func(ch *Checker) {
+		ch.cache = cache
+		ch.keyFilter = filter
+	}
+}
+
+// A KeyFilter selects the key-value pairs used to identify
+// a chunk of content.
+type KeyFilter struct {
+	// If non-empty, only these keys are used.
+	Include []string
+	// These keys are never used, e.g. "comment_date_gmt"
+	// for content that is timestamped on submission.
+	Exclude []string
+}
+
+// match reports whether the filter allows the given key.
+// A nil KeyFilter allows all keys.
+func (f *KeyFilter) match(key string) bool {
+
+	if f == nil {
+		return true
+	}
+
+	if len(f.Include) > 0 && !containsString(f.Include, key) {
+		return false
+	}
+
+	return !containsString(f.Exclude, key)
+}
+
+// HashValues returns a canonical hash of a set of key-value
+// pairs, suitable for use as a cache key. Keys are sorted and
+// empty values are ignored (Checker never sends them), so any
+// two maps that produce the same Akismet request produce the
+// same hash. If filter is non-nil, only the keys it selects
+// contribute to the hash.
+func HashValues(values map[string]string, filter *KeyFilter) string {
+
+	keys := make([]string, 0, len(values))
+	for k, v := range values {
+		if v != "" && filter.match(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		// Length-prefix keys and values so that different
+		// maps can't produce the same byte stream.
+		writeHashString(h, k)
+		writeHashString(h, values[k])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeHashString(h hash.Hash, s string) {
+	var n [8]byte
+	binary.LittleEndian.PutUint64(n[:], uint64(len(s)))
+	h.Write(n[:])
+	h.Write([]byte(s))
+}
+
+// An LRUCache is an in-memory Cache with a fixed capacity.
+// When the cache is full, the least recently used result is
+// evicted. Results also expire after a fixed time-to-live.
+type LRUCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type lruEntry struct {
+	key     string
+	result  *CheckResult
+	expires time.Time
+}
+
+// NewLRUCache returns an LRUCache that holds up to size
+// results. If ttl is positive, results expire that long after
+// being added. Otherwise they are only removed on eviction.
+func NewLRUCache(size int, ttl time.Duration) *LRUCache {
+	return &LRUCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Get returns the result stored under the given key, if
+// it exists and has not expired.
+func (c *LRUCache) Get(key string) (*CheckResult, bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*lruEntry)
+	if c.ttl > 0 && time.Now().After(entry.expires) {
+		c.remove(el)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return entry.result, true
+}
+
+// Add stores a result under the given key, evicting the
+// least recently used result if the cache is full.
+func (c *LRUCache) Add(key string, result *CheckResult) {
+
+	if c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*lruEntry)
+		entry.result = result
+		entry.expires = expires
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{
+		key:     key,
+		result:  result,
+		expires: expires,
+	})
+
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Len returns the number of results in the cache, including
+// any that have expired but not yet been removed.
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRUCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*lruEntry).key)
+}
@@ -0,0 +1,205 @@
+package gokismet_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/deepilla/gokismet"
+)
+
+// TestHashValues verifies that HashValues produces the same
+// hash for equivalent key-value pairs.
+func TestHashValues(t *testing.T) {
+
+	values := map[string]string{
+		"comment_author":   "A. Commenter",
+		"comment_content":  "Hello world",
+		"comment_date_gmt": "2016-04-01T14:00:00Z",
+	}
+
+	tests := []struct {
+		Values map[string]string
+		Filter *gokismet.KeyFilter
+		Equal  bool
+	}{
+		{
+			// Empty values are ignored.
+			Values: map[string]string{
+				"comment_author":   "A. Commenter",
+				"comment_content":  "Hello world",
+				"comment_date_gmt": "2016-04-01T14:00:00Z",
+				"user_ip":          "",
+			},
+			Equal: true,
+		},
+		{
+			Values: map[string]string{
+				"comment_author":   "A. Commenter",
+				"comment_content":  "Hello world",
+				"comment_date_gmt": "2016-04-02T14:00:00Z",
+			},
+			Equal: false,
+		},
+		{
+			// Excluded keys are ignored.
+			Values: map[string]string{
+				"comment_author":   "A. Commenter",
+				"comment_content":  "Hello world",
+				"comment_date_gmt": "2016-04-02T14:00:00Z",
+			},
+			Filter: &gokismet.KeyFilter{
+				Exclude: []string{"comment_date_gmt"},
+			},
+			Equal: true,
+		},
+		{
+			// Only included keys are used.
+			Values: map[string]string{
+				"comment_author":  "Someone Else",
+				"comment_content": "Hello world",
+			},
+			Filter: &gokismet.KeyFilter{
+				Include: []string{"comment_content"},
+			},
+			Equal: true,
+		},
+		{
+			// Keys and values can't bleed into each other.
+			Values: map[string]string{
+				"comment_author":    "A. Commenter",
+				"comment_content":   "Hello worl",
+				"dcomment_date_gmt": "2016-04-01T14:00:00Z",
+			},
+			Equal: false,
+		},
+	}
+
+	for i, test := range tests {
+		h1 := gokismet.HashValues(values, test.Filter)
+		h2 := gokismet.HashValues(test.Values, test.Filter)
+		if equal := h1 == h2; equal != test.Equal {
+			t.Errorf("Test %d: Expected equal hashes to be %v, got %v", i+1, test.Equal, equal)
+		}
+	}
+}
+
+// TestLRUCache verifies eviction and expiry in LRUCache.
+func TestLRUCache(t *testing.T) {
+
+	cache := gokismet.NewLRUCache(2, 0)
+
+	cache.Add("a", &gokismet.CheckResult{Status: gokismet.StatusHam})
+	cache.Add("b", &gokismet.CheckResult{Status: gokismet.StatusProbableSpam})
+
+	// Using "a" makes "b" the least recently used entry.
+	if _, ok := cache.Get("a"); !ok {
+		t.Errorf("Expected a cached result for %q", "a")
+	}
+
+	cache.Add("c", &gokismet.CheckResult{Status: gokismet.StatusDefiniteSpam})
+
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("Expected %q to be evicted", "b")
+	}
+
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("Expected a cached result for %q", key)
+		}
+	}
+
+	if n := cache.Len(); n != 2 {
+		t.Errorf("Expected %d cached results, got %d", 2, n)
+	}
+
+	cache = gokismet.NewLRUCache(2, time.Millisecond)
+	cache.Add("a", &gokismet.CheckResult{Status: gokismet.StatusHam})
+
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := cache.Get("a"); ok {
+		t.Errorf("Expected %q to expire", "a")
+	}
+}
+
+// TestCheckerCache verifies that Checkers use their Cache to
+// avoid repeat calls to Akismet.
+func TestCheckerCache(t *testing.T) {
+
+	store := &RequestStore{}
+	client := adaptClient(store, withResponder(&Responder{
+		Responses: map[string]*ResponseInfo{
+			"verify-key": {
+				Body:       "valid",
+				StatusCode: http.StatusOK,
+			},
+			"comment-check": {
+				Body:       "true",
+				StatusCode: http.StatusOK,
+			},
+		},
+	}))
+
+	ch := gokismet.NewCheckerClient(TestAPIKey, TestSite, client,
+		gokismet.WithCache(gokismet.NewLRUCache(10, time.Minute), &gokismet.KeyFilter{
+			Exclude: []string{"comment_date_gmt"},
+		}),
+	)
+
+	tests := []struct {
+		Values   map[string]string
+		Cached   bool
+		Requests int
+	}{
+		{
+			// The first call verifies the key and checks
+			// the content.
+			Values: map[string]string{
+				"comment_content":  "Hello world",
+				"comment_date_gmt": "2016-04-01T14:00:00Z",
+			},
+			Requests: 2,
+		},
+		{
+			Values: map[string]string{
+				"comment_content":  "Hello world",
+				"comment_date_gmt": "2016-04-01T14:05:00Z",
+			},
+			Cached:   true,
+			Requests: 2,
+		},
+		{
+			Values: map[string]string{
+				"comment_content": "Goodbye world",
+			},
+			Requests: 3,
+		},
+	}
+
+	for i, test := range tests {
+
+		result, err := ch.CheckContext(context.Background(), test.Values)
+		if err != nil {
+			t.Fatalf("Test %d: Unexpected error %s", i+1, err)
+		}
+
+		if result.Status != gokismet.StatusProbableSpam {
+			t.Errorf("Test %d: Expected Spam Status %q, got %q", i+1,
+				statusToString(gokismet.StatusProbableSpam), statusToString(result.Status))
+		}
+
+		if result.Cached != test.Cached {
+			t.Errorf("Test %d: Expected Cached to be %v, got %v", i+1, test.Cached, result.Cached)
+		}
+
+		if result.Values["comment_date_gmt"] != test.Values["comment_date_gmt"] {
+			t.Errorf("Test %d: Expected the result to contain the original values", i+1)
+		}
+
+		if n := len(store.Requests); n != test.Requests {
+			t.Errorf("Test %d: Expected %d request(s), got %d", i+1, test.Requests, n)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+/*
+Command gokismetd is a standalone spam-checking service built
+on the gokismet library. It owns the Akismet credentials and
+exposes the Checker methods to other services as a JSON API.
+
+Usage:
+
+	gokismetd -site http://your-website.com -tokens tokens.txt
+
+The Akismet API key is read from the AKISMET_KEY environment
+variable. The tokens file lists one caller per line, as a name
+followed by that caller's API token. Blank lines and lines
+starting with # are ignored.
+
+Endpoints:
+
+	GET  /health          Liveness check (no authentication)
+	GET  /metrics         Prometheus metrics (no authentication)
+	POST /v1/check        Check content for spam
+	POST /v1/report-ham   Report a false positive
+	POST /v1/report-spam  Report a false negative
+	POST /v1/verify       Verify the Akismet credentials
+
+The check and report endpoints take a JSON object of the form
+{"values": {...}}, where values contains the Akismet key-value
+pairs. Callers authenticate with an "Authorization: Bearer"
+header containing their API token.
+
+# Local rules
+
+With the -rules flag, gokismetd evaluates the rules in the
+given JSON file (see package rules) before calling Akismet.
+Content matching a rule is decided locally, and the check
+response reports the deciding rule. The file is reloaded
+when it changes.
+
+# Proxy mode
+
+With the -proxy flag, gokismetd also serves the Akismet REST
+API endpoints under /1.1/, e.g. /1.1/comment-check. Existing
+Akismet clients can switch to gokismetd by changing their API
+host and using their gokismetd token in place of an Akismet
+key. The real key and site are injected by gokismetd, so the
+same rate limits apply and the key never leaves the server.
+*/
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/deepilla/gokismet"
+	"github.com/deepilla/gokismet/metrics"
+	"github.com/deepilla/gokismet/rules"
+)
+
+// A config holds gokismetd's command line settings.
+type config struct {
+	addr       string
+	site       string
+	tokensFile string
+	rate       float64
+	burst      int
+	retries    int
+	backoff    time.Duration
+	timeout    time.Duration
+	grace      time.Duration
+	proxy      bool
+	cacheSize  int
+	cacheTTL   time.Duration
+	rulesFile  string
+}
+
+func main() {
+
+	var cfg config
+
+	flag.StringVar(&cfg.addr, "addr", ":8080", "HTTP listen address")
+	flag.StringVar(&cfg.site, "site", "", "the website associated with the Akismet API key")
+	flag.StringVar(&cfg.tokensFile, "tokens", "", "path to the file of caller API tokens")
+	flag.Float64Var(&cfg.rate, "rate", 10, "requests per second allowed for each caller (0 for no limit)")
+	flag.IntVar(&cfg.burst, "burst", 20, "maximum burst size for each caller")
+	flag.IntVar(&cfg.retries, "retries", 2, "number of times to retry failed Akismet calls")
+	flag.DurationVar(&cfg.backoff, "backoff", 200*time.Millisecond, "initial delay between retries")
+	flag.DurationVar(&cfg.timeout, "timeout", 10*time.Second, "timeout for Akismet calls")
+	flag.DurationVar(&cfg.grace, "shutdown-timeout", 30*time.Second, "time allowed for in-flight requests on shutdown")
+	flag.IntVar(&cfg.cacheSize, "cache-size", 0, "maximum number of check results to cache (0 for no caching)")
+	flag.DurationVar(&cfg.cacheTTL, "cache-ttl", 10*time.Minute, "time to keep cached check results")
+	flag.StringVar(&cfg.rulesFile, "rules", "", "path to a JSON file of local spam rules")
+	flag.BoolVar(&cfg.proxy, "proxy", false, "serve the Akismet REST API endpoints for legacy clients")
+
+	flag.Parse()
+
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+
+	if err := run(&cfg, logger); err != nil {
+		logger.Error("fatal error", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+}
+
+// run starts the HTTP server and blocks until it fails or
+// the process receives an interrupt, in which case in-flight
+// requests are given time to complete.
+func run(cfg *config, logger *slog.Logger) error {
+
+	key := os.Getenv("AKISMET_KEY")
+	if key == "" {
+		return errors.New("AKISMET_KEY is not set")
+	}
+
+	if cfg.site == "" {
+		return errors.New("no site provided")
+	}
+
+	tokens, err := loadTokens(cfg.tokensFile)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: cfg.timeout}
+
+	reg := metrics.NewRegistry()
+
+	opts := []gokismet.Option{
+		gokismet.WithRetries(cfg.retries, cfg.backoff),
+		gokismet.WithObserver(reg.Collector("default")),
+		gokismet.WithLogger(logger, nil),
+		gokismet.WithApplication("gokismetd", ""),
+	}
+	if cfg.cacheSize > 0 {
+		// Timestamps vary between retries of the same
+		// submission so they're excluded from cache keys.
+		opts = append(opts, gokismet.WithCache(gokismet.NewLRUCache(cfg.cacheSize, cfg.cacheTTL), &gokismet.KeyFilter{
+			Exclude: []string{"comment_date_gmt"},
+		}))
+	}
+
+	checker := gokismet.NewCheckerClient(key, cfg.site, client, opts...)
+
+	var limiter *rateLimiter
+	if cfg.rate > 0 {
+		limiter = newRateLimiter(cfg.rate, cfg.burst)
+	}
+
+	s := newServer(checker, tokens, limiter, logger)
+	s.proxy = cfg.proxy
+	s.metrics = reg
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.rulesFile != "" {
+		engine, err := rules.Load(cfg.rulesFile)
+		if err != nil {
+			return err
+		}
+
+		go engine.Watch(ctx, 10*time.Second, func(err error) {
+			logger.Warn("failed to reload rules", slog.String("error", err.Error()))
+		})
+
+		s.pipeline = gokismet.NewPipeline().
+			Add("rules", rules.Stage(engine)).
+			Add("akismet", checker)
+	}
+
+	srv := &http.Server{
+		Addr:    cfg.addr,
+		Handler: s.Handler(),
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		logger.Info("listening", slog.String("addr", cfg.addr))
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.grace)
+	defer cancel()
+
+	return srv.Shutdown(ctx)
+}
+
+// loadTokens reads a tokens file and returns a map of API
+// tokens to caller names.
+func loadTokens(filename string) (map[string]string, error) {
+
+	if filename == "" {
+		return nil, errors.New("no tokens file provided")
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseTokens(f.Name(), bufio.NewScanner(f))
+}
+
+// parseTokens parses the lines of a tokens file.
+func parseTokens(name string, sc *bufio.Scanner) (map[string]string, error) {
+
+	tokens := make(map[string]string)
+
+	for line := 1; sc.Scan(); line++ {
+
+		s := strings.TrimSpace(sc.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+
+		fields := strings.Fields(s)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a caller name and token", name, line)
+		}
+
+		if _, ok := tokens[fields[1]]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate token", name, line)
+		}
+
+		tokens[fields[1]] = fields[0]
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s: no tokens found", name)
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/deepilla/gokismet"
+)
+
+// Akismet REST API methods and responses, as served by the
+// proxy endpoints.
+const (
+	methodVerify     = "verify-key"
+	methodCheck      = "comment-check"
+	methodReportHam  = "submit-ham"
+	methodReportSpam = "submit-spam"
+
+	responseVerified   = "valid"
+	responseUnverified = "invalid"
+	responseHam        = "false"
+	responseSpam       = "true"
+	responseReported   = "Thanks for making the web a better place."
+)
+
+// Akismet parameters that the proxy replaces with its own
+// credentials. Older clients send the API key as "key",
+// newer ones as "api_key".
+const (
+	paramKey    = "key"
+	paramAPIKey = "api_key"
+	paramSite   = "blog"
+)
+
+// proxyHandler returns a Handler that serves the Akismet REST
+// API endpoints, e.g. /1.1/comment-check. Legacy clients can
+// use gokismetd in place of rest.akismet.com by changing the
+// API host and using their gokismetd token as the API key.
+//
+// The real Akismet key and site are injected server-side, so
+// clients never see them.
+func (s *server) proxyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		method := path.Base(r.URL.Path)
+		caller, ok := s.tokens[proxyToken(r)]
+
+		// Like Akismet, respond to an invalid key on the
+		// verify-key endpoint with "invalid".
+		if method == methodVerify {
+			if !ok {
+				writeText(w, responseUnverified, nil)
+				return
+			}
+			if !s.allow(r, caller) {
+				writeTooManyRequests(w)
+				return
+			}
+			writeText(w, responseVerified, nil)
+			return
+		}
+
+		if !ok {
+			writeText(w, responseUnverified, http.Header{
+				"X-Akismet-Debug-Help": {"We were unable to find a valid API key."},
+			})
+			return
+		}
+
+		if !s.allow(r, caller) {
+			writeTooManyRequests(w)
+			return
+		}
+
+		values := proxyValues(r)
+
+		switch method {
+		case methodCheck:
+			v, err := s.check(r.Context(), values)
+			if err != nil {
+				s.writeProxyError(w, r, err)
+				return
+			}
+
+			header := make(http.Header)
+			if v.CheckResult != nil && v.CheckResult.GUID != "" {
+				header.Set("X-Akismet-Guid", v.CheckResult.GUID)
+			}
+
+			switch v.Status {
+			case gokismet.StatusHam:
+				writeText(w, responseHam, header)
+			case gokismet.StatusDefiniteSpam:
+				header.Set("X-Akismet-Pro-Tip", "discard")
+				fallthrough
+			default:
+				writeText(w, responseSpam, header)
+			}
+
+		case methodReportHam, methodReportSpam:
+			report := s.checker.ReportHamContext
+			if method == methodReportSpam {
+				report = s.checker.ReportSpamContext
+			}
+
+			if err := report(r.Context(), values); err != nil {
+				s.writeProxyError(w, r, err)
+				return
+			}
+
+			writeText(w, responseReported, nil)
+
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// proxyToken returns the API key supplied by an Akismet
+// client. Akismet accepts the key either as the first label
+// of the API hostname or as a request parameter.
+func proxyToken(r *http.Request) string {
+
+	for _, param := range []string{paramKey, paramAPIKey} {
+		if key := r.PostForm.Get(param); key != "" {
+			return key
+		}
+	}
+
+	host := r.Host
+	if i := strings.IndexByte(host, '.'); i > 0 {
+		return host[:i]
+	}
+
+	return ""
+}
+
+// proxyValues returns the key-value pairs from an Akismet
+// client's request, minus the client's key and site.
+func proxyValues(r *http.Request) map[string]string {
+
+	values := make(map[string]string)
+
+	for k, v := range r.PostForm {
+		if k == paramKey || k == paramAPIKey || k == paramSite || len(v) == 0 {
+			continue
+		}
+		values[k] = v[0]
+	}
+
+	return values
+}
+
+// writeProxyError passes an error returned by the Checker
+// back to an Akismet client. Unexpected responses from
+// Akismet are relayed as is. Anything else is logged and
+// reported as a failure to reach Akismet.
+func (s *server) writeProxyError(w http.ResponseWriter, r *http.Request, err error) {
+
+	var valErr *gokismet.ValError
+	var keyErr *gokismet.KeyError
+
+	switch {
+	case errors.As(err, &keyErr):
+		// The client's token was valid so this is our
+		// problem, not theirs.
+		http.Error(w, "upstream API key not verified", http.StatusBadGateway)
+	case errors.As(err, &valErr):
+		header := make(http.Header)
+		if valErr.Hint != "" {
+			header.Set("X-Akismet-Debug-Help", valErr.Hint)
+		}
+		writeText(w, valErr.Response, header)
+	default:
+		s.logger.Warn("upstream error",
+			slog.String("path", r.URL.Path),
+			slog.String("error", err.Error()),
+		)
+		http.Error(w, "akismet unavailable", http.StatusBadGateway)
+	}
+}
+
+func writeTooManyRequests(w http.ResponseWriter) {
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+}
+
+func writeText(w http.ResponseWriter, body string, header http.Header) {
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(body))
+}
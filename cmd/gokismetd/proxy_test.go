@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/deepilla/gokismet"
+)
+
+// TestProxy verifies the responses returned by the Akismet
+// compatible endpoints.
+func TestProxy(t *testing.T) {
+
+	var requests []url.Values
+
+	client := gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+
+		body, _ := io.ReadAll(req.Body)
+		values, _ := url.ParseQuery(string(body))
+		requests = append(requests, values)
+
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     make(http.Header),
+		}
+
+		switch {
+		case strings.HasSuffix(req.URL.Path, "verify-key"):
+			resp.Body = io.NopCloser(strings.NewReader("valid"))
+		case strings.HasPrefix(path.Base(req.URL.Path), "submit-"):
+			resp.Body = io.NopCloser(strings.NewReader("Thanks for making the web a better place."))
+		case values.Get("comment_author") == "viagra-test-123":
+			resp.Header.Set("X-Akismet-Pro-Tip", "discard")
+			resp.Header.Set("X-Akismet-Guid", "abc123")
+			resp.Body = io.NopCloser(strings.NewReader("true"))
+		case values.Get("comment_author") == "":
+			resp.Header.Set("X-Akismet-Debug-Help", "Empty comment")
+			resp.Body = io.NopCloser(strings.NewReader("invalid"))
+		default:
+			resp.Body = io.NopCloser(strings.NewReader("false"))
+		}
+
+		return resp, nil
+	})
+
+	checker := gokismet.NewCheckerClient("123456789abc", "http://example.com", client)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	s := newServer(checker, map[string]string{"secret": "alice"}, nil, logger)
+	s.proxy = true
+	handler := s.Handler()
+
+	tests := []struct {
+		Host     string
+		Path     string
+		Body     string
+		Response string
+		Header   map[string]string
+	}{
+		{
+			Host:     "rest.example.com",
+			Path:     "/1.1/verify-key",
+			Body:     "key=secret&blog=http://legacy.example.com",
+			Response: "valid",
+		},
+		{
+			Host:     "rest.example.com",
+			Path:     "/1.1/verify-key",
+			Body:     "key=wrong&blog=http://legacy.example.com",
+			Response: "invalid",
+		},
+		{
+			Host:     "secret.rest.example.com",
+			Path:     "/1.1/comment-check",
+			Body:     "blog=http://legacy.example.com&comment_author=A.+Commenter",
+			Response: "false",
+		},
+		{
+			Host:     "rest.example.com",
+			Path:     "/1.1/comment-check",
+			Body:     "key=secret&comment_author=viagra-test-123",
+			Response: "true",
+			Header: map[string]string{
+				"X-Akismet-Pro-Tip": "discard",
+				"X-Akismet-Guid":    "abc123",
+			},
+		},
+		{
+			Host:     "rest.example.com",
+			Path:     "/1.1/verify-key",
+			Body:     "api_key=secret&blog=http://legacy.example.com",
+			Response: "valid",
+		},
+		{
+			Host:     "rest.example.com",
+			Path:     "/1.1/comment-check",
+			Body:     "api_key=secret&comment_author=A.+Commenter",
+			Response: "false",
+		},
+		{
+			// Tokens are never forwarded to Akismet.
+			Host:     "secret.rest.example.com",
+			Path:     "/1.1/comment-check",
+			Body:     "api_key=secret&comment_author=A.+Commenter",
+			Response: "false",
+		},
+		{
+			Host:     "wrong.rest.example.com",
+			Path:     "/1.1/comment-check",
+			Body:     "comment_author=A.+Commenter",
+			Response: "invalid",
+		},
+		{
+			// Unexpected responses are relayed to the client.
+			Host:     "secret.rest.example.com",
+			Path:     "/1.1/comment-check",
+			Body:     "comment_content=Hello",
+			Response: "invalid",
+			Header: map[string]string{
+				"X-Akismet-Debug-Help": "Empty comment",
+			},
+		},
+		{
+			Host:     "secret.rest.example.com",
+			Path:     "/1.1/submit-spam",
+			Body:     "comment_author=A.+Commenter",
+			Response: "Thanks for making the web a better place.",
+		},
+	}
+
+	for i, test := range tests {
+
+		requests = nil
+
+		req := httptest.NewRequest("POST", test.Path, strings.NewReader(test.Body))
+		req.Host = test.Host
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if got := rec.Body.String(); got != test.Response {
+			t.Errorf("Test %d: Expected response %q, got %q", i+1, test.Response, got)
+		}
+
+		for k, v := range test.Header {
+			if got := rec.Header().Get(k); got != v {
+				t.Errorf("Test %d: Expected header %s %q, got %q", i+1, k, v, got)
+			}
+		}
+
+		// Requests forwarded to Akismet must use the real
+		// key and site, not the client's.
+		for _, values := range requests {
+			if key := values.Get("key"); key != "" && key != "123456789abc" {
+				t.Errorf("Test %d: Expected key %q, got %q", i+1, "123456789abc", key)
+			}
+			if _, ok := values["api_key"]; ok {
+				t.Errorf("Test %d: Expected no api_key, got %q", i+1, values.Get("api_key"))
+			}
+			if site := values.Get("blog"); site != "http://example.com" {
+				t.Errorf("Test %d: Expected blog %q, got %q", i+1, "http://example.com", site)
+			}
+		}
+	}
+}
+
+// TestProxy_UpstreamError verifies that network errors are
+// not relayed to Akismet clients.
+func TestProxy_UpstreamError(t *testing.T) {
+
+	client := gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, &url.Error{
+			Op:  "Post",
+			URL: req.URL.String(),
+			Err: errors.New("connection refused"),
+		}
+	})
+
+	checker := gokismet.NewCheckerClient("123456789abc", "http://example.com", client)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	s := newServer(checker, map[string]string{"secret": "alice"}, nil, logger)
+	s.proxy = true
+
+	req := httptest.NewRequest("POST", "/1.1/comment-check", strings.NewReader("comment_author=A.+Commenter"))
+	req.Host = "secret.rest.example.com"
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("Expected HTTP Status %d, got %d", http.StatusBadGateway, rec.Code)
+	}
+
+	if got, exp := strings.TrimSpace(rec.Body.String()), "akismet unavailable"; got != exp {
+		t.Errorf("Expected response %q, got %q", exp, got)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/deepilla/gokismet"
+)
+
+// A server is the HTTP front end for gokismetd. It exposes
+// the Checker methods as a JSON API to authenticated callers.
+type server struct {
+	checker *gokismet.Checker
+	// pipeline runs spam checks. By default, it consists of
+	// the Checker alone.
+	pipeline *gokismet.Pipeline
+	// tokens maps API tokens to caller names.
+	tokens  map[string]string
+	limiter *rateLimiter
+	logger  *slog.Logger
+	// proxy enables the Akismet-compatible endpoints.
+	proxy bool
+	// metrics, if non-nil, serves the /metrics endpoint.
+	metrics http.Handler
+}
+
+// newServer returns a server that uses the given Checker to
+// service requests. Callers authenticate with the API tokens
+// in tokens, which maps tokens to caller names. If limiter is
+// nil, requests are not rate limited.
+func newServer(checker *gokismet.Checker, tokens map[string]string, limiter *rateLimiter, logger *slog.Logger) *server {
+	return &server{
+		checker:  checker,
+		pipeline: gokismet.NewPipeline().Add("akismet", checker),
+		tokens:   tokens,
+		limiter:  limiter,
+		logger:   logger,
+	}
+}
+
+// Handler returns the server's HTTP handler.
+func (s *server) Handler() http.Handler {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.Handle("/v1/check", s.authenticate(http.HandlerFunc(s.handleCheck)))
+	mux.Handle("/v1/report-ham", s.authenticate(s.reportHandler(s.checker.ReportHamContext)))
+	mux.Handle("/v1/report-spam", s.authenticate(s.reportHandler(s.checker.ReportSpamContext)))
+	mux.Handle("/v1/verify", s.authenticate(http.HandlerFunc(s.handleVerify)))
+
+	if s.metrics != nil {
+		mux.Handle("/metrics", s.metrics)
+	}
+
+	if s.proxy {
+		mux.Handle("/1.1/", s.proxyHandler())
+	}
+
+	return s.logRequests(mux)
+}
+
+// A valuesRequest is the JSON body expected by the check
+// and report endpoints.
+type valuesRequest struct {
+	Values map[string]string `json:"values"`
+}
+
+// A checkResponse is the JSON body returned by the check
+// endpoint.
+type checkResponse struct {
+	Status    string `json:"status"`
+	GUID      string `json:"guid,omitempty"`
+	DecidedBy string `json:"decided_by,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// An errorResponse is the JSON body returned when a request
+// fails.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *server) handleCheck(w http.ResponseWriter, r *http.Request) {
+
+	values, ok := readValues(w, r)
+	if !ok {
+		return
+	}
+
+	v, err := s.check(r.Context(), values)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	resp := &checkResponse{
+		Status:    v.Status.String(),
+		DecidedBy: v.DecidedBy,
+		Reason:    v.Reason,
+	}
+	if v.CheckResult != nil {
+		resp.GUID = v.CheckResult.GUID
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// check runs the server's pipeline over a set of key-value
+// pairs. It returns an error if no stage reached a decision.
+func (s *server) check(ctx context.Context, values map[string]string) (*gokismet.Verdict, error) {
+
+	v, err := s.pipeline.Run(ctx, values)
+	if v.Status == gokismet.StatusUnknown {
+		if err == nil {
+			err = errors.New("no decision reached")
+		}
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func (s *server) reportHandler(report func(context.Context, map[string]string) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		values, ok := readValues(w, r)
+		if !ok {
+			return
+		}
+
+		if err := report(r.Context(), values); err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"status": "reported"})
+	})
+}
+
+func (s *server) handleVerify(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	if err := s.checker.VerifyContext(r.Context()); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "verified"})
+}
+
+// authenticate wraps a Handler with API token authentication
+// and rate limiting. Callers pass their token in a standard
+// "Authorization: Bearer" header.
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		auth := r.Header.Get("Authorization")
+		caller, ok := s.tokens[strings.TrimPrefix(auth, "Bearer ")]
+		if !ok || !strings.HasPrefix(auth, "Bearer ") {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, &errorResponse{"invalid API token"})
+			return
+		}
+
+		if !s.allow(r, caller) {
+			writeJSON(w, http.StatusTooManyRequests, &errorResponse{"rate limit exceeded"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow records the authenticated caller for a request and
+// reports whether the caller is within their rate limit.
+func (s *server) allow(r *http.Request, caller string) bool {
+	setCaller(r, caller)
+	return s.limiter == nil || s.limiter.Allow(caller)
+}
+
+// logRequests wraps a Handler with structured access logging.
+func (s *server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		info := &requestInfo{}
+
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+
+		s.logger.Info("request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("remote", r.RemoteAddr),
+			slog.String("caller", info.caller),
+			slog.Int("status", rec.status),
+			slog.Duration("duration", time.Since(start)),
+		)
+	})
+}
+
+// requestInfo collects details about a request for the
+// access log.
+type requestInfo struct {
+	caller string
+}
+
+type requestInfoKey struct{}
+
+// setCaller records the authenticated caller for a request.
+func setCaller(r *http.Request, caller string) {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		info.caller = caller
+	}
+}
+
+// A statusRecorder is a ResponseWriter that keeps track of
+// the HTTP status code.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// readValues decodes the key-value pairs from a check or
+// report request. If the request is invalid, readValues
+// writes an error response and returns false.
+func readValues(w http.ResponseWriter, r *http.Request) (map[string]string, bool) {
+
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return nil, false
+	}
+
+	var req valuesRequest
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, &errorResponse{"invalid JSON: " + err.Error()})
+		return nil, false
+	}
+
+	if len(req.Values) == 0 {
+		writeJSON(w, http.StatusBadRequest, &errorResponse{"no values provided"})
+		return nil, false
+	}
+
+	return req.Values, true
+}
+
+// writeError writes an error response for an error returned
+// by the Checker. Akismet's rejection of our data or API key
+// is reported as a 422. Anything else is treated as a failure
+// to reach Akismet. The details of such failures are logged
+// but not returned, as they may include upstream URLs and
+// other information that callers shouldn't see.
+func (s *server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+
+	var valErr *gokismet.ValError
+	var keyErr *gokismet.KeyError
+
+	if errors.As(err, &keyErr) || errors.As(err, &valErr) {
+		writeJSON(w, http.StatusUnprocessableEntity, &errorResponse{err.Error()})
+		return
+	}
+
+	s.logger.Warn("upstream error",
+		slog.String("path", r.URL.Path),
+		slog.String("error", err.Error()),
+	)
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		writeJSON(w, http.StatusGatewayTimeout, &errorResponse{"akismet request timed out"})
+		return
+	}
+
+	writeJSON(w, http.StatusBadGateway, &errorResponse{"akismet unavailable"})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter) {
+	w.Header().Set("Allow", http.MethodPost)
+	writeJSON(w, http.StatusMethodNotAllowed, &errorResponse{"method not allowed"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepilla/gokismet"
+	"github.com/deepilla/gokismet/rules"
+)
+
+// akismetResponses maps Akismet methods to mock response bodies.
+type akismetResponses map[string]string
+
+// newTestChecker returns a Checker whose HTTP requests are
+// answered with the given mock Akismet responses.
+func newTestChecker(responses akismetResponses) *gokismet.Checker {
+
+	client := gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+
+		body, ok := responses[path.Base(req.URL.Path)]
+		status := http.StatusOK
+		if !ok {
+			status = http.StatusInternalServerError
+		}
+
+		return &http.Response{
+			StatusCode: status,
+			Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			Header:     make(http.Header),
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}, nil
+	})
+
+	return gokismet.NewCheckerClient("123456789abc", "http://example.com", client)
+}
+
+func newTestServer(responses akismetResponses, limiter *rateLimiter) http.Handler {
+	tokens := map[string]string{"secret": "alice"}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return newServer(newTestChecker(responses), tokens, limiter, logger).Handler()
+}
+
+// TestServer verifies the responses returned by the gokismetd
+// endpoints.
+func TestServer(t *testing.T) {
+
+	verified := akismetResponses{
+		"verify-key":    "valid",
+		"comment-check": "true",
+		"submit-ham":    "Thanks for making the web a better place.",
+		"submit-spam":   "Thanks for making the web a better place.",
+	}
+
+	tests := []struct {
+		Responses  akismetResponses
+		Method     string
+		Path       string
+		Token      string
+		Body       string
+		StatusCode int
+		Response   string
+	}{
+		{
+			// Health checks do not require a token.
+			Method:     "GET",
+			Path:       "/health",
+			StatusCode: http.StatusOK,
+			Response:   `{"status":"ok"}`,
+		},
+		{
+			Method:     "POST",
+			Path:       "/v1/check",
+			Body:       `{"values":{"comment_author":"viagra-test-123"}}`,
+			StatusCode: http.StatusUnauthorized,
+			Response:   `{"error":"invalid API token"}`,
+		},
+		{
+			Method:     "POST",
+			Path:       "/v1/check",
+			Token:      "wrong",
+			Body:       `{"values":{"comment_author":"viagra-test-123"}}`,
+			StatusCode: http.StatusUnauthorized,
+			Response:   `{"error":"invalid API token"}`,
+		},
+		{
+			Responses:  verified,
+			Method:     "POST",
+			Path:       "/v1/check",
+			Token:      "secret",
+			Body:       `{"values":{"comment_author":"viagra-test-123"}}`,
+			StatusCode: http.StatusOK,
+			Response:   `{"status":"probable-spam","decided_by":"akismet"}`,
+		},
+		{
+			Responses:  verified,
+			Method:     "GET",
+			Path:       "/v1/check",
+			Token:      "secret",
+			StatusCode: http.StatusMethodNotAllowed,
+			Response:   `{"error":"method not allowed"}`,
+		},
+		{
+			Responses:  verified,
+			Method:     "POST",
+			Path:       "/v1/check",
+			Token:      "secret",
+			Body:       `{"values":{}}`,
+			StatusCode: http.StatusBadRequest,
+			Response:   `{"error":"no values provided"}`,
+		},
+		{
+			Responses:  verified,
+			Method:     "POST",
+			Path:       "/v1/report-ham",
+			Token:      "secret",
+			Body:       `{"values":{"comment_author":"A. Commenter"}}`,
+			StatusCode: http.StatusOK,
+			Response:   `{"status":"reported"}`,
+		},
+		{
+			Responses:  verified,
+			Method:     "POST",
+			Path:       "/v1/report-spam",
+			Token:      "secret",
+			Body:       `{"values":{"comment_author":"A. Commenter"}}`,
+			StatusCode: http.StatusOK,
+			Response:   `{"status":"reported"}`,
+		},
+		{
+			Responses:  verified,
+			Method:     "POST",
+			Path:       "/v1/verify",
+			Token:      "secret",
+			StatusCode: http.StatusOK,
+			Response:   `{"status":"verified"}`,
+		},
+		{
+			// Invalid API key.
+			Responses: akismetResponses{
+				"verify-key": "invalid",
+			},
+			Method:     "POST",
+			Path:       "/v1/verify",
+			Token:      "secret",
+			StatusCode: http.StatusUnprocessableEntity,
+			Response:   `{"error":"key ********9abc not verified: verify-key returned \"invalid\""}`,
+		},
+		{
+			// Akismet unavailable.
+			Responses: akismetResponses{
+				"verify-key": "valid",
+			},
+			Method:     "POST",
+			Path:       "/v1/check",
+			Token:      "secret",
+			Body:       `{"values":{"comment_author":"A. Commenter"}}`,
+			StatusCode: http.StatusBadGateway,
+			Response:   `{"error":"akismet unavailable"}`,
+		},
+	}
+
+	for i, test := range tests {
+
+		handler := newTestServer(test.Responses, nil)
+
+		req := httptest.NewRequest(test.Method, test.Path, strings.NewReader(test.Body))
+		if test.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.Token)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != test.StatusCode {
+			t.Errorf("Test %d: Expected HTTP Status %d, got %d", i+1, test.StatusCode, rec.Code)
+		}
+
+		if got := strings.TrimSpace(rec.Body.String()); got != test.Response {
+			t.Errorf("Test %d: Expected response %s, got %s", i+1, test.Response, got)
+		}
+	}
+}
+
+// TestServer_RateLimit verifies that callers are limited to
+// the configured request rate.
+func TestServer_RateLimit(t *testing.T) {
+
+	limiter := newRateLimiter(1, 2)
+
+	now := time.Date(2016, time.May, 5, 10, 30, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+
+	handler := newTestServer(akismetResponses{"verify-key": "valid"}, limiter)
+
+	expected := []int{
+		http.StatusOK,
+		http.StatusOK,
+		http.StatusTooManyRequests,
+	}
+
+	for i, exp := range expected {
+
+		req := httptest.NewRequest("POST", "/v1/verify", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != exp {
+			t.Errorf("Request %d: Expected HTTP Status %d, got %d", i+1, exp, rec.Code)
+		}
+	}
+
+	// After a second, one more request should be allowed.
+	now = now.Add(time.Second)
+
+	req := httptest.NewRequest("POST", "/v1/verify", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected HTTP Status %d after refill, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+// TestParseTokens verifies the parsing of tokens files.
+func TestParseTokens(t *testing.T) {
+
+	tests := []struct {
+		Input  string
+		Tokens map[string]string
+		Error  string
+	}{
+		{
+			Input: "# Callers\nalice secret1\n\nbob   secret2\n",
+			Tokens: map[string]string{
+				"secret1": "alice",
+				"secret2": "bob",
+			},
+		},
+		{
+			Input: "alice\n",
+			Error: "tokens:1: expected a caller name and token",
+		},
+		{
+			Input: "alice secret\nbob secret\n",
+			Error: "tokens:2: duplicate token",
+		},
+		{
+			Input: "# No callers\n",
+			Error: "tokens: no tokens found",
+		},
+	}
+
+	for i, test := range tests {
+
+		tokens, err := parseTokens("tokens", bufio.NewScanner(strings.NewReader(test.Input)))
+
+		if test.Error != "" {
+			if err == nil || err.Error() != test.Error {
+				t.Errorf("Test %d: Expected error %q, got %v", i+1, test.Error, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("Test %d: Unexpected error %s", i+1, err)
+		}
+
+		if len(tokens) != len(test.Tokens) {
+			t.Errorf("Test %d: Expected %d tokens, got %d", i+1, len(test.Tokens), len(tokens))
+		}
+
+		for k, v := range test.Tokens {
+			if tokens[k] != v {
+				t.Errorf("Test %d: Expected token %q for %q, got %q", i+1, k, v, tokens[k])
+			}
+		}
+	}
+}
+
+// TestServer_Rules verifies that checks decided by local
+// rules are reported without calling Akismet.
+func TestServer_Rules(t *testing.T) {
+
+	rs, err := rules.Parse(strings.NewReader(`{"rules": [
+		{"name": "bad-ips", "type": "ip", "action": "definite-spam", "values": ["203.0.113.0/24"]}
+	]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	// Akismet calls fail, so any successful response must
+	// come from the rules.
+	checker := newTestChecker(nil)
+
+	tokens := map[string]string{"secret": "alice"}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	s := newServer(checker, tokens, nil, logger)
+	s.pipeline = gokismet.NewPipeline().
+		Add("rules", rules.Stage(rs)).
+		Add("akismet", checker)
+
+	handler := s.Handler()
+
+	tests := []struct {
+		Body       string
+		StatusCode int
+		Response   string
+	}{
+		{
+			Body:       `{"values":{"user_ip":"203.0.113.7"}}`,
+			StatusCode: http.StatusOK,
+			Response:   `{"status":"definite-spam","decided_by":"rules","reason":"bad-ips: user_ip 203.0.113.7 is in 203.0.113.0/24"}`,
+		},
+		{
+			Body:       `{"values":{"user_ip":"192.168.0.1"}}`,
+			StatusCode: http.StatusBadGateway,
+		},
+	}
+
+	for i, test := range tests {
+
+		req := httptest.NewRequest("POST", "/v1/check", strings.NewReader(test.Body))
+		req.Header.Set("Authorization", "Bearer secret")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != test.StatusCode {
+			t.Errorf("Test %d: Expected HTTP Status %d, got %d", i+1, test.StatusCode, rec.Code)
+		}
+
+		if test.Response == "" {
+			continue
+		}
+
+		if body := strings.TrimSpace(rec.Body.String()); body != test.Response {
+			t.Errorf("Test %d: Expected response %s, got %s", i+1, test.Response, body)
+		}
+	}
+}
+
+// TestServer_UpstreamError verifies that network errors are
+// logged but not returned to callers, as they include the
+// Akismet URL and therefore the API key.
+func TestServer_UpstreamError(t *testing.T) {
+
+	client := gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, &url.Error{
+			Op:  "Post",
+			URL: req.URL.String(),
+			Err: errors.New("connection refused"),
+		}
+	})
+
+	checker := gokismet.NewCheckerClient("123456789abc", "http://example.com", client)
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+	handler := newServer(checker, map[string]string{"secret": "alice"}, nil, logger).Handler()
+
+	for _, p := range []string{"/v1/check", "/v1/report-spam", "/v1/verify"} {
+
+		req := httptest.NewRequest("POST", p, strings.NewReader(`{"values":{"comment_author":"A. Commenter"}}`))
+		req.Header.Set("Authorization", "Bearer secret")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadGateway {
+			t.Errorf("%s: Expected HTTP Status %d, got %d", p, http.StatusBadGateway, rec.Code)
+		}
+
+		if got, exp := strings.TrimSpace(rec.Body.String()), `{"error":"akismet unavailable"}`; got != exp {
+			t.Errorf("%s: Expected response %s, got %s", p, exp, got)
+		}
+	}
+
+	if !strings.Contains(logs.String(), "connection refused") {
+		t.Errorf("Expected the error to be logged, got %s", logs.String())
+	}
+}
@@ -0,0 +1,98 @@
+package gokismet
+
+import (
+	"context"
+	"sync"
+)
+
+// A callGroup coalesces concurrent spam checks of the same
+// content into a single call.
+type callGroup struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// A call is an in-flight spam check shared by one or more
+// waiters.
+type call struct {
+	done   chan struct{}
+	result *CheckResult
+	err    error
+
+	// waiters is the number of callers still waiting for
+	// the result. It is guarded by callGroup.mu.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+func newCallGroup() *callGroup {
+	return &callGroup{
+		calls: make(map[string]*call),
+	}
+}
+
+// do runs fn for the given key, unless a call for that key
+// is already in flight, in which case it waits for and returns
+// the result of that call. The boolean result reports whether
+// the call was started by another caller.
+//
+// The function runs with a Context that carries the values
+// of the first caller's Context but is only cancelled when
+// every caller has stopped waiting. A caller whose Context is
+// cancelled returns immediately with the Context's error.
+func (g *callGroup) do(ctx context.Context, key string, fn func(context.Context) (*CheckResult, error)) (*CheckResult, bool, error) {
+
+	g.mu.Lock()
+
+	c, ok := g.calls[key]
+	if ok {
+		c.waiters++
+	} else {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+		c = &call{
+			done:    make(chan struct{}),
+			waiters: 1,
+			cancel:  cancel,
+		}
+		g.calls[key] = c
+
+		go func() {
+			c.result, c.err = fn(callCtx)
+
+			g.mu.Lock()
+			g.forget(key, c)
+			g.mu.Unlock()
+
+			cancel()
+			close(c.done)
+		}()
+	}
+
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.result, ok, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// Nobody is waiting for this call any more.
+			// Cancel it so that later callers start afresh.
+			c.cancel()
+			g.forget(key, c)
+		}
+		g.mu.Unlock()
+		return nil, ok, ctx.Err()
+	}
+}
+
+// forget removes a call from the group, provided it hasn't
+// already been replaced by a newer call. The caller must hold
+// g.mu.
+func (g *callGroup) forget(key string, c *call) {
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
@@ -0,0 +1,130 @@
+package gokismet_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/deepilla/gokismet"
+)
+
+// A blockingClient is a mock Client that holds comment-check
+// requests until it is released.
+type blockingClient struct {
+	checks  int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingClient() *blockingClient {
+	return &blockingClient{
+		started: make(chan struct{}, 100),
+		release: make(chan struct{}),
+	}
+}
+
+func (c *blockingClient) Do(req *http.Request) (*http.Response, error) {
+
+	body := "valid"
+
+	if path.Base(req.URL.Path) == "comment-check" {
+		atomic.AddInt32(&c.checks, 1)
+		c.started <- struct{}{}
+
+		select {
+		case <-c.release:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+
+		body = "true"
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+// TestCoalesce verifies that concurrent checks of the same
+// content share a single Akismet request.
+func TestCoalesce(t *testing.T) {
+
+	client := newBlockingClient()
+	ch := gokismet.NewCheckerClient(TestAPIKey, TestSite, client)
+
+	values := map[string]string{
+		"comment_content": "Buy cheap watches",
+	}
+
+	const n = 10
+
+	var wg sync.WaitGroup
+	results := make([]*gokismet.CheckResult, n)
+	errs := make([]error, n)
+
+	for i := 1; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = ch.CheckContext(context.Background(), values)
+		}(i)
+	}
+
+	// Wait for the shared request to start and give the
+	// other goroutines a chance to join it.
+	<-client.started
+	time.Sleep(20 * time.Millisecond)
+
+	// Join the call with a Context that is then cancelled.
+	// The other callers should be unaffected.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		results[0], errs[0] = ch.CheckContext(ctx, values)
+		close(done)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	<-done
+
+	close(client.release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&client.checks); got != 1 {
+		t.Errorf("Expected 1 comment-check request, got %d", got)
+	}
+
+	if errs[0] != context.Canceled {
+		t.Errorf("Expected the cancelled check to return %v, got %v", context.Canceled, errs[0])
+	}
+
+	shared := 0
+
+	for i := 1; i < n; i++ {
+		if errs[i] != nil {
+			t.Errorf("Check %d: Unexpected error %s", i+1, errs[i])
+			continue
+		}
+		if results[i].Shared {
+			shared++
+		}
+		if results[i].Status != gokismet.StatusProbableSpam {
+			t.Errorf("Check %d: Expected Spam Status %q, got %q", i+1,
+				statusToString(gokismet.StatusProbableSpam), statusToString(results[i].Status))
+		}
+	}
+
+	// Only the caller that made the request has an unshared
+	// result.
+	if shared != n-2 {
+		t.Errorf("Expected %d shared results, got %d", n-2, shared)
+	}
+}
@@ -0,0 +1,4 @@
+package gokismet
+
+// Exported for testing.
+var VersionFromBuildInfo = versionFromBuildInfo
@@ -0,0 +1,167 @@
+package gokismet
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// A FakeRule determines the response of a Fake to content
+// containing a particular value.
+type FakeRule struct {
+	// The key to examine, e.g. "comment_author".
+	Key string
+	// The rule matches if the value for Key contains this
+	// string. Matching is case-sensitive.
+	Contains string
+	// The spam status returned when the rule matches.
+	Status SpamStatus
+	// If non-nil, the error returned when the rule matches.
+	Err error
+}
+
+// match reports whether a FakeRule applies to a set of
+// key-value pairs.
+func (r *FakeRule) match(values map[string]string) bool {
+	v, ok := values[r.Key]
+	return ok && strings.Contains(v, r.Contains)
+}
+
+// DefaultFakeRules mirror the test values recognised by
+// Akismet itself.
+var DefaultFakeRules = []FakeRule{
+	{Key: "comment_author", Contains: "viagra-test-123", Status: StatusDefiniteSpam},
+	{Key: "comment_author_email", Contains: "akismet-guaranteed-spam@example.com", Status: StatusDefiniteSpam},
+	{Key: "user_role", Contains: "administrator", Status: StatusHam},
+}
+
+// A Fake is a deterministic SpamChecker for use in tests.
+// It never makes network calls. Instead, it decides the spam
+// status of content using a list of rules. A Fake also keeps
+// track of the reports it receives.
+//
+// The zero value is ready to use and behaves like Akismet's
+// test mode (see DefaultFakeRules). A Fake is safe for
+// concurrent use.
+type Fake struct {
+	// Rules are evaluated in order, and the first rule to
+	// match decides the result of a check. Content that
+	// matches no rules is ham. If Rules is nil, the Fake
+	// uses DefaultFakeRules.
+	Rules []FakeRule
+
+	mu      sync.Mutex
+	checks  int
+	reports []FakeReport
+}
+
+// A FakeReport records a report received by a Fake.
+type FakeReport struct {
+	// True for ReportSpam, false for ReportHam.
+	Spam bool
+	// The reported key-value pairs.
+	Values map[string]string
+}
+
+// Fake implements SpamChecker.
+var _ SpamChecker = (*Fake)(nil)
+
+// Check returns the spam status decided by the Fake's rules.
+func (f *Fake) Check(values map[string]string) (SpamStatus, error) {
+
+	result, err := f.CheckContext(context.Background(), values)
+	if err != nil {
+		return StatusUnknown, err
+	}
+
+	return result.Status, nil
+}
+
+// CheckContext returns a CheckResult decided by the Fake's
+// rules. The result's GUID is derived from the values, so
+// the same content always produces the same GUID.
+func (f *Fake) CheckContext(ctx context.Context, values map[string]string) (*CheckResult, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.checks++
+	f.mu.Unlock()
+
+	rules := f.Rules
+	if rules == nil {
+		rules = DefaultFakeRules
+	}
+
+	status := StatusHam
+
+	for i := range rules {
+		if rules[i].match(values) {
+			if rules[i].Err != nil {
+				return nil, rules[i].Err
+			}
+			status = rules[i].Status
+			break
+		}
+	}
+
+	return &CheckResult{
+		Status: status,
+		Values: values,
+		GUID:   "fake-" + HashValues(values, nil)[:16],
+	}, nil
+}
+
+// ReportHam records a ham report.
+func (f *Fake) ReportHam(values map[string]string) error {
+	return f.ReportHamContext(context.Background(), values)
+}
+
+// ReportHamContext records a ham report.
+func (f *Fake) ReportHamContext(ctx context.Context, values map[string]string) error {
+	return f.report(ctx, false, values)
+}
+
+// ReportSpam records a spam report.
+func (f *Fake) ReportSpam(values map[string]string) error {
+	return f.ReportSpamContext(context.Background(), values)
+}
+
+// ReportSpamContext records a spam report.
+func (f *Fake) ReportSpamContext(ctx context.Context, values map[string]string) error {
+	return f.report(ctx, true, values)
+}
+
+func (f *Fake) report(ctx context.Context, spam bool, values map[string]string) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.reports = append(f.reports, FakeReport{
+		Spam:   spam,
+		Values: values,
+	})
+
+	return nil
+}
+
+// Checks returns the number of checks the Fake has made.
+func (f *Fake) Checks() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.checks
+}
+
+// Reports returns the reports received by the Fake, in the
+// order they were made.
+func (f *Fake) Reports() []FakeReport {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeReport(nil), f.reports...)
+}
@@ -0,0 +1,153 @@
+package gokismet_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/deepilla/gokismet"
+)
+
+// TestFake verifies that Fakes decide spam status using
+// their rules.
+func TestFake(t *testing.T) {
+
+	errDown := errors.New("service unavailable")
+
+	custom := []gokismet.FakeRule{
+		{Key: "comment_content", Contains: "casino", Status: gokismet.StatusProbableSpam},
+		{Key: "comment_content", Contains: "outage", Err: errDown},
+	}
+
+	tests := []struct {
+		Rules  []gokismet.FakeRule
+		Values map[string]string
+		Status gokismet.SpamStatus
+		Err    error
+	}{
+		{
+			Values: map[string]string{"comment_author": "A. Commenter"},
+			Status: gokismet.StatusHam,
+		},
+		{
+			Values: map[string]string{"comment_author": "viagra-test-123"},
+			Status: gokismet.StatusDefiniteSpam,
+		},
+		{
+			Values: map[string]string{"comment_author_email": "akismet-guaranteed-spam@example.com"},
+			Status: gokismet.StatusDefiniteSpam,
+		},
+		{
+			// Custom rules replace the default rules.
+			Rules:  custom,
+			Values: map[string]string{"comment_author": "viagra-test-123"},
+			Status: gokismet.StatusHam,
+		},
+		{
+			Rules:  custom,
+			Values: map[string]string{"comment_content": "Visit my online casino"},
+			Status: gokismet.StatusProbableSpam,
+		},
+		{
+			Rules:  custom,
+			Values: map[string]string{"comment_content": "Simulate an outage"},
+			Status: gokismet.StatusUnknown,
+			Err:    errDown,
+		},
+	}
+
+	for i, test := range tests {
+
+		fake := &gokismet.Fake{Rules: test.Rules}
+
+		status, err := fake.Check(test.Values)
+
+		if status != test.Status {
+			t.Errorf("Test %d: Expected Spam Status %q, got %q", i+1,
+				statusToString(test.Status), statusToString(status))
+		}
+
+		if err != test.Err {
+			t.Errorf("Test %d: Expected error %v, got %v", i+1, test.Err, err)
+		}
+
+		if fake.Checks() != 1 {
+			t.Errorf("Test %d: Expected 1 check, got %d", i+1, fake.Checks())
+		}
+	}
+}
+
+// TestFake_GUID verifies that Fakes generate the same GUID
+// for the same content.
+func TestFake_GUID(t *testing.T) {
+
+	fake := &gokismet.Fake{}
+	ctx := context.Background()
+
+	r1, _ := fake.CheckContext(ctx, map[string]string{"comment_content": "Hello"})
+	r2, _ := fake.CheckContext(ctx, map[string]string{"comment_content": "Hello"})
+	r3, _ := fake.CheckContext(ctx, map[string]string{"comment_content": "Goodbye"})
+
+	if r1.GUID == "" || r1.GUID != r2.GUID {
+		t.Errorf("Expected matching GUIDs, got %q and %q", r1.GUID, r2.GUID)
+	}
+
+	if r1.GUID == r3.GUID {
+		t.Errorf("Expected different GUIDs for different content, got %q", r1.GUID)
+	}
+}
+
+// TestFake_Reports verifies that Fakes record the reports
+// they receive.
+func TestFake_Reports(t *testing.T) {
+
+	var fake gokismet.Fake
+
+	ham := map[string]string{"comment_content": "ham"}
+	spam := map[string]string{"comment_content": "spam"}
+
+	if err := fake.ReportHam(ham); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if err := fake.ReportSpam(spam); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := fake.ReportSpamContext(ctx, spam); err != context.Canceled {
+		t.Errorf("Expected error %v, got %v", context.Canceled, err)
+	}
+
+	reports := fake.Reports()
+	if len(reports) != 2 {
+		t.Fatalf("Expected 2 reports, got %d", len(reports))
+	}
+
+	if reports[0].Spam || reports[0].Values["comment_content"] != "ham" {
+		t.Errorf("Expected a ham report, got %+v", reports[0])
+	}
+
+	if !reports[1].Spam || reports[1].Values["comment_content"] != "spam" {
+		t.Errorf("Expected a spam report, got %+v", reports[1])
+	}
+}
+
+// TestCheckStage verifies that any SpamChecker can be used
+// as a Pipeline stage.
+func TestCheckStage(t *testing.T) {
+
+	var sc gokismet.SpamChecker = &gokismet.Fake{}
+
+	v, err := gokismet.NewPipeline().
+		Add("fake", gokismet.CheckStage(sc)).
+		Run(context.Background(), map[string]string{"comment_author": "viagra-test-123"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if v.Status != gokismet.StatusDefiniteSpam || v.CheckResult == nil {
+		t.Errorf("Expected definite spam with a CheckResult, got %+v", v)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/deepilla/gokismet
+
+go 1.21
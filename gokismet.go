@@ -13,11 +13,14 @@ For background on the Akismet API, see https://akismet.com/development/api/#deta
 package gokismet
 
 import (
-	"errors"
+	"context"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -61,6 +64,7 @@ const (
 const (
 	headerDebugHelp = "X-Akismet-Debug-Help"
 	headerProTip    = "X-Akismet-Pro-Tip"
+	headerGUID      = "X-Akismet-Guid"
 )
 
 // Akismet's "pervasive" spam indicator, returned in the
@@ -68,9 +72,10 @@ const (
 const proTipDiscard = "discard"
 
 // UserAgent identifies gokismet to the Akismet API. By default,
-// all API calls include this value in the HTTP request header.
-// Use a custom Client to override this behaviour (see ClientFunc
-// for an example).
+// all API calls include this value in the HTTP request header
+// (with the version number taken from the module's build info,
+// where available). Use the WithApplication option to identify
+// your application as well.
 const UserAgent = "Gokismet/3.0"
 
 // A SpamStatus is the result of a spam check. It represents
@@ -101,6 +106,21 @@ const (
 	StatusDefiniteSpam
 )
 
+// String returns a short, lowercase description of a
+// SpamStatus, e.g. "ham" or "definite-spam".
+func (s SpamStatus) String() string {
+	switch s {
+	case StatusHam:
+		return "ham"
+	case StatusProbableSpam:
+		return "probable-spam"
+	case StatusDefiniteSpam:
+		return "definite-spam"
+	default:
+		return "unknown"
+	}
+}
+
 // A Client is responsible for executing HTTP requests.
 // Its interface is satisfied by http.Client. Provide
 // your own implementation to intercept gokismet's
@@ -125,9 +145,30 @@ type Checker struct {
 	key      string
 	site     string
 	client   Client
-	verified bool
+	verified uint32
+
+	cache     Cache
+	keyFilter *KeyFilter
+
+	callsOnce sync.Once
+	calls     *callGroup
+
+	observer Observer
+	retries  int
+	backoff  time.Duration
+
+	logger       *slog.Logger
+	logVerbosity LogVerbosity
+	redaction    *RedactionPolicy
+
+	userAgent string
+	provider  *Provider
 }
 
+// An Option configures optional Checker behaviour. Options
+// are passed to NewChecker and NewCheckerClient.
+type Option func(*Checker)
+
 // NewChecker returns a Checker that uses the given API key
 // and website as credentials for the Akismet service. These
 // credentials are verified automatically on the first call
@@ -136,25 +177,33 @@ type Checker struct {
 // Checkers created with NewChecker use the default HTTP
 // client to make calls to the Akismet API. To provide your
 // own Client, use the NewCheckerClient function.
-func NewChecker(key string, site string) *Checker {
-	return NewCheckerClient(key, site, nil)
+func NewChecker(key string, site string, opts ...Option) *Checker {
+	return NewCheckerClient(key, site, nil, opts...)
 }
 
 // NewCheckerClient is like NewChecker except the returned
 // Checker uses the provided Client to make calls to the
 // Akismet API. If the provided Client is nil, the default
 // HTTP client is used instead.
-func NewCheckerClient(key string, site string, client Client) *Checker {
+func NewCheckerClient(key string, site string, client Client, opts ...Option) *Checker {
 
 	if client == nil {
 		client = http.DefaultClient
 	}
 
-	return &Checker{
-		key:    key,
-		site:   site,
-		client: client,
+	ch := &Checker{
+		key:       key,
+		site:      site,
+		client:    client,
+		userAgent: libraryUserAgent(),
+		provider:  AkismetProvider,
 	}
+
+	for _, opt := range opts {
+		opt(ch)
+	}
+
+	return ch
 }
 
 // Check takes content in the form of key-value pairs and
@@ -168,31 +217,120 @@ func NewCheckerClient(key string, site string, client Client) *Checker {
 // with, the faster and more accurate its spam detection.
 func (ch *Checker) Check(values map[string]string) (SpamStatus, error) {
 
-	if !ch.verified {
-		if err := ch.verify(); err != nil {
-			return StatusUnknown, err
+	result, err := ch.CheckContext(context.Background(), values)
+	if err != nil {
+		return StatusUnknown, err
+	}
+
+	return result.Status, nil
+}
+
+// CheckContext is like Check except that it takes a Context
+// to control the lifetime of the HTTP request, and it returns
+// a CheckResult containing the details of Akismet's response.
+// If an error occurs, CheckContext returns a nil CheckResult
+// and a non-nil error.
+//
+// Concurrent calls with the same key-value pairs (as defined
+// by HashValues) are coalesced into a single Akismet request,
+// the result of which is shared by all callers. Cancelling one
+// caller's Context does not cancel the shared request unless
+// all of the callers have gone away.
+func (ch *Checker) CheckContext(ctx context.Context, values map[string]string) (*CheckResult, error) {
+
+	key := HashValues(values, ch.keyFilter)
+
+	if ch.cache != nil {
+		if result, ok := ch.cache.Get(key); ok {
+			hit := *result
+			hit.Values = mergeStringMaps(values)
+			hit.Cached = true
+
+			info := ch.startCall(methodCheck)
+			info.Cached = true
+			ch.finishCall(ctx, info, hit.Status, nil)
+
+			return &hit, nil
 		}
-		ch.verified = true
 	}
 
-	url := buildURL(methodCheck, ch.key)
+	ch.callsOnce.Do(func() {
+		ch.calls = newCallGroup()
+	})
+
+	// Concurrent checks of the same content share a single
+	// call to Akismet.
+	shared, joined, err := ch.calls.do(ctx, key, func(ctx context.Context) (*CheckResult, error) {
 
-	body, header, err := ch.call(url, values)
+		result, err := ch.check(ctx, values)
+		if err != nil {
+			return nil, err
+		}
+
+		if ch.cache != nil {
+			ch.cache.Add(key, result)
+		}
+
+		return result, nil
+	})
 	if err != nil {
-		return StatusUnknown, err
+		return nil, err
+	}
+
+	// Give each caller their own copy of the result.
+	result := *shared
+	result.Values = mergeStringMaps(values)
+	result.Shared = joined
+
+	return &result, nil
+}
+
+// check handles the heavy lifting for the CheckContext method.
+func (ch *Checker) check(ctx context.Context, values map[string]string) (result *CheckResult, err error) {
+
+	if ch.provider.path(methodCheck) == "" {
+		return nil, ErrUnsupported
+	}
+
+	if err := ch.ensureVerified(ctx); err != nil {
+		return nil, err
+	}
+
+	info := ch.startCall(methodCheck)
+	defer func() {
+		status := StatusUnknown
+		if result != nil {
+			status = result.Status
+		}
+		ch.finishCall(ctx, info, status, err)
+	}()
+
+	p := ch.provider
+
+	body, header, err := ch.call(ctx, info, methodCheck, values)
+	if err != nil {
+		return nil, err
+	}
+
+	result = &CheckResult{
+		Values: values,
+		GUID:   header.Get(p.HeaderGUID),
+		Header: header,
 	}
 
 	switch string(body) {
-	case responseHam:
-		return StatusHam, nil
-	case responseSpam:
-		if header.Get(headerProTip) == proTipDiscard {
-			return StatusDefiniteSpam, nil
+	case p.ResponseHam:
+		result.Status = StatusHam
+	case p.ResponseSpam:
+		result.Status = StatusProbableSpam
+		if tip := header.Get(p.HeaderProTip); tip != "" && tip == p.ProTipDiscard {
+			result.Status = StatusDefiniteSpam
 		}
-		return StatusProbableSpam, nil
 	default:
-		return StatusUnknown, newValError(methodCheck, string(body), header)
+		return nil, newValError(methodCheck, string(body), header.Get(p.HeaderDebugHelp))
 	}
+
+	return result, nil
 }
 
 // ReportHam notifies Akismet of legitimate content incorrectly
@@ -200,7 +338,13 @@ func (ch *Checker) Check(values map[string]string) (SpamStatus, error) {
 // content in the form of key-value pairs. For best results,
 // provide as many of the original values as possible.
 func (ch *Checker) ReportHam(values map[string]string) error {
-	return ch.report(methodReportHam, values)
+	return ch.report(context.Background(), methodReportHam, values)
+}
+
+// ReportHamContext is like ReportHam except that it takes
+// a Context to control the lifetime of the HTTP request.
+func (ch *Checker) ReportHamContext(ctx context.Context, values map[string]string) error {
+	return ch.report(ctx, methodReportHam, values)
 }
 
 // ReportSpam notifies Akismet of spam that the Check method
@@ -208,101 +352,203 @@ func (ch *Checker) ReportHam(values map[string]string) error {
 // of key-value pairs. For best results, provide as many of the
 // original values as possible.
 func (ch *Checker) ReportSpam(values map[string]string) error {
-	return ch.report(methodReportSpam, values)
+	return ch.report(context.Background(), methodReportSpam, values)
+}
+
+// ReportSpamContext is like ReportSpam except that it takes
+// a Context to control the lifetime of the HTTP request.
+func (ch *Checker) ReportSpamContext(ctx context.Context, values map[string]string) error {
+	return ch.report(ctx, methodReportSpam, values)
 }
 
 // report handles the heavy lifting for the ReportHam and
 // ReportSpam methods.
-func (ch *Checker) report(method string, values map[string]string) error {
+func (ch *Checker) report(ctx context.Context, method string, values map[string]string) (err error) {
 
-	if !ch.verified {
-		if err := ch.verify(); err != nil {
-			return err
-		}
-		ch.verified = true
+	if ch.provider.path(method) == "" {
+		return ErrUnsupported
+	}
+
+	if err := ch.ensureVerified(ctx); err != nil {
+		return err
 	}
 
-	url := buildURL(method, ch.key)
+	info := ch.startCall(method)
+	defer func() {
+		ch.finishCall(ctx, info, StatusUnknown, err)
+	}()
 
-	body, header, err := ch.call(url, values)
+	body, header, err := ch.call(ctx, info, method, values)
 	if err != nil {
 		return err
 	}
 
-	if string(body) != responseReported {
-		return newValError(method, string(body), header)
+	if string(body) != ch.provider.ResponseReported {
+		return newValError(method, string(body), header.Get(ch.provider.HeaderDebugHelp))
+	}
+
+	return nil
+}
+
+// Verify authenticates a Checker's API key and website with
+// Akismet. The other Checker methods call Verify automatically
+// so there's usually no need to call it directly. It's useful
+// for validating credentials ahead of time, e.g. on startup.
+// If verification fails, the returned error is typically a
+// KeyError.
+func (ch *Checker) Verify() error {
+	return ch.VerifyContext(context.Background())
+}
+
+// VerifyContext is like Verify except that it takes a Context
+// to control the lifetime of the HTTP request.
+func (ch *Checker) VerifyContext(ctx context.Context) error {
+
+	if err := ch.verify(ctx); err != nil {
+		return err
 	}
 
+	atomic.StoreUint32(&ch.verified, 1)
 	return nil
 }
 
+// ensureVerified verifies a Checker's credentials if they
+// have not been verified already.
+func (ch *Checker) ensureVerified(ctx context.Context) error {
+
+	if atomic.LoadUint32(&ch.verified) == 1 {
+		return nil
+	}
+
+	return ch.VerifyContext(ctx)
+}
+
 // verify authenticates a Checker's API key and website.
-func (ch *Checker) verify() error {
+// If the Checker's Provider does not support verification,
+// the credentials are assumed to be valid.
+func (ch *Checker) verify(ctx context.Context) (err error) {
+
+	p := ch.provider
+	if p.VerifyPath == "" {
+		return nil
+	}
 
-	// The verify-key endpoint is not qualified with an
-	// API key so we pass a blank key to buildUrl.
-	url := buildURL(methodVerify, "")
+	info := ch.startCall(methodVerify)
+	defer func() {
+		ch.finishCall(ctx, info, StatusUnknown, err)
+	}()
 
 	values := map[string]string{
-		paramKey:  ch.key,
 		paramSite: ch.site,
 	}
 
-	body, header, err := ch.call(url, values)
+	// Akismet's verify-key endpoint is not qualified with
+	// an API key, so the key is passed as a parameter.
+	if p.Auth == AuthKeyHost {
+		values[paramKey] = ch.key
+	}
+
+	body, header, err := ch.call(ctx, info, methodVerify, values)
 	if err != nil {
 		return err
 	}
 
-	if string(body) != responseVerified {
-		return newKeyError(ch.key, ch.site, string(body), header)
+	if string(body) != p.ResponseVerified {
+		return newKeyError(ch.key, ch.site, string(body), header.Get(p.HeaderDebugHelp))
 	}
 
 	return nil
 }
 
-// call makes a request to an Akismet endpoint with the given
-// parameters and returns the response body and headers.
-func (ch *Checker) call(url string, params map[string]string) ([]byte, http.Header, error) {
+// call makes a request to an API method with the given
+// parameters and returns the response body and headers. Failed
+// requests are retried according to the Checker's retry policy.
+// The CallInfo is updated with the number of attempts made and
+// the outcome of the last attempt.
+func (ch *Checker) call(ctx context.Context, info *CallInfo, method string, params map[string]string) ([]byte, http.Header, error) {
+
+	for {
+		info.Attempts++
+
+		body, header, err := ch.callOnce(ctx, info, method, params)
+		if err == nil || !info.retryable() || info.Attempts > ch.retries {
+			return body, header, err
+		}
+
+		// Wait before retrying, doubling the delay after
+		// each failed attempt.
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(ch.backoff << uint(info.Attempts-1)):
+		}
+	}
+}
+
+// callOnce makes a single request to an API method.
+func (ch *Checker) callOnce(ctx context.Context, info *CallInfo, method string, params map[string]string) ([]byte, http.Header, error) {
+
+	info.ErrorClass = ErrorClassNone
+	info.HTTPStatus = 0
 
 	defaultParams := map[string]string{
 		paramSite: ch.site,
 	}
 
-	req, err := newRequest(url, mergeStringMaps(defaultParams, params))
+	if ch.provider.Auth == AuthKeyParam {
+		defaultParams[paramKey] = ch.key
+	}
+
+	params = mergeStringMaps(defaultParams, params)
+
+	key := ch.key
+	if method == methodVerify {
+		key = ""
+	}
+
+	req, err := newRequest(ch.provider.url(method, key), params, ch.userAgent)
 	if err != nil {
 		return nil, nil, err
 	}
+	req = req.WithContext(ctx)
+
+	if ch.provider.Auth == AuthBearer {
+		req.Header.Set("Authorization", "Bearer "+ch.key)
+	}
+
+	ch.logRequest(ctx, info, params)
 
 	resp, err := ch.client.Do(req)
 	if err != nil {
-		return nil, nil, err
+		info.ErrorClass = ErrorClassNetwork
+		if ctx.Err() != nil {
+			info.ErrorClass = ErrorClassCanceled
+		}
+		return nil, nil, maskError(err, ch.key)
 	}
 	defer resp.Body.Close()
 
+	info.HTTPStatus = resp.StatusCode
+
 	if resp.StatusCode != http.StatusOK {
-		return nil, nil, errors.New(resp.Status + " returned from " + url)
+		ch.logResponse(ctx, info, resp, nil)
+		info.ErrorClass = ErrorClassHTTP
+		return nil, nil, newHTTPError(info.Method, resp)
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		info.ErrorClass = ErrorClassNetwork
+	}
 
-	return body, resp.Header, err
-}
-
-// buildURL returns the Akismet endpoint URL for the
-// given API method. If a non-empty API key is provided,
-// the hostname is qualified with the key.
-func buildURL(method string, key string) string {
+	ch.logResponse(ctx, info, resp, body)
 
-	s := "https://"
-	if key != "" {
-		s += key + "."
-	}
-	return s + "rest.akismet.com/1.1/" + method
+	return body, resp.Header, err
 }
 
 // newRequest creates an HTTP Request from the given
-// endpoint URL and query parameters.
-func newRequest(url string, params map[string]string) (*http.Request, error) {
+// endpoint URL, query parameters and user agent.
+func newRequest(url string, params map[string]string, userAgent string) (*http.Request, error) {
 
 	req, err := http.NewRequest("POST", url, strings.NewReader(encodeParams(params)))
 	if err != nil {
@@ -310,7 +556,7 @@ func newRequest(url string, params map[string]string) (*http.Request, error) {
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("User-Agent", userAgent)
 
 	return req, nil
 }
@@ -354,6 +600,27 @@ func mergeStringMaps(maps ...map[string]string) map[string]string {
 	return values
 }
 
+// A CheckResult contains the details of a spam check.
+type CheckResult struct {
+	// Akismet's opinion on the spaminess of the content.
+	Status SpamStatus
+	// A copy of the key-value pairs that were checked.
+	Values map[string]string
+	// Akismet's unique identifier for the check (may be
+	// empty).
+	GUID string
+	// The HTTP headers returned by Akismet.
+	Header http.Header
+	// Was the result served from a Cache?
+	Cached bool
+	// Was the result shared with a concurrent check of the
+	// same content (see CheckContext)?
+	Shared bool
+	// The individual results from each provider, if the
+	// result was produced by a MultiChecker.
+	Verdicts []ProviderVerdict
+}
+
 // A ValError is the error returned by the Checker methods
 // if Akismet returns an unexpected response. Typically it
 // indicates a problem with the data sent to Akismet, e.g.
@@ -367,11 +634,11 @@ type ValError struct {
 	Hint string
 }
 
-func newValError(method string, response string, header http.Header) *ValError {
+func newValError(method string, response string, hint string) *ValError {
 	return &ValError{
 		Method:   method,
 		Response: response,
-		Hint:     header.Get(headerDebugHelp),
+		Hint:     hint,
 	}
 }
 
@@ -413,16 +680,41 @@ type KeyError struct {
 	*ValError
 }
 
-func newKeyError(key string, site string, response string, header http.Header) *KeyError {
+func newKeyError(key string, site string, response string, hint string) *KeyError {
 	return &KeyError{
 		Key:      key,
 		Site:     site,
-		ValError: newValError(methodVerify, response, header),
+		ValError: newValError(methodVerify, response, hint),
 	}
 }
 
+// Error returns a description of the error. To avoid leaking
+// credentials into logs, the API key is masked.
 func (e KeyError) Error() string {
-	return "key " + e.Key + " not verified: " + e.ValError.Error()
+	return "key " + MaskKey(e.Key) + " not verified: " + e.ValError.Error()
+}
+
+// An HTTPError is the error returned by the Checker methods
+// if Akismet responds with an HTTP status other than 200 OK.
+type HTTPError struct {
+	// The Akismet method being called.
+	Method string
+	// The HTTP status code, e.g. 500.
+	StatusCode int
+	// The HTTP status, e.g. "500 Internal Server Error".
+	Status string
+}
+
+func newHTTPError(method string, resp *http.Response) *HTTPError {
+	return &HTTPError{
+		Method:     method,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+	}
+}
+
+func (e HTTPError) Error() string {
+	return e.Status + " returned from " + e.Method
 }
 
 // A Comment represents a chunk of content to be checked
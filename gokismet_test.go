@@ -648,7 +648,11 @@ func sharedResponseTests(method string) []ResponseTest {
 					StatusCode: http.StatusMovedPermanently,
 				},
 			},
-			Error: errors.New("301 Moved Permanently returned from https://rest.akismet.com/1.1/verify-key"),
+			Error: &gokismet.HTTPError{
+				Method:     "verify-key",
+				StatusCode: http.StatusMovedPermanently,
+				Status:     "301 Moved Permanently",
+			},
 		},
 		{
 			// API key not verified.
@@ -701,7 +705,11 @@ func sharedResponseTests(method string) []ResponseTest {
 					StatusCode: http.StatusInternalServerError,
 				},
 			},
-			Error: errors.New("500 Internal Server Error returned from https://123456789abc.rest.akismet.com/1.1/" + method),
+			Error: &gokismet.HTTPError{
+				Method:     method,
+				StatusCode: http.StatusInternalServerError,
+				Status:     "500 Internal Server Error",
+			},
 		},
 		{
 			// Unexpected return value from Akismet call.
@@ -946,20 +954,20 @@ func TestError_KeyError(t *testing.T) {
 		Expected string
 	}{
 		{
-			Expected: `key 123456789abc not verified: verify-key returned an empty string`,
+			Expected: `key ********9abc not verified: verify-key returned an empty string`,
 		},
 		{
 			Hint:     "A helpful diagnostic message",
-			Expected: `key 123456789abc not verified: verify-key returned an empty string (A helpful diagnostic message)`,
+			Expected: `key ********9abc not verified: verify-key returned an empty string (A helpful diagnostic message)`,
 		},
 		{
 			Response: "invalid",
-			Expected: `key 123456789abc not verified: verify-key returned "invalid"`,
+			Expected: `key ********9abc not verified: verify-key returned "invalid"`,
 		},
 		{
 			Response: "invalid",
 			Hint:     "A helpful diagnostic message",
-			Expected: `key 123456789abc not verified: verify-key returned "invalid" (A helpful diagnostic message)`,
+			Expected: `key ********9abc not verified: verify-key returned "invalid" (A helpful diagnostic message)`,
 		},
 	}
 
@@ -981,6 +989,23 @@ func TestError_KeyError(t *testing.T) {
 	}
 }
 
+// TestError_HTTPError tests string formatting for the HTTPError
+// type.
+func TestError_HTTPError(t *testing.T) {
+
+	err := gokismet.HTTPError{
+		Method:     "comment-check",
+		StatusCode: http.StatusInternalServerError,
+		Status:     "500 Internal Server Error",
+	}
+
+	exp := `500 Internal Server Error returned from comment-check`
+
+	if got := err.Error(); got != exp {
+		t.Errorf("Expected %q, got %q", exp, got)
+	}
+}
+
 // An AkismetTest defines a test case for the TestAkismet
 // functions.
 type AkismetTest struct {
@@ -1192,6 +1217,13 @@ func compareError(exp, got error) []error {
 		}
 		return compareValError(exp, err)
 
+	case *gokismet.HTTPError:
+		err, ok := got.(*gokismet.HTTPError)
+		if !ok {
+			return sliceErrorf("Expected an HTTPError, got %T %s", got, got)
+		}
+		return compareHTTPError(exp, err)
+
 	default:
 		if isErrorString(exp) {
 			if !isErrorString(got) {
@@ -1223,6 +1255,25 @@ func compareValError(exp, got *gokismet.ValError) []error {
 	return errors
 }
 
+func compareHTTPError(exp, got *gokismet.HTTPError) []error {
+
+	var errors []error
+
+	if got.Method != exp.Method {
+		errors = append(errors, fmt.Errorf("Expected an HTTPError with Method %q, got %q", exp.Method, got.Method))
+	}
+
+	if got.StatusCode != exp.StatusCode {
+		errors = append(errors, fmt.Errorf("Expected an HTTPError with StatusCode %d, got %d", exp.StatusCode, got.StatusCode))
+	}
+
+	if got.Status != exp.Status {
+		errors = append(errors, fmt.Errorf("Expected an HTTPError with Status %q, got %q", exp.Status, got.Status))
+	}
+
+	return errors
+}
+
 func compareKeyError(exp, got *gokismet.KeyError) []error {
 
 	var errors []error
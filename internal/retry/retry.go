@@ -0,0 +1,96 @@
+/*
+Package retry implements the retry and polling logic shared
+by gokismet's background workers, outbox.Outbox and
+recheck.Scheduler.
+*/
+package retry
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/deepilla/gokismet"
+)
+
+// A Backoff computes the delay before retrying a failed
+// operation. The delay starts at Base and doubles after each
+// failure, up to Max.
+type Backoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// Delay returns the delay after the given number of failed
+// attempts.
+func (b Backoff) Delay(attempts int) time.Duration {
+
+	d := b.Base
+	for i := 1; i < attempts && d < b.Max; i++ {
+		d *= 2
+	}
+
+	if d > b.Max {
+		d = b.Max
+	}
+
+	return d
+}
+
+// Permanent reports whether an error from a SpamChecker will
+// not be fixed by retrying: Akismet rejected the request or
+// the API key, or the provider doesn't support the method.
+func Permanent(err error) bool {
+
+	var valErr *gokismet.ValError
+	var keyErr *gokismet.KeyError
+
+	return errors.As(err, &valErr) ||
+		errors.As(err, &keyErr) ||
+		errors.Is(err, gokismet.ErrUnsupported)
+}
+
+// A Poller runs a function at regular intervals, and early
+// when woken. The zero value is not usable; call NewPoller.
+type Poller struct {
+	wake chan struct{}
+}
+
+// NewPoller returns a new Poller.
+func NewPoller() *Poller {
+	return &Poller{
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Wake makes a running Poller call its function without
+// waiting for the next interval. It never blocks.
+func (p *Poller) Wake() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run calls fn immediately, then every interval and whenever
+// the Poller is woken, until ctx is done. It returns
+// ctx.Err(). Errors from fn are passed to onError, if it is
+// non-nil, unless they were caused by ctx being done.
+func (p *Poller) Run(ctx context.Context, interval time.Duration, fn func(context.Context) error, onError func(error)) error {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := fn(ctx); err != nil && ctx.Err() == nil && onError != nil {
+			onError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-p.wake:
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package retry_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/deepilla/gokismet"
+	"github.com/deepilla/gokismet/internal/retry"
+)
+
+// TestBackoff verifies that delays double after each failure
+// and are capped at the maximum.
+func TestBackoff(t *testing.T) {
+
+	b := retry.Backoff{
+		Base: time.Second,
+		Max:  5 * time.Second,
+	}
+
+	expected := []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		5 * time.Second,
+		5 * time.Second,
+	}
+
+	for i, exp := range expected {
+		if d := b.Delay(i + 1); d != exp {
+			t.Errorf("Attempt %d: Expected delay %s, got %s", i+1, exp, d)
+		}
+	}
+}
+
+// TestPermanent verifies which errors are not worth retrying.
+func TestPermanent(t *testing.T) {
+
+	tests := []struct {
+		Err       error
+		Permanent bool
+	}{
+		{
+			Err:       &gokismet.ValError{Method: "comment-check", Response: "invalid"},
+			Permanent: true,
+		},
+		{
+			Err:       fmt.Errorf("wrapped: %w", &gokismet.KeyError{Key: "abc123", ValError: &gokismet.ValError{Method: "verify-key", Response: "invalid"}}),
+			Permanent: true,
+		},
+		{
+			Err:       gokismet.ErrUnsupported,
+			Permanent: true,
+		},
+		{
+			Err: errors.New("connection reset"),
+		},
+	}
+
+	for i, test := range tests {
+		if got := retry.Permanent(test.Err); got != test.Permanent {
+			t.Errorf("Test %d: Expected %v, got %v", i+1, test.Permanent, got)
+		}
+	}
+}
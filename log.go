@@ -0,0 +1,293 @@
+package gokismet
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// A LogVerbosity controls how much detail a Checker logs.
+type LogVerbosity int
+
+// Log verbosity levels. Each level includes the output of
+// the levels before it.
+const (
+	// LogCalls logs one line per Akismet call, with the
+	// method, outcome and duration.
+	LogCalls LogVerbosity = iota
+
+	// LogRequests also logs each HTTP request and response,
+	// including retries.
+	LogRequests
+
+	// LogValues also logs the key-value pairs sent to
+	// Akismet and the response bodies and headers. Values
+	// are redacted according to the Checker's
+	// RedactionPolicy.
+	LogValues
+)
+
+// LogOptions configures the logging behaviour of a Checker.
+type LogOptions struct {
+	// How much detail to log. Defaults to LogCalls.
+	Verbosity LogVerbosity
+	// How to redact sensitive values. If nil, the API key,
+	// email addresses and IP addresses are masked.
+	Redaction *RedactionPolicy
+}
+
+// WithLogger returns an Option that logs a Checker's Akismet
+// calls to the given Logger. Call summaries are logged at
+// level Info (or Warn, for failed calls). Request details are
+// logged at level Debug. If opts is nil, default options are
+// used.
+func WithLogger(logger *slog.Logger, opts *LogOptions) Option {
+
+	if opts == nil {
+		opts = &LogOptions{}
+	}
+
+	return func(ch *Checker) {
+		ch.logger = logger
+		ch.logVerbosity = opts.Verbosity
+		ch.redaction = opts.Redaction
+	}
+}
+
+// A Redaction specifies how a sensitive value is obscured.
+type Redaction int
+
+// Redaction methods.
+const (
+	// RedactMask replaces a value with a placeholder. API
+	// keys keep their last four characters so that they
+	// can still be identified.
+	RedactMask Redaction = iota
+
+	// RedactHash replaces a value with a truncated SHA-256
+	// hash, so that repeated values can be correlated
+	// without being revealed.
+	RedactHash
+
+	// RedactNone leaves a value unchanged.
+	RedactNone
+)
+
+// redactedValue is the placeholder for masked values.
+const redactedValue = "[redacted]"
+
+// A RedactionPolicy determines how sensitive values are
+// obscured in log output. The zero value masks the API key,
+// email addresses and IP addresses.
+type RedactionPolicy struct {
+	// How to redact the API key.
+	Key Redaction
+	// How to redact email addresses (comment_author_email).
+	Email Redaction
+	// How to redact IP addresses (user_ip).
+	IP Redaction
+	// How to redact any other keys, e.g. comment_author.
+	// Keys that don't appear here are left unchanged.
+	Fields map[string]Redaction
+	// Salt is prepended to values before hashing, making
+	// hashes harder to reverse.
+	Salt string
+}
+
+// Redact returns a copy of the given key-value pairs with
+// sensitive values obscured according to the policy. A nil
+// RedactionPolicy is equivalent to the zero value.
+func (p *RedactionPolicy) Redact(values map[string]string) map[string]string {
+
+	if p == nil {
+		p = &RedactionPolicy{}
+	}
+
+	redacted := make(map[string]string, len(values))
+
+	for k, v := range values {
+
+		var r Redaction
+
+		switch k {
+		case paramKey:
+			r = p.Key
+		case paramAuthorEmail:
+			r = p.Email
+		case paramUserIP:
+			r = p.IP
+		default:
+			var ok bool
+			if r, ok = p.Fields[k]; !ok {
+				r = RedactNone
+			}
+		}
+
+		redacted[k] = p.redact(k, v, r)
+	}
+
+	return redacted
+}
+
+func (p *RedactionPolicy) redact(key, value string, r Redaction) string {
+
+	if value == "" {
+		return value
+	}
+
+	switch r {
+	case RedactNone:
+		return value
+	case RedactHash:
+		sum := sha256.Sum256([]byte(p.Salt + value))
+		return "sha256:" + hex.EncodeToString(sum[:6])
+	default:
+		if key == paramKey {
+			return MaskKey(value)
+		}
+		return redactedValue
+	}
+}
+
+// MaskKey returns an API key with all but its last four
+// characters replaced by asterisks, e.g. "********9abc".
+// Shorter keys are masked completely.
+func MaskKey(key string) string {
+
+	const visible = 4
+
+	if len(key) <= visible {
+		return strings.Repeat("*", len(key))
+	}
+
+	return strings.Repeat("*", len(key)-visible) + key[len(key)-visible:]
+}
+
+// maskError returns an error whose message has every
+// occurrence of an API key masked. Network errors from an
+// http.Client include the request URL, which contains the
+// key for most providers. The original error is still
+// available to errors.Is and errors.As.
+func maskError(err error, key string) error {
+
+	if err == nil || key == "" || !strings.Contains(err.Error(), key) {
+		return err
+	}
+
+	if ue, ok := err.(*url.Error); ok {
+		return &url.Error{
+			Op:  ue.Op,
+			URL: strings.Replace(ue.URL, key, MaskKey(key), -1),
+			Err: maskError(ue.Err, key),
+		}
+	}
+
+	return &maskedError{err: err, key: key}
+}
+
+// A maskedError is an error whose message hides an API key.
+type maskedError struct {
+	err error
+	key string
+}
+
+func (e *maskedError) Error() string {
+	return strings.Replace(e.err.Error(), e.key, MaskKey(e.key), -1)
+}
+
+func (e *maskedError) Unwrap() error {
+	return e.err
+}
+
+// Timeout reports whether the underlying error is a timeout,
+// so that url.Error's Timeout method still works.
+func (e *maskedError) Timeout() bool {
+	t, ok := e.err.(interface{ Timeout() bool })
+	return ok && t.Timeout()
+}
+
+// logCall logs the outcome of an Akismet call.
+func (ch *Checker) logCall(ctx context.Context, info *CallInfo) {
+
+	if ch.logger == nil {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", info.Method),
+		slog.Duration("duration", info.Duration),
+		slog.Int("attempts", info.Attempts),
+	}
+
+	if info.Cached {
+		attrs = append(attrs, slog.Bool("cached", true))
+	}
+
+	if info.Method == methodCheck && info.Err == nil {
+		attrs = append(attrs, slog.String("status", info.Status.String()))
+	}
+
+	level := slog.LevelInfo
+
+	if info.Err != nil {
+		level = slog.LevelWarn
+		attrs = append(attrs,
+			slog.String("error", info.Err.Error()),
+			slog.String("error_class", string(info.ErrorClass)),
+		)
+	}
+
+	ch.logger.LogAttrs(ctx, level, "akismet call", attrs...)
+}
+
+// logRequest logs an HTTP request to Akismet.
+func (ch *Checker) logRequest(ctx context.Context, info *CallInfo, params map[string]string) {
+
+	if ch.logger == nil || ch.logVerbosity < LogRequests {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", info.Method),
+		slog.Int("attempt", info.Attempts),
+	}
+
+	if ch.logVerbosity >= LogValues {
+		attrs = append(attrs, slog.Any("values", ch.redaction.Redact(params)))
+	}
+
+	ch.logger.LogAttrs(ctx, slog.LevelDebug, "akismet request", attrs...)
+}
+
+// logResponse logs an HTTP response from Akismet.
+func (ch *Checker) logResponse(ctx context.Context, info *CallInfo, resp *http.Response, body []byte) {
+
+	if ch.logger == nil || ch.logVerbosity < LogRequests {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", info.Method),
+		slog.Int("attempt", info.Attempts),
+		slog.Int("http_status", resp.StatusCode),
+	}
+
+	if ch.logVerbosity >= LogValues {
+		header := make(map[string]string)
+		for k := range resp.Header {
+			if strings.HasPrefix(strings.ToLower(k), "x-akismet-") {
+				header[k] = resp.Header.Get(k)
+			}
+		}
+		attrs = append(attrs,
+			slog.String("body", string(body)),
+			slog.Any("header", header),
+		)
+	}
+
+	ch.logger.LogAttrs(ctx, slog.LevelDebug, "akismet response", attrs...)
+}
@@ -0,0 +1,204 @@
+package gokismet_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/deepilla/gokismet"
+)
+
+// TestMaskKey verifies that MaskKey hides all but the last
+// four characters of an API key.
+func TestMaskKey(t *testing.T) {
+
+	tests := []struct {
+		Key      string
+		Expected string
+	}{
+		{"", ""},
+		{"abc", "***"},
+		{"abcd", "****"},
+		{"123456789abc", "********9abc"},
+	}
+
+	for i, test := range tests {
+		if got := gokismet.MaskKey(test.Key); got != test.Expected {
+			t.Errorf("Test %d: Expected %q, got %q", i+1, test.Expected, got)
+		}
+	}
+}
+
+// TestRedactionPolicy verifies that RedactionPolicy obscures
+// the correct values.
+func TestRedactionPolicy(t *testing.T) {
+
+	values := map[string]string{
+		"key":                  TestAPIKey,
+		"user_ip":              "127.0.0.1",
+		"comment_author":       "A. Commenter",
+		"comment_author_email": "acommenter@example.com",
+		"comment_content":      "Hello world",
+	}
+
+	tests := []struct {
+		Policy   *gokismet.RedactionPolicy
+		Expected map[string]string
+	}{
+		{
+			// The default policy masks the key, IP
+			// and email.
+			Policy: nil,
+			Expected: map[string]string{
+				"key":                  "********9abc",
+				"user_ip":              "[redacted]",
+				"comment_author":       "A. Commenter",
+				"comment_author_email": "[redacted]",
+				"comment_content":      "Hello world",
+			},
+		},
+		{
+			Policy: &gokismet.RedactionPolicy{
+				IP:    gokismet.RedactNone,
+				Email: gokismet.RedactHash,
+				Fields: map[string]gokismet.Redaction{
+					"comment_author": gokismet.RedactMask,
+				},
+			},
+			Expected: map[string]string{
+				"key":                  "********9abc",
+				"user_ip":              "127.0.0.1",
+				"comment_author":       "[redacted]",
+				"comment_author_email": "sha256:4e01bac73a8a",
+				"comment_content":      "Hello world",
+			},
+		},
+	}
+
+	compareValues := compareStringMap("key-value pair(s)")
+
+	for i, test := range tests {
+		errors := compareValues(test.Expected, test.Policy.Redact(values))
+		for _, err := range errors {
+			t.Errorf("Test %d: %s", i+1, err)
+		}
+	}
+}
+
+// TestLogger verifies that Checkers log their Akismet calls
+// without revealing sensitive values.
+func TestLogger(t *testing.T) {
+
+	client := &Responder{
+		Responses: map[string]*ResponseInfo{
+			"comment-check": {
+				Body:       "true",
+				StatusCode: http.StatusOK,
+			},
+		},
+	}
+	client.AddResponses(verifyingResponder)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	ch := gokismet.NewCheckerClient(TestAPIKey, TestSite, client,
+		gokismet.WithLogger(logger, &gokismet.LogOptions{
+			Verbosity: gokismet.LogValues,
+		}),
+	)
+
+	ch.Check(map[string]string{
+		"user_ip":              "192.168.1.1",
+		"comment_author_email": "acommenter@example.com",
+	})
+
+	out := buf.String()
+
+	for _, s := range []string{
+		`msg="akismet call" method=verify-key`,
+		`msg="akismet call" method=comment-check`,
+		`status=probable-spam`,
+		`msg="akismet request"`,
+		`msg="akismet response"`,
+		`key:********9abc`,
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Expected log output to contain %q", s)
+		}
+	}
+
+	for _, s := range []string{
+		TestAPIKey,
+		"192.168.1.1",
+		"acommenter@example.com",
+	} {
+		if strings.Contains(out, s) {
+			t.Errorf("Expected log output not to contain %q", s)
+		}
+	}
+}
+
+// transportFunc converts a standalone function into an
+// http.RoundTripper.
+type transportFunc func(req *http.Request) (*http.Response, error)
+
+func (f transportFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// TestLogger_NetworkError verifies that API keys in the URLs
+// of failed requests are masked in logs and returned errors.
+func TestLogger_NetworkError(t *testing.T) {
+
+	client := &http.Client{
+		Transport: transportFunc(func(req *http.Request) (*http.Response, error) {
+			if path.Base(req.URL.Path) == "verify-key" {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader("valid")),
+					Header:     make(http.Header),
+				}, nil
+			}
+			return nil, fmt.Errorf("dial tcp: lookup %s: no such host", req.URL.Host)
+		}),
+	}
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	ch := gokismet.NewCheckerClient(TestAPIKey, TestSite, client,
+		gokismet.WithLogger(logger, nil),
+	)
+
+	_, err := ch.Check(map[string]string{
+		"comment_author": "A. Commenter",
+	})
+	if err == nil {
+		t.Fatal("Expected an error, got nil")
+	}
+
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		t.Errorf("Expected a *url.Error, got %T", err)
+	}
+
+	for name, s := range map[string]string{
+		"error":      err.Error(),
+		"log output": buf.String(),
+	} {
+		if strings.Contains(s, TestAPIKey) {
+			t.Errorf("Expected %s not to contain the API key, got %s", name, s)
+		}
+		if !strings.Contains(s, "********9abc") {
+			t.Errorf("Expected %s to contain the masked API key, got %s", name, s)
+		}
+	}
+}
@@ -0,0 +1,525 @@
+/*
+Package metrics collects in-process metrics for gokismet
+Checkers and exposes them in the Prometheus text format and
+via expvar.
+
+Create a Registry, then add a Collector for each Checker you
+want to monitor:
+
+	reg := metrics.NewRegistry()
+
+	ch := gokismet.NewChecker("YOUR-API-KEY", "http://your-website.com",
+		gokismet.WithObserver(reg.Collector("comments")))
+
+	http.Handle("/metrics", reg)
+	reg.Publish("gokismet")
+
+The package has no dependencies outside the standard library.
+*/
+package metrics
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/deepilla/gokismet"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the
+// latency histogram buckets.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// A Registry holds the metrics for one or more Checkers. It
+// is an http.Handler that serves its metrics in the Prometheus
+// text exposition format.
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]*Collector
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: make(map[string]*Collector),
+	}
+}
+
+// Collector returns the Collector with the given name,
+// creating it if necessary. The name identifies a Checker
+// and is used as the value of the "checker" label.
+func (r *Registry) Collector(name string) *Collector {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := r.collectors[name]
+	if c == nil {
+		c = newCollector(name)
+		r.collectors[name] = c
+	}
+
+	return c
+}
+
+// sorted returns the Registry's Collectors in name order.
+func (r *Registry) sorted() []*Collector {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	collectors := make([]*Collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		collectors = append(collectors, c)
+	}
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].name < collectors[j].name
+	})
+
+	return collectors
+}
+
+// ServeHTTP writes the Registry's metrics in the Prometheus
+// text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// WriteTo writes the Registry's metrics to w in the Prometheus
+// text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+
+	var snapshots []*snapshot
+	for _, c := range r.sorted() {
+		snapshots = append(snapshots, c.snapshot())
+	}
+
+	for _, f := range families {
+		fmt.Fprintf(cw, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(cw, "# TYPE %s %s\n", f.name, f.typ)
+		for _, s := range snapshots {
+			f.write(cw, s)
+		}
+	}
+
+	if err := bw.Flush(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+
+	return cw.n, cw.err
+}
+
+// Publish exports the Registry's metrics as an expvar
+// variable with the given name. Like expvar.Publish, it
+// panics if the name is already in use.
+func (r *Registry) Publish(name string) {
+	expvar.Publish(name, expvar.Func(r.expvar))
+}
+
+// expvar returns the Registry's metrics as a value suitable
+// for JSON encoding.
+func (r *Registry) expvar() interface{} {
+
+	vars := make(map[string]interface{})
+
+	for _, c := range r.sorted() {
+		s := c.snapshot()
+
+		durations := make(map[string]interface{})
+		for method, h := range s.durations {
+			durations[method] = map[string]interface{}{
+				"count": h.count,
+				"sum":   h.sum,
+			}
+		}
+
+		errors := make(map[string]map[string]uint64)
+		for k, n := range s.errors {
+			if errors[k.method] == nil {
+				errors[k.method] = make(map[string]uint64)
+			}
+			errors[k.method][string(k.class)] = n
+		}
+
+		results := make(map[string]uint64)
+		for status, n := range s.results {
+			results[status.String()] = n
+		}
+
+		vars[s.name] = map[string]interface{}{
+			"calls":                 s.calls,
+			"calls_in_flight":       s.inFlight,
+			"errors":                errors,
+			"retries":               s.retries,
+			"results":               results,
+			"cache_hits":            s.cacheHits,
+			"verification_failures": s.verifyFailures,
+			"durations":             durations,
+		}
+	}
+
+	return vars
+}
+
+// A Collector records metrics for a single Checker. It
+// implements the gokismet.Observer interface.
+type Collector struct {
+	name    string
+	buckets []float64
+
+	mu             sync.Mutex
+	inFlight       int64
+	calls          map[string]uint64
+	errors         map[errorKey]uint64
+	retries        map[string]uint64
+	results        map[gokismet.SpamStatus]uint64
+	cacheHits      uint64
+	verifyFailures uint64
+	durations      map[string]*histogram
+}
+
+type errorKey struct {
+	method string
+	class  gokismet.ErrorClass
+}
+
+func newCollector(name string) *Collector {
+	return &Collector{
+		name:      name,
+		buckets:   DefaultBuckets,
+		calls:     make(map[string]uint64),
+		errors:    make(map[errorKey]uint64),
+		retries:   make(map[string]uint64),
+		results:   make(map[gokismet.SpamStatus]uint64),
+		durations: make(map[string]*histogram),
+	}
+}
+
+// CallStarted records the start of an Akismet call.
+func (c *Collector) CallStarted(info *gokismet.CallInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight++
+}
+
+// CallFinished records the outcome of an Akismet call.
+func (c *Collector) CallFinished(info *gokismet.CallInfo) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inFlight--
+
+	if info.Attempts > 1 {
+		c.retries[info.Method] += uint64(info.Attempts - 1)
+	}
+
+	if info.ErrorClass != gokismet.ErrorClassNone {
+		c.errors[errorKey{info.Method, info.ErrorClass}]++
+	}
+
+	if info.ErrorClass == gokismet.ErrorClassKey {
+		c.verifyFailures++
+	}
+
+	if info.Method == "comment-check" {
+		c.results[info.Status]++
+	}
+
+	// Cache hits don't touch the network so they're
+	// excluded from the call counts and latency histograms.
+	if info.Cached {
+		c.cacheHits++
+		return
+	}
+
+	c.calls[info.Method]++
+
+	h := c.durations[info.Method]
+	if h == nil {
+		h = newHistogram(c.buckets)
+		c.durations[info.Method] = h
+	}
+	h.observe(info.Duration.Seconds())
+}
+
+// A snapshot is a point-in-time copy of a Collector's metrics.
+type snapshot struct {
+	name           string
+	inFlight       int64
+	calls          map[string]uint64
+	errors         map[errorKey]uint64
+	retries        map[string]uint64
+	results        map[gokismet.SpamStatus]uint64
+	cacheHits      uint64
+	verifyFailures uint64
+	durations      map[string]*histogram
+}
+
+func (c *Collector) snapshot() *snapshot {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := &snapshot{
+		name:           c.name,
+		inFlight:       c.inFlight,
+		calls:          make(map[string]uint64, len(c.calls)),
+		errors:         make(map[errorKey]uint64, len(c.errors)),
+		retries:        make(map[string]uint64, len(c.retries)),
+		results:        make(map[gokismet.SpamStatus]uint64, len(c.results)),
+		cacheHits:      c.cacheHits,
+		verifyFailures: c.verifyFailures,
+		durations:      make(map[string]*histogram, len(c.durations)),
+	}
+
+	for k, v := range c.calls {
+		s.calls[k] = v
+	}
+	for k, v := range c.errors {
+		s.errors[k] = v
+	}
+	for k, v := range c.retries {
+		s.retries[k] = v
+	}
+	for k, v := range c.results {
+		s.results[k] = v
+	}
+	for k, v := range c.durations {
+		s.durations[k] = v.copy()
+	}
+
+	return s
+}
+
+// A histogram counts observations in fixed buckets.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) copy() *histogram {
+	c := *h
+	c.counts = append([]uint64(nil), h.counts...)
+	return &c
+}
+
+// A family describes a Prometheus metric family.
+type family struct {
+	name  string
+	help  string
+	typ   string
+	write func(w io.Writer, s *snapshot)
+}
+
+var families = []family{
+	{
+		name: "gokismet_calls_total",
+		help: "Akismet calls made, by method.",
+		typ:  "counter",
+		write: func(w io.Writer, s *snapshot) {
+			for _, method := range sortedKeys(s.calls) {
+				writeSample(w, "gokismet_calls_total", s.calls[method], "checker", s.name, "method", method)
+			}
+		},
+	},
+	{
+		name: "gokismet_calls_in_flight",
+		help: "Akismet calls currently in progress.",
+		typ:  "gauge",
+		write: func(w io.Writer, s *snapshot) {
+			writeSample(w, "gokismet_calls_in_flight", s.inFlight, "checker", s.name)
+		},
+	},
+	{
+		name: "gokismet_call_errors_total",
+		help: "Failed Akismet calls, by method and error class.",
+		typ:  "counter",
+		write: func(w io.Writer, s *snapshot) {
+			keys := make([]errorKey, 0, len(s.errors))
+			for k := range s.errors {
+				keys = append(keys, k)
+			}
+			sort.Slice(keys, func(i, j int) bool {
+				if keys[i].method != keys[j].method {
+					return keys[i].method < keys[j].method
+				}
+				return keys[i].class < keys[j].class
+			})
+			for _, k := range keys {
+				writeSample(w, "gokismet_call_errors_total", s.errors[k], "checker", s.name, "method", k.method, "class", string(k.class))
+			}
+		},
+	},
+	{
+		name: "gokismet_call_retries_total",
+		help: "Akismet requests retried after a failure, by method.",
+		typ:  "counter",
+		write: func(w io.Writer, s *snapshot) {
+			for _, method := range sortedKeys(s.retries) {
+				writeSample(w, "gokismet_call_retries_total", s.retries[method], "checker", s.name, "method", method)
+			}
+		},
+	},
+	{
+		name: "gokismet_check_results_total",
+		help: "Spam check results, by status.",
+		typ:  "counter",
+		write: func(w io.Writer, s *snapshot) {
+			for _, status := range []gokismet.SpamStatus{
+				gokismet.StatusHam,
+				gokismet.StatusProbableSpam,
+				gokismet.StatusDefiniteSpam,
+				gokismet.StatusUnknown,
+			} {
+				writeSample(w, "gokismet_check_results_total", s.results[status], "checker", s.name, "status", status.String())
+			}
+		},
+	},
+	{
+		name: "gokismet_cache_hits_total",
+		help: "Spam checks served from the cache.",
+		typ:  "counter",
+		write: func(w io.Writer, s *snapshot) {
+			writeSample(w, "gokismet_cache_hits_total", s.cacheHits, "checker", s.name)
+		},
+	},
+	{
+		name: "gokismet_verification_failures_total",
+		help: "Failed API key verifications.",
+		typ:  "counter",
+		write: func(w io.Writer, s *snapshot) {
+			writeSample(w, "gokismet_verification_failures_total", s.verifyFailures, "checker", s.name)
+		},
+	},
+	{
+		name: "gokismet_call_duration_seconds",
+		help: "Latency of Akismet calls, by method.",
+		typ:  "histogram",
+		write: func(w io.Writer, s *snapshot) {
+			methods := make([]string, 0, len(s.durations))
+			for method := range s.durations {
+				methods = append(methods, method)
+			}
+			sort.Strings(methods)
+
+			for _, method := range methods {
+				h := s.durations[method]
+				var cumulative uint64
+				for i, bound := range h.bounds {
+					cumulative += h.counts[i]
+					writeSample(w, "gokismet_call_duration_seconds_bucket", cumulative,
+						"checker", s.name, "method", method, "le", formatFloat(bound))
+				}
+				writeSample(w, "gokismet_call_duration_seconds_bucket", h.count,
+					"checker", s.name, "method", method, "le", "+Inf")
+				writeSample(w, "gokismet_call_duration_seconds_sum", h.sum, "checker", s.name, "method", method)
+				writeSample(w, "gokismet_call_duration_seconds_count", h.count, "checker", s.name, "method", method)
+			}
+		},
+	},
+}
+
+// writeSample writes a single Prometheus sample. Labels are
+// given as alternating names and values.
+func writeSample(w io.Writer, name string, value interface{}, labels ...string) {
+
+	var sb strings.Builder
+
+	sb.WriteString(name)
+
+	if len(labels) > 0 {
+		sb.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(labels[i])
+			sb.WriteString(`="`)
+			sb.WriteString(escapeLabel(labels[i+1]))
+			sb.WriteByte('"')
+		}
+		sb.WriteByte('}')
+	}
+
+	sb.WriteByte(' ')
+
+	switch v := value.(type) {
+	case float64:
+		sb.WriteString(formatFloat(v))
+	default:
+		fmt.Fprint(&sb, v)
+	}
+
+	sb.WriteByte('\n')
+
+	io.WriteString(w, sb.String())
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// A countingWriter keeps track of the bytes written to an
+// underlying Writer and the first error encountered.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
@@ -0,0 +1,151 @@
+package metrics_test
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepilla/gokismet"
+	"github.com/deepilla/gokismet/metrics"
+)
+
+// calls is a sequence of finished Akismet calls used by the
+// tests below.
+var calls = []*gokismet.CallInfo{
+	{
+		Method:     "verify-key",
+		Attempts:   1,
+		Duration:   20 * time.Millisecond,
+		Err:        errors.New("key not verified"),
+		ErrorClass: gokismet.ErrorClassKey,
+	},
+	{
+		Method:   "verify-key",
+		Attempts: 2,
+		Duration: 300 * time.Millisecond,
+	},
+	{
+		Method:   "comment-check",
+		Attempts: 1,
+		Duration: 40 * time.Millisecond,
+		Status:   gokismet.StatusProbableSpam,
+	},
+	{
+		Method: "comment-check",
+		Cached: true,
+		Status: gokismet.StatusProbableSpam,
+	},
+	{
+		Method:   "comment-check",
+		Attempts: 1,
+		Duration: 3 * time.Second,
+		Status:   gokismet.StatusHam,
+	},
+}
+
+func newTestRegistry() *metrics.Registry {
+
+	reg := metrics.NewRegistry()
+	c := reg.Collector("comments")
+
+	for _, info := range calls {
+		c.CallStarted(info)
+		c.CallFinished(info)
+	}
+
+	// An unfinished call.
+	c.CallStarted(&gokismet.CallInfo{Method: "submit-spam"})
+
+	return reg
+}
+
+// TestRegistry_Prometheus verifies the Prometheus text output
+// of a Registry.
+func TestRegistry_Prometheus(t *testing.T) {
+
+	reg := newTestRegistry()
+
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected Content-Type %q", ct)
+	}
+
+	body := rec.Body.String()
+
+	expected := []string{
+		`# TYPE gokismet_calls_total counter`,
+		`gokismet_calls_total{checker="comments",method="comment-check"} 2`,
+		`gokismet_calls_total{checker="comments",method="verify-key"} 2`,
+		`gokismet_calls_in_flight{checker="comments"} 1`,
+		`gokismet_call_errors_total{checker="comments",method="verify-key",class="key"} 1`,
+		`gokismet_call_retries_total{checker="comments",method="verify-key"} 1`,
+		`gokismet_check_results_total{checker="comments",status="ham"} 1`,
+		`gokismet_check_results_total{checker="comments",status="probable-spam"} 2`,
+		`gokismet_check_results_total{checker="comments",status="definite-spam"} 0`,
+		`gokismet_cache_hits_total{checker="comments"} 1`,
+		`gokismet_verification_failures_total{checker="comments"} 1`,
+		`# TYPE gokismet_call_duration_seconds histogram`,
+		`gokismet_call_duration_seconds_bucket{checker="comments",method="comment-check",le="0.05"} 1`,
+		`gokismet_call_duration_seconds_bucket{checker="comments",method="comment-check",le="2.5"} 1`,
+		`gokismet_call_duration_seconds_bucket{checker="comments",method="comment-check",le="5"} 2`,
+		`gokismet_call_duration_seconds_bucket{checker="comments",method="comment-check",le="+Inf"} 2`,
+		`gokismet_call_duration_seconds_sum{checker="comments",method="comment-check"} 3.04`,
+		`gokismet_call_duration_seconds_count{checker="comments",method="comment-check"} 2`,
+	}
+
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(body, "\n") {
+		lines[line] = true
+	}
+
+	for _, exp := range expected {
+		if !lines[exp] {
+			t.Errorf("Expected output to contain %q", exp)
+		}
+	}
+}
+
+// TestRegistry_Expvar verifies the expvar output of a Registry.
+func TestRegistry_Expvar(t *testing.T) {
+
+	reg := newTestRegistry()
+	reg.Publish("gokismet_test")
+
+	var vars map[string]struct {
+		Calls                map[string]uint64 `json:"calls"`
+		Results              map[string]uint64 `json:"results"`
+		CacheHits            uint64            `json:"cache_hits"`
+		VerificationFailures uint64            `json:"verification_failures"`
+	}
+
+	if err := json.Unmarshal([]byte(expvar.Get("gokismet_test").String()), &vars); err != nil {
+		t.Fatalf("Could not decode expvar output: %s", err)
+	}
+
+	c, ok := vars["comments"]
+	if !ok {
+		t.Fatalf("Expected metrics for checker %q", "comments")
+	}
+
+	if n := c.Calls["comment-check"]; n != 2 {
+		t.Errorf("Expected %d comment-check calls, got %d", 2, n)
+	}
+
+	if n := c.Results["probable-spam"]; n != 2 {
+		t.Errorf("Expected %d probable-spam results, got %d", 2, n)
+	}
+
+	if c.CacheHits != 1 {
+		t.Errorf("Expected %d cache hits, got %d", 1, c.CacheHits)
+	}
+
+	if c.VerificationFailures != 1 {
+		t.Errorf("Expected %d verification failures, got %d", 1, c.VerificationFailures)
+	}
+}
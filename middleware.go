@@ -0,0 +1,188 @@
+package gokismet
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// MiddlewareOptions configures the behaviour of Middleware.
+type MiddlewareOptions struct {
+
+	// Fields maps Akismet keys, e.g. "comment_content", to
+	// the names of the form fields that contain their values.
+	Fields map[string]string
+
+	// Values contains additional key-value pairs to include
+	// with every check, e.g. a fixed "comment_type".
+	Values map[string]string
+
+	// Methods lists the HTTP methods that trigger a spam
+	// check. Requests using other methods are passed through
+	// unchecked. Defaults to POST.
+	Methods []string
+
+	// IPHeader is the name of a request header containing
+	// the client's IP address, e.g. "X-Forwarded-For". Only
+	// set this if the header is controlled by a trusted proxy.
+	// If the header lists several addresses, the last one is
+	// used, i.e. the address seen by the proxy in front of
+	// the server. If empty, the IP address is taken from the
+	// request's RemoteAddr.
+	IPHeader string
+
+	// BlockSpam controls what happens to content that Akismet
+	// flags as definite spam. If true, these requests are
+	// passed to SpamHandler instead of the wrapped Handler.
+	BlockSpam bool
+
+	// SpamHandler responds to requests blocked by BlockSpam.
+	// Defaults to a handler that returns 403 Forbidden.
+	SpamHandler http.Handler
+}
+
+// Middleware returns a function that wraps an http.Handler
+// with a spam check. For each incoming request, the wrapper
+// builds key-value pairs from the request and its form fields,
+// checks them with the given SpamChecker (typically a Checker)
+// and stores the result in the request context, where it can
+// be retrieved by calling ResultFromContext.
+//
+// If opts is nil, default options are used.
+func Middleware(checker SpamChecker, opts *MiddlewareOptions) func(http.Handler) http.Handler {
+
+	if opts == nil {
+		opts = &MiddlewareOptions{}
+	}
+
+	methods := opts.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodPost}
+	}
+
+	spamHandler := opts.SpamHandler
+	if spamHandler == nil {
+		spamHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			if !containsString(methods, r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+
+			values := requestValues(r, opts)
+
+			result, err := checker.CheckContext(r.Context(), values)
+			if err != nil {
+				result = &CheckResult{
+					Status: StatusUnknown,
+					Values: values,
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), resultContextKey{}, &contextResult{result, err})
+			r = r.WithContext(ctx)
+
+			if opts.BlockSpam && result.Status == StatusDefiniteSpam {
+				spamHandler.ServeHTTP(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ResultFromContext returns the CheckResult stored in a
+// Context by Middleware, along with any error returned by
+// the spam check. If the check failed, the CheckResult has
+// a status of StatusUnknown. If the Context contains no
+// result, ResultFromContext returns nil, nil.
+func ResultFromContext(ctx context.Context) (*CheckResult, error) {
+
+	cr, ok := ctx.Value(resultContextKey{}).(*contextResult)
+	if !ok {
+		return nil, nil
+	}
+
+	return cr.result, cr.err
+}
+
+// resultContextKey is the Context key for spam check results.
+type resultContextKey struct{}
+
+// contextResult is the value stored in a Context by Middleware.
+type contextResult struct {
+	result *CheckResult
+	err    error
+}
+
+// requestValues builds Akismet key-value pairs from an HTTP
+// request and the given Middleware options. Non-empty form
+// values take precedence over values derived from the
+// request itself.
+func requestValues(r *http.Request, opts *MiddlewareOptions) map[string]string {
+
+	values := map[string]string{
+		paramUserIP:    requestIP(r, opts.IPHeader),
+		paramUserAgent: r.UserAgent(),
+		paramReferer:   r.Referer(),
+	}
+
+	fields := make(map[string]string)
+	for key, field := range opts.Fields {
+		if v := r.FormValue(field); v != "" {
+			fields[key] = v
+		}
+	}
+
+	return mergeStringMaps(values, opts.Values, fields)
+}
+
+// requestIP returns the client IP address for an HTTP
+// request. If header is non-empty, the last address in that
+// header is preferred over the request's RemoteAddr. Proxies
+// append to headers like X-Forwarded-For, so the last address
+// is the one added by the nearest proxy. Earlier addresses
+// are supplied by the client and can't be trusted.
+func requestIP(r *http.Request, header string) string {
+
+	if header != "" {
+		if lines := r.Header.Values(header); len(lines) > 0 {
+			addrs := strings.Split(lines[len(lines)-1], ",")
+			ip := strings.TrimSpace(addrs[len(addrs)-1])
+			if ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// containsString reports whether a slice of strings
+// contains the given value.
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,268 @@
+package gokismet_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/deepilla/gokismet"
+)
+
+// TestMiddleware verifies that Middleware builds the correct
+// key-value pairs, stores the check result in the request
+// context and blocks spam when configured to.
+func TestMiddleware(t *testing.T) {
+
+	tests := []struct {
+		// HTTP method of the incoming request.
+		Method string
+		// Akismet's response to comment-check.
+		Response *ResponseInfo
+		// Should definite spam be blocked?
+		BlockSpam bool
+		// Expected status code of the HTTP response.
+		StatusCode int
+		// Expected spam status stored in the context
+		// (or StatusUnknown for unchecked requests).
+		SpamStatus gokismet.SpamStatus
+		// Should the request be checked?
+		IsChecked bool
+		// Should the check return an error?
+		IsError bool
+	}{
+		{
+			// GET requests are not checked.
+			Method:     "GET",
+			StatusCode: http.StatusOK,
+		},
+		{
+			Method: "POST",
+			Response: &ResponseInfo{
+				Body:       "false",
+				StatusCode: http.StatusOK,
+			},
+			StatusCode: http.StatusOK,
+			SpamStatus: gokismet.StatusHam,
+			IsChecked:  true,
+		},
+		{
+			Method: "POST",
+			Response: &ResponseInfo{
+				Body:       "true",
+				StatusCode: http.StatusOK,
+				HeaderItems: map[string]string{
+					"X-akismet-pro-tip": "discard",
+					"X-akismet-guid":    "abc123",
+				},
+			},
+			StatusCode: http.StatusOK,
+			SpamStatus: gokismet.StatusDefiniteSpam,
+			IsChecked:  true,
+		},
+		{
+			// Definite spam is blocked.
+			Method: "POST",
+			Response: &ResponseInfo{
+				Body:       "true",
+				StatusCode: http.StatusOK,
+				HeaderItems: map[string]string{
+					"X-akismet-pro-tip": "discard",
+				},
+			},
+			BlockSpam:  true,
+			StatusCode: http.StatusForbidden,
+			SpamStatus: gokismet.StatusDefiniteSpam,
+			IsChecked:  true,
+		},
+		{
+			// Probable spam is not blocked.
+			Method: "POST",
+			Response: &ResponseInfo{
+				Body:       "true",
+				StatusCode: http.StatusOK,
+			},
+			BlockSpam:  true,
+			StatusCode: http.StatusOK,
+			SpamStatus: gokismet.StatusProbableSpam,
+			IsChecked:  true,
+		},
+		{
+			// Errors are passed on to the wrapped handler.
+			Method: "POST",
+			Response: &ResponseInfo{
+				StatusCode: http.StatusInternalServerError,
+			},
+			StatusCode: http.StatusOK,
+			SpamStatus: gokismet.StatusUnknown,
+			IsChecked:  true,
+			IsError:    true,
+		},
+	}
+
+	expValues := map[string]string{
+		"user_ip":              "10.0.0.1",
+		"user_agent":           "Test/1.0",
+		"referrer":             "http://example.com/posts/1",
+		"comment_type":         "comment",
+		"comment_author":       "A. Commenter",
+		"comment_author_email": "acommenter@example.com",
+		"comment_content":      "Hello world",
+	}
+
+	compareValues := compareStringMap("key-value pair(s)")
+
+	for i, test := range tests {
+
+		client := &Responder{
+			Responses: map[string]*ResponseInfo{
+				"comment-check": test.Response,
+			},
+		}
+		client.AddResponses(verifyingResponder)
+
+		ch := gokismet.NewCheckerClient(TestAPIKey, TestSite, client)
+
+		mw := gokismet.Middleware(ch, &gokismet.MiddlewareOptions{
+			Fields: map[string]string{
+				"comment_author":       "name",
+				"comment_author_email": "email",
+				"comment_content":      "message",
+				// Missing form fields don't override
+				// other values.
+				"user_ip":      "ip",
+				"comment_type": "type",
+			},
+			Values: map[string]string{
+				"comment_type": "comment",
+			},
+			IPHeader:  "X-Forwarded-For",
+			BlockSpam: test.BlockSpam,
+		})
+
+		var result *gokismet.CheckResult
+		var err error
+
+		handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			result, err = gokismet.ResultFromContext(r.Context())
+		}))
+
+		form := url.Values{
+			"name":    {"A. Commenter"},
+			"email":   {"acommenter@example.com"},
+			"message": {"Hello world"},
+		}
+
+		req := httptest.NewRequest(test.Method, "http://example.com/comments", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", "Test/1.0")
+		req.Header.Set("Referer", "http://example.com/posts/1")
+		// The proxy appends the client's address to whatever
+		// the client sent.
+		req.Header.Set("X-Forwarded-For", "192.168.0.1, 10.0.0.1")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != test.StatusCode {
+			t.Errorf("Test %d: Expected HTTP Status %d, got %d", i+1, test.StatusCode, rec.Code)
+		}
+
+		if test.StatusCode != http.StatusOK {
+			continue
+		}
+
+		if !test.IsChecked {
+			if result != nil || err != nil {
+				t.Errorf("Test %d: Expected no result, got %v, %v", i+1, result, err)
+			}
+			continue
+		}
+
+		if result == nil {
+			t.Fatalf("Test %d: Expected a result, got nil", i+1)
+		}
+
+		if result.Status != test.SpamStatus {
+			t.Errorf("Test %d: Expected Spam Status %q, got %q", i+1,
+				statusToString(test.SpamStatus), statusToString(result.Status))
+		}
+
+		if isErr := err != nil; isErr != test.IsError {
+			t.Errorf("Test %d: Expected error %v, got %v", i+1, test.IsError, err)
+		}
+
+		errors := compareValues(expValues, result.Values)
+		for _, err := range errors {
+			t.Errorf("Test %d: %s", i+1, err)
+		}
+
+		if guid := test.Response.HeaderItems["X-akismet-guid"]; result.GUID != guid {
+			t.Errorf("Test %d: Expected GUID %q, got %q", i+1, guid, result.GUID)
+		}
+	}
+}
+
+// TestMiddleware_IP verifies that client IP addresses are
+// taken from the last entry in the IP header.
+func TestMiddleware_IP(t *testing.T) {
+
+	tests := []struct {
+		Header []string
+		IP     string
+	}{
+		{
+			// Without a header, RemoteAddr is used.
+			IP: "192.0.2.1",
+		},
+		{
+			Header: []string{"10.0.0.1"},
+			IP:     "10.0.0.1",
+		},
+		{
+			// Client-supplied addresses are ignored.
+			Header: []string{"203.0.113.7, 10.0.0.1"},
+			IP:     "10.0.0.1",
+		},
+		{
+			// Repeated headers are treated as one list.
+			Header: []string{"203.0.113.7", "10.0.0.1"},
+			IP:     "10.0.0.1",
+		},
+		{
+			// A trailing empty entry falls back to RemoteAddr.
+			Header: []string{"10.0.0.1, "},
+			IP:     "192.0.2.1",
+		},
+	}
+
+	for i, test := range tests {
+
+		fake := &gokismet.Fake{}
+		mw := gokismet.Middleware(fake, &gokismet.MiddlewareOptions{
+			IPHeader: "X-Forwarded-For",
+		})
+
+		var result *gokismet.CheckResult
+
+		handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			result, _ = gokismet.ResultFromContext(r.Context())
+		}))
+
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/comments", nil)
+		for _, h := range test.Header {
+			req.Header.Add("X-Forwarded-For", h)
+		}
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if result == nil {
+			t.Fatalf("Test %d: Expected a result, got nil", i+1)
+		}
+
+		if ip := result.Values["user_ip"]; ip != test.IP {
+			t.Errorf("Test %d: Expected IP %q, got %q", i+1, test.IP, ip)
+		}
+	}
+}
@@ -0,0 +1,513 @@
+/*
+Package moderation provides a review queue for content that
+gokismet could not confidently classify.
+
+Akismet's StatusProbableSpam means "consider reviewing it".
+A Queue holds such content, along with its original values
+and CheckResult, until a moderator approves or rejects it.
+Decisions that contradict the original spam check are
+reported back to Akismet, so that it learns from them:
+
+	q := moderation.NewQueue(checker, nil)
+
+	result, err := checker.CheckContext(ctx, values)
+	if err == nil && result.Status == gokismet.StatusProbableSpam {
+		q.Add(ctx, values, result)
+	}
+
+	// Later, in the moderation UI...
+	q.Approve(ctx, id, "alice")
+
+Queues keep their Items in a store.Store, so they can be
+persisted to a file or database. NewUI provides a web
+interface for moderators.
+*/
+package moderation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/deepilla/gokismet"
+	"github.com/deepilla/gokismet/store"
+)
+
+// Collection is the name of the store collection that holds
+// a Queue's Items.
+const Collection = "moderation"
+
+// A State is the moderation state of an Item.
+type State string
+
+const (
+	// StatePending means the Item is awaiting a decision.
+	StatePending State = "pending"
+	// StateApproved means a moderator accepted the Item as
+	// legitimate content.
+	StateApproved State = "approved"
+	// StateRejected means a moderator rejected the Item as
+	// spam.
+	StateRejected State = "rejected"
+)
+
+// Errors returned by Queue methods.
+var (
+	// ErrNotFound means that no Item has the given ID.
+	ErrNotFound = errors.New("moderation: item not found")
+	// ErrDecided means that an Item has already been
+	// approved or rejected.
+	ErrDecided = errors.New("moderation: item already decided")
+)
+
+// An Item is a piece of content in a moderation Queue.
+type Item struct {
+	// A unique identifier for the Item. IDs sort in the
+	// order that Items were added.
+	ID string `json:"id"`
+	// The key-value pairs that were checked.
+	Values map[string]string `json:"values"`
+	// The result of the spam check. Only the Status, GUID,
+	// Header and Values are stored.
+	Result *gokismet.CheckResult `json:"-"`
+	// Explanations from any local rules that matched the
+	// content (see AddVerdict).
+	Rules []string `json:"rules,omitempty"`
+	// The Item's moderation state.
+	State State `json:"state"`
+	// When the Item was added to the Queue.
+	Created time.Time `json:"created"`
+	// The moderator who approved or rejected the Item.
+	DecidedBy string `json:"decided_by,omitempty"`
+	// When the Item was approved or rejected.
+	DecidedAt time.Time `json:"decided_at"`
+	// Was the decision reported to the spam checker?
+	Reported bool `json:"reported,omitempty"`
+}
+
+// storedItem is the form in which Items are kept in a Store.
+// Only the key details of the CheckResult are stored. The
+// Cached and Shared flags are kept so that reports don't
+// reuse the GUID of another check.
+type storedItem struct {
+	*item
+	Status gokismet.SpamStatus `json:"status"`
+	GUID   string              `json:"guid,omitempty"`
+	Header http.Header         `json:"header,omitempty"`
+	Cached bool                `json:"cached,omitempty"`
+	Shared bool                `json:"shared,omitempty"`
+}
+
+// item has the same fields as Item but none of its methods,
+// which prevents infinite recursion in the JSON methods.
+type item Item
+
+// MarshalJSON encodes an Item for storage.
+func (it *Item) MarshalJSON() ([]byte, error) {
+
+	v := &storedItem{item: (*item)(it)}
+
+	if it.Result != nil {
+		v.Status = it.Result.Status
+		v.GUID = it.Result.GUID
+		v.Header = it.Result.Header
+		v.Cached = it.Result.Cached
+		v.Shared = it.Result.Shared
+	}
+
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes an Item from storage.
+func (it *Item) UnmarshalJSON(b []byte) error {
+
+	v := &storedItem{item: (*item)(it)}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return err
+	}
+
+	it.Result = &gokismet.CheckResult{
+		Status: v.Status,
+		Values: it.Values,
+		GUID:   v.GUID,
+		Header: v.Header,
+		Cached: v.Cached,
+		Shared: v.Shared,
+	}
+
+	return nil
+}
+
+// flagged reports whether the spam check flagged an Item as
+// spam.
+func (it *Item) flagged() bool {
+	return it.Result != nil &&
+		(it.Result.Status == gokismet.StatusProbableSpam || it.Result.Status == gokismet.StatusDefiniteSpam)
+}
+
+// A Filter selects Items from a Queue. Zero-valued fields
+// match all Items.
+type Filter struct {
+	// Match Items in this state.
+	State State
+	// Match Items with this spam status.
+	Status gokismet.SpamStatus
+	// Match Items added at or after this time.
+	Since time.Time
+	// Match Items added before this time.
+	Until time.Time
+	// Match Items for which this function returns true.
+	Match func(*Item) bool
+}
+
+// match reports whether an Item satisfies a Filter. A nil
+// Filter matches everything.
+func (f *Filter) match(it *Item) bool {
+
+	if f == nil {
+		return true
+	}
+
+	switch {
+	case f.State != "" && it.State != f.State:
+		return false
+	case f.Status != gokismet.StatusUnknown && (it.Result == nil || it.Result.Status != f.Status):
+		return false
+	case !f.Since.IsZero() && it.Created.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !it.Created.Before(f.Until):
+		return false
+	case f.Match != nil && !f.Match(it):
+		return false
+	}
+
+	return true
+}
+
+// A Page is a subset of the Items matching a Filter.
+type Page struct {
+	// The Items on this page, oldest first.
+	Items []*Item
+	// The total number of matching Items.
+	Total int
+}
+
+// A Queue holds content awaiting moderation. It is safe for
+// concurrent use, but only one Queue should use a given Store.
+type Queue struct {
+	checker gokismet.SpamChecker
+	store   store.Store
+
+	// mu serialises updates to Items.
+	mu sync.Mutex
+	// deciding holds the IDs of Items with a decision
+	// in progress.
+	deciding map[string]bool
+}
+
+// NewQueue returns a Queue that keeps its Items in the given
+// Store. If s is nil, Items are kept in memory. Moderation
+// decisions that contradict the original spam check are
+// reported to the given SpamChecker.
+func NewQueue(checker gokismet.SpamChecker, s store.Store) *Queue {
+
+	if s == nil {
+		s = store.NewMemory()
+	}
+
+	return &Queue{
+		checker:  checker,
+		store:    s,
+		deciding: make(map[string]bool),
+	}
+}
+
+// Add adds content to the Queue in the pending state and
+// returns the new Item.
+func (q *Queue) Add(ctx context.Context, values map[string]string, result *gokismet.CheckResult) (*Item, error) {
+	return q.add(ctx, values, result, nil)
+}
+
+// AddVerdict adds content that was checked by a Pipeline to
+// the Queue. The explanations of any local rules that matched
+// are kept with the Item. If no stage called Akismet, the
+// Item's Result contains only the Pipeline's status.
+func (q *Queue) AddVerdict(ctx context.Context, values map[string]string, v *gokismet.Verdict) (*Item, error) {
+
+	result := v.CheckResult
+	if result == nil {
+		result = &gokismet.CheckResult{
+			Status: v.Status,
+			Values: values,
+		}
+	}
+
+	var rules []string
+	for _, o := range v.Trail {
+		if _, ok := o.Annotations["rule"]; ok && o.Reason != "" {
+			rules = append(rules, o.Reason)
+		}
+	}
+
+	return q.add(ctx, values, result, rules)
+}
+
+// add does the work of Add and AddVerdict.
+func (q *Queue) add(ctx context.Context, values map[string]string, result *gokismet.CheckResult, rules []string) (*Item, error) {
+
+	now := time.Now()
+
+	id, err := newID(now)
+	if err != nil {
+		return nil, err
+	}
+
+	it := &Item{
+		ID:      id,
+		Values:  values,
+		Result:  result,
+		Rules:   rules,
+		State:   StatePending,
+		Created: now,
+	}
+
+	if err := q.put(ctx, it); err != nil {
+		return nil, err
+	}
+
+	return it, nil
+}
+
+// Get returns the Item with the given ID.
+func (q *Queue) Get(ctx context.Context, id string) (*Item, error) {
+
+	b, err := q.store.Get(ctx, Collection, id)
+	if err == store.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var it Item
+	if err := json.Unmarshal(b, &it); err != nil {
+		return nil, fmt.Errorf("moderation: item %s: %w", id, err)
+	}
+
+	return &it, nil
+}
+
+// List returns a page of the Items that match a Filter,
+// oldest first. It skips the first offset matching Items
+// and returns at most limit Items. If limit is zero or less,
+// all remaining Items are returned. If filter is nil, all
+// Items match.
+//
+// List reads and decodes every Item in the Queue's Store,
+// including decided ones, on each call. Its cost grows with
+// the size of the Queue regardless of the page requested, so
+// decided Items should be removed (see Purge) once they are
+// no longer needed.
+func (q *Queue) List(ctx context.Context, filter *Filter, offset, limit int) (*Page, error) {
+
+	records, err := q.store.List(ctx, Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*Item
+	for _, r := range records {
+		it := &Item{}
+		if err := json.Unmarshal(r.Value, it); err != nil {
+			return nil, fmt.Errorf("moderation: item %s: %w", r.Key, err)
+		}
+		if filter.match(it) {
+			matches = append(matches, it)
+		}
+	}
+
+	return paginate(matches, offset, limit), nil
+}
+
+// Purge removes approved and rejected Items that were decided
+// before the given time. It returns the number of Items
+// removed.
+func (q *Queue) Purge(ctx context.Context, before time.Time) (int, error) {
+
+	page, err := q.List(ctx, nil, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, it := range page.Items {
+		if it.State == StatePending || !it.DecidedAt.Before(before) {
+			continue
+		}
+		if err := q.store.Delete(ctx, Collection, it.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// Approve marks an Item as legitimate content. If the spam
+// check flagged the Item as spam, Approve reports it to the
+// spam checker as ham. If the report fails, the Item is left
+// pending and the error is returned.
+func (q *Queue) Approve(ctx context.Context, id string, moderator string) (*Item, error) {
+	return q.decide(ctx, id, moderator, StateApproved)
+}
+
+// Reject marks an Item as spam. If the spam check passed the
+// Item as ham, Reject reports it to the spam checker as spam.
+// If the report fails, the Item is left pending and the error
+// is returned.
+func (q *Queue) Reject(ctx context.Context, id string, moderator string) (*Item, error) {
+	return q.decide(ctx, id, moderator, StateRejected)
+}
+
+// decide records a moderator's decision, reporting it to the
+// spam checker if necessary.
+func (q *Queue) decide(ctx context.Context, id string, moderator string, state State) (*Item, error) {
+
+	it, err := q.claim(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer q.unclaim(id)
+
+	reported, err := q.report(ctx, it, state)
+	if err != nil {
+		return nil, err
+	}
+
+	it.State = state
+	it.DecidedBy = moderator
+	it.DecidedAt = time.Now()
+	it.Reported = reported
+
+	if err := q.put(ctx, it); err != nil {
+		return nil, err
+	}
+
+	return it, nil
+}
+
+// claim loads a pending Item and marks it as being decided,
+// so that concurrent decisions fail while a report is in
+// progress.
+func (q *Queue) claim(ctx context.Context, id string) (*Item, error) {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.deciding[id] {
+		return nil, ErrDecided
+	}
+
+	it, err := q.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if it.State != StatePending {
+		return nil, ErrDecided
+	}
+
+	q.deciding[id] = true
+
+	return it, nil
+}
+
+// unclaim releases an Item claimed by claim.
+func (q *Queue) unclaim(id string) {
+	q.mu.Lock()
+	delete(q.deciding, id)
+	q.mu.Unlock()
+}
+
+// put writes an Item to the Queue's Store.
+func (q *Queue) put(ctx context.Context, it *Item) error {
+
+	b, err := json.Marshal(it)
+	if err != nil {
+		return err
+	}
+
+	return q.store.Put(ctx, Collection, it.ID, b)
+}
+
+// report notifies the spam checker of a decision that
+// contradicts the original spam check. It returns true if
+// a report was sent.
+func (q *Queue) report(ctx context.Context, it *Item, state State) (bool, error) {
+
+	var err error
+
+	// Include the GUID of the original check, if any.
+	values := it.Values
+	if it.Result != nil {
+		values = gokismet.ResultValues(it.Result)
+	}
+
+	switch {
+	case state == StateApproved && it.flagged():
+		err = q.checker.ReportHamContext(ctx, values)
+	case state == StateRejected && !it.flagged():
+		err = q.checker.ReportSpamContext(ctx, values)
+	default:
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("moderation: report failed: %w", err)
+	}
+
+	return true, nil
+}
+
+// paginate returns a page of Items.
+func paginate(items []*Item, offset, limit int) *Page {
+
+	page := &Page{
+		Total: len(items),
+	}
+
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(items) {
+		offset = len(items)
+	}
+	items = items[offset:]
+
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+
+	page.Items = items
+
+	return page
+}
+
+// newID generates a unique Item ID. IDs begin with the
+// creation time so that they sort chronologically.
+func newID(t time.Time) (string, error) {
+
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%016x", t.UnixNano()) + hex.EncodeToString(b), nil
+}
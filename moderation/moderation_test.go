@@ -0,0 +1,386 @@
+package moderation_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/deepilla/gokismet"
+	"github.com/deepilla/gokismet/moderation"
+	"github.com/deepilla/gokismet/store"
+)
+
+// failingChecker is a SpamChecker whose reports always fail.
+type failingChecker struct {
+	gokismet.Fake
+}
+
+var errReport = errors.New("report failed")
+
+func (fc *failingChecker) ReportHamContext(ctx context.Context, values map[string]string) error {
+	return errReport
+}
+
+func addItem(t *testing.T, q *moderation.Queue, content string, status gokismet.SpamStatus) *moderation.Item {
+	t.Helper()
+
+	values := map[string]string{"comment_content": content}
+
+	it, err := q.Add(context.Background(), values, &gokismet.CheckResult{
+		Status: status,
+		Values: values,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	return it
+}
+
+// TestQueue_Decisions verifies that approving and rejecting
+// Items sends the correct reports.
+func TestQueue_Decisions(t *testing.T) {
+
+	tests := []struct {
+		Status   gokismet.SpamStatus
+		Approve  bool
+		State    moderation.State
+		Reported bool
+		Spam     bool
+	}{
+		{
+			// Approving flagged content reports ham.
+			Status:   gokismet.StatusProbableSpam,
+			Approve:  true,
+			State:    moderation.StateApproved,
+			Reported: true,
+		},
+		{
+			// Rejecting flagged content needs no report.
+			Status: gokismet.StatusProbableSpam,
+			State:  moderation.StateRejected,
+		},
+		{
+			// Approving passed content needs no report.
+			Status:  gokismet.StatusHam,
+			Approve: true,
+			State:   moderation.StateApproved,
+		},
+		{
+			// Rejecting passed content reports spam.
+			Status:   gokismet.StatusHam,
+			State:    moderation.StateRejected,
+			Reported: true,
+			Spam:     true,
+		},
+	}
+
+	for i, test := range tests {
+
+		fake := &gokismet.Fake{}
+		q := moderation.NewQueue(fake, nil)
+
+		it := addItem(t, q, "Hello", test.Status)
+
+		before := time.Now()
+
+		decide := q.Reject
+		if test.Approve {
+			decide = q.Approve
+		}
+
+		got, err := decide(context.Background(), it.ID, "alice")
+		if err != nil {
+			t.Fatalf("Test %d: Unexpected error %s", i+1, err)
+		}
+
+		if got.State != test.State {
+			t.Errorf("Test %d: Expected state %q, got %q", i+1, test.State, got.State)
+		}
+
+		if got.DecidedBy != "alice" {
+			t.Errorf("Test %d: Expected DecidedBy %q, got %q", i+1, "alice", got.DecidedBy)
+		}
+
+		if got.DecidedAt.Before(before) {
+			t.Errorf("Test %d: Expected DecidedAt after %s, got %s", i+1, before, got.DecidedAt)
+		}
+
+		if got.Reported != test.Reported {
+			t.Errorf("Test %d: Expected Reported %v, got %v", i+1, test.Reported, got.Reported)
+		}
+
+		reports := fake.Reports()
+
+		if !test.Reported {
+			if len(reports) != 0 {
+				t.Errorf("Test %d: Expected no reports, got %d", i+1, len(reports))
+			}
+			continue
+		}
+
+		if len(reports) != 1 || reports[0].Spam != test.Spam {
+			t.Errorf("Test %d: Expected 1 report (spam=%v), got %+v", i+1, test.Spam, reports)
+		}
+
+		// Items can only be decided once.
+		if _, err := decide(context.Background(), it.ID, "bob"); err != moderation.ErrDecided {
+			t.Errorf("Test %d: Expected error %v, got %v", i+1, moderation.ErrDecided, err)
+		}
+	}
+}
+
+// TestQueue_ReportError verifies that Items stay pending if
+// their report fails.
+func TestQueue_ReportError(t *testing.T) {
+
+	q := moderation.NewQueue(&failingChecker{}, nil)
+	it := addItem(t, q, "Hello", gokismet.StatusProbableSpam)
+
+	if _, err := q.Approve(context.Background(), it.ID, "alice"); !errors.Is(err, errReport) {
+		t.Errorf("Expected error %v, got %v", errReport, err)
+	}
+
+	got, err := q.Get(context.Background(), it.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if got.State != moderation.StatePending || got.DecidedBy != "" {
+		t.Errorf("Expected a pending item, got %+v", got)
+	}
+
+	if _, err := q.Get(context.Background(), "missing"); err != moderation.ErrNotFound {
+		t.Errorf("Expected error %v, got %v", moderation.ErrNotFound, err)
+	}
+}
+
+// TestQueue_List verifies that Items can be filtered and
+// paginated.
+func TestQueue_List(t *testing.T) {
+
+	q := moderation.NewQueue(&gokismet.Fake{}, nil)
+
+	var ids []string
+	for i, status := range []gokismet.SpamStatus{
+		gokismet.StatusProbableSpam,
+		gokismet.StatusHam,
+		gokismet.StatusProbableSpam,
+		gokismet.StatusDefiniteSpam,
+		gokismet.StatusProbableSpam,
+	} {
+		it := addItem(t, q, string(rune('a'+i)), status)
+		ids = append(ids, it.ID)
+	}
+
+	if _, err := q.Reject(context.Background(), ids[2], "alice"); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	tests := []struct {
+		Filter *moderation.Filter
+		Offset int
+		Limit  int
+		Total  int
+		IDs    []string
+	}{
+		{
+			Total: 5,
+			IDs:   ids,
+		},
+		{
+			Offset: 1,
+			Limit:  2,
+			Total:  5,
+			IDs:    ids[1:3],
+		},
+		{
+			Offset: 10,
+			Total:  5,
+		},
+		{
+			Filter: &moderation.Filter{State: moderation.StatePending, Status: gokismet.StatusProbableSpam},
+			Total:  2,
+			IDs:    []string{ids[0], ids[4]},
+		},
+		{
+			Filter: &moderation.Filter{State: moderation.StateRejected},
+			Total:  1,
+			IDs:    []string{ids[2]},
+		},
+		{
+			Filter: &moderation.Filter{Match: func(it *moderation.Item) bool {
+				return it.Values["comment_content"] == "d"
+			}},
+			Total: 1,
+			IDs:   []string{ids[3]},
+		},
+	}
+
+	for i, test := range tests {
+
+		page, err := q.List(context.Background(), test.Filter, test.Offset, test.Limit)
+		if err != nil {
+			t.Fatalf("Test %d: Unexpected error %s", i+1, err)
+		}
+
+		if page.Total != test.Total {
+			t.Errorf("Test %d: Expected total %d, got %d", i+1, test.Total, page.Total)
+		}
+
+		if len(page.Items) != len(test.IDs) {
+			t.Errorf("Test %d: Expected %d items, got %d", i+1, len(test.IDs), len(page.Items))
+			continue
+		}
+
+		for j, id := range test.IDs {
+			if page.Items[j].ID != id {
+				t.Errorf("Test %d: Expected item %d to be %s, got %s", i+1, j+1, id, page.Items[j].ID)
+			}
+		}
+	}
+}
+
+// TestQueue_Store verifies that Queues persist their Items
+// in a Store.
+func TestQueue_Store(t *testing.T) {
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "moderation.jsonl")
+
+	s, err := store.OpenFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	q := moderation.NewQueue(&gokismet.Fake{}, s)
+
+	values := map[string]string{"comment_content": "Hello"}
+	it, err := q.Add(ctx, values, &gokismet.CheckResult{
+		Status: gokismet.StatusProbableSpam,
+		Values: values,
+		GUID:   "abc123",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if _, err := q.Approve(ctx, it.ID, "alice"); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	s.Close()
+
+	s, err = store.OpenFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	defer s.Close()
+
+	got, err := moderation.NewQueue(&gokismet.Fake{}, s).Get(ctx, it.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if got.State != moderation.StateApproved || got.DecidedBy != "alice" || !got.Reported {
+		t.Errorf("Expected an approved, reported item, got %+v", got)
+	}
+
+	if got.Result.Status != gokismet.StatusProbableSpam || got.Result.GUID != "abc123" {
+		t.Errorf("Expected probable spam with GUID %q, got %+v", "abc123", got.Result)
+	}
+
+	if got.Values["comment_content"] != "Hello" || !got.Created.Equal(it.Created) {
+		t.Errorf("Expected the original values and creation time, got %+v", got)
+	}
+}
+
+// TestQueue_BorrowedGUID verifies that reports for cached
+// and shared results don't include the GUID of the check
+// they were borrowed from.
+func TestQueue_BorrowedGUID(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := []struct {
+		Cached bool
+		Shared bool
+		GUID   string
+	}{
+		{
+			GUID: "abc123",
+		},
+		{
+			Cached: true,
+		},
+		{
+			Shared: true,
+		},
+	}
+
+	for i, test := range tests {
+
+		fake := &gokismet.Fake{}
+		q := moderation.NewQueue(fake, nil)
+
+		values := map[string]string{"comment_content": "Hello"}
+		it, err := q.Add(ctx, values, &gokismet.CheckResult{
+			Status: gokismet.StatusProbableSpam,
+			Values: values,
+			GUID:   "abc123",
+			Cached: test.Cached,
+			Shared: test.Shared,
+		})
+		if err != nil {
+			t.Fatalf("Test %d: Unexpected error %s", i+1, err)
+		}
+
+		if _, err := q.Approve(ctx, it.ID, "alice"); err != nil {
+			t.Fatalf("Test %d: Unexpected error %s", i+1, err)
+		}
+
+		reports := fake.Reports()
+		if len(reports) != 1 {
+			t.Fatalf("Test %d: Expected 1 report, got %d", i+1, len(reports))
+		}
+
+		if guid := reports[0].Values["guid"]; guid != test.GUID {
+			t.Errorf("Test %d: Expected GUID %q, got %q", i+1, test.GUID, guid)
+		}
+	}
+}
+
+// TestQueue_Purge verifies that Purge removes decided Items
+// and leaves pending ones.
+func TestQueue_Purge(t *testing.T) {
+
+	ctx := context.Background()
+	q := moderation.NewQueue(&gokismet.Fake{}, nil)
+
+	pending := addItem(t, q, "Pending", gokismet.StatusProbableSpam)
+	approved := addItem(t, q, "Approved", gokismet.StatusHam)
+	rejected := addItem(t, q, "Rejected", gokismet.StatusProbableSpam)
+
+	q.Approve(ctx, approved.ID, "alice")
+	q.Reject(ctx, rejected.ID, "alice")
+
+	// Nothing was decided before the cutoff.
+	if n, err := q.Purge(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("Expected 0 items purged, got %d, %v", n, err)
+	}
+
+	if n, err := q.Purge(ctx, time.Now().Add(time.Second)); err != nil || n != 2 {
+		t.Errorf("Expected 2 items purged, got %d, %v", n, err)
+	}
+
+	page, err := q.List(ctx, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if page.Total != 1 || page.Items[0].ID != pending.ID {
+		t.Errorf("Expected only the pending item to remain, got %+v", page.Items)
+	}
+}
@@ -0,0 +1,494 @@
+package moderation
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UIOptions configures the moderation UI.
+type UIOptions struct {
+	// Authorize identifies the moderator making a request.
+	// It returns the moderator's name and true if they are
+	// allowed to use the UI. If Authorize is nil, all
+	// requests are refused.
+	Authorize func(r *http.Request) (moderator string, ok bool)
+
+	// The number of Items per page. Defaults to 50.
+	PageSize int
+
+	// The secret used to generate CSRF tokens. If empty, a
+	// random secret is generated, which means that tokens
+	// are not valid across restarts or between replicas.
+	CSRFKey []byte
+
+	// How long a CSRF token remains valid. Moderators must
+	// reload a page older than this before deciding Items.
+	// Defaults to 12 hours.
+	CSRFMaxAge time.Duration
+}
+
+// NewUI returns an http.Handler that serves a web interface
+// for a moderation Queue. It lists Items, shows their details
+// and lets moderators approve or reject them, singly or in
+// bulk. The handler uses relative URLs, so it can be mounted
+// under any path with http.StripPrefix:
+//
+//	mux.Handle("/moderation/", http.StripPrefix("/moderation", moderation.NewUI(q, opts)))
+//
+// All state-changing requests are protected against
+// cross-site request forgery.
+func NewUI(q *Queue, opts *UIOptions) http.Handler {
+
+	if opts == nil {
+		opts = &UIOptions{}
+	}
+
+	ui := &ui{
+		queue:     q,
+		authorize: opts.Authorize,
+		pageSize:  opts.PageSize,
+		csrfKey:   opts.CSRFKey,
+		csrfAge:   opts.CSRFMaxAge,
+	}
+
+	if ui.pageSize <= 0 {
+		ui.pageSize = 50
+	}
+
+	if ui.csrfAge <= 0 {
+		ui.csrfAge = 12 * time.Hour
+	}
+
+	if len(ui.csrfKey) == 0 {
+		ui.csrfKey = make([]byte, 32)
+		if _, err := rand.Read(ui.csrfKey); err != nil {
+			panic("moderation: failed to generate CSRF key: " + err.Error())
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", ui.handleList)
+	mux.HandleFunc("/item", ui.handleItem)
+	mux.HandleFunc("/decide", ui.handleDecide)
+
+	return ui.authenticate(mux)
+}
+
+// ui serves the moderation interface.
+type ui struct {
+	queue     *Queue
+	authorize func(*http.Request) (string, bool)
+	pageSize  int
+	csrfKey   []byte
+	csrfAge   time.Duration
+}
+
+type moderatorKey struct{}
+
+// authenticate wraps a Handler with the authorisation
+// callback. The moderator's name is stored in the request
+// context.
+func (ui *ui) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		var moderator string
+		ok := false
+		if ui.authorize != nil {
+			moderator, ok = ui.authorize(r)
+		}
+
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), moderatorKey{}, moderator))
+
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'unsafe-inline'")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// moderatorFrom returns the moderator stored in a request
+// context by authenticate.
+func moderatorFrom(r *http.Request) string {
+	moderator, _ := r.Context().Value(moderatorKey{}).(string)
+	return moderator
+}
+
+// csrfToken returns a CSRF token for a moderator. Tokens
+// consist of the time they were issued, in hex Unix seconds,
+// and an HMAC of the moderator and that time.
+func (ui *ui) csrfToken(moderator string) string {
+	issued := strconv.FormatInt(time.Now().Unix(), 16)
+	return issued + "." + ui.csrfMAC(moderator, issued)
+}
+
+func (ui *ui) csrfMAC(moderator, issued string) string {
+	mac := hmac.New(sha256.New, ui.csrfKey)
+	mac.Write([]byte(moderator + "\n" + issued))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validCSRF reports whether a request carries a current CSRF
+// token for its moderator.
+func (ui *ui) validCSRF(r *http.Request) bool {
+
+	issued, sum, ok := strings.Cut(r.PostFormValue("csrf"), ".")
+	if !ok {
+		return false
+	}
+
+	want := ui.csrfMAC(moderatorFrom(r), issued)
+	if !hmac.Equal([]byte(sum), []byte(want)) {
+		return false
+	}
+
+	secs, err := strconv.ParseInt(issued, 16, 64)
+	if err != nil {
+		return false
+	}
+
+	// Allow for a little clock skew between replicas.
+	age := time.Since(time.Unix(secs, 0))
+	return age > -time.Minute && age <= ui.csrfAge
+}
+
+// outcomeMessage returns the summary of a decision passed to
+// the list page by handleDecide. Only the known message is
+// rendered, so links can't inject arbitrary text.
+func outcomeMessage(query url.Values) string {
+
+	done := query.Get("done")
+	if done != "approved" && done != "rejected" {
+		return ""
+	}
+
+	decided, err1 := strconv.Atoi(query.Get("n"))
+	failed, err2 := strconv.Atoi(query.Get("failed"))
+	if err1 != nil || err2 != nil || decided < 0 || failed < 0 {
+		return ""
+	}
+
+	msg := strconv.Itoa(decided) + " item(s) " + done
+	if failed > 0 {
+		msg += ", " + strconv.Itoa(failed) + " failed"
+	}
+
+	return msg
+}
+
+// listData is passed to the list template.
+type listData struct {
+	Moderator string
+	CSRF      string
+	State     State
+	States    []State
+	Items     []*Item
+	Total     int
+	Page      int
+	Prev      string
+	Next      string
+	Message   string
+}
+
+func (ui *ui) handleList(w http.ResponseWriter, r *http.Request) {
+
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	state := State(r.FormValue("state"))
+	if state == "" {
+		state = StatePending
+	}
+
+	page, _ := strconv.Atoi(r.FormValue("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	p, err := ui.queue.List(r.Context(), &Filter{State: state}, (page-1)*ui.pageSize, ui.pageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	moderator := moderatorFrom(r)
+
+	data := &listData{
+		Moderator: moderator,
+		CSRF:      ui.csrfToken(moderator),
+		State:     state,
+		States:    []State{StatePending, StateApproved, StateRejected},
+		Items:     p.Items,
+		Total:     p.Total,
+		Page:      page,
+		Message:   outcomeMessage(r.URL.Query()),
+	}
+
+	if page > 1 {
+		data.Prev = pageURL(state, page-1)
+	}
+	if page*ui.pageSize < p.Total {
+		data.Next = pageURL(state, page+1)
+	}
+
+	render(w, listTemplate, data)
+}
+
+// itemData is passed to the item template.
+type itemData struct {
+	Moderator string
+	CSRF      string
+	Item      *Item
+	Keys      []string
+	Headers   []string
+}
+
+func (ui *ui) handleItem(w http.ResponseWriter, r *http.Request) {
+
+	it, err := ui.queue.Get(r.Context(), r.FormValue("id"))
+	if err == ErrNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	moderator := moderatorFrom(r)
+
+	data := &itemData{
+		Moderator: moderator,
+		CSRF:      ui.csrfToken(moderator),
+		Item:      it,
+	}
+
+	for k := range it.Values {
+		data.Keys = append(data.Keys, k)
+	}
+	sort.Strings(data.Keys)
+
+	if it.Result != nil {
+		for k := range it.Result.Header {
+			if strings.HasPrefix(strings.ToLower(k), "x-akismet-") {
+				data.Headers = append(data.Headers, k)
+			}
+		}
+		sort.Strings(data.Headers)
+	}
+
+	render(w, itemTemplate, data)
+}
+
+// handleDecide approves or rejects Items. The "decide" form
+// value is either "approve" or "reject", applied to each of
+// the checked "id" values, or "approve:<id>" or "reject:<id>"
+// for a single Item.
+func (ui *ui) handleDecide(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	if !ui.validCSRF(r) {
+		http.Error(w, "invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	action, id, _ := strings.Cut(r.PostFormValue("decide"), ":")
+
+	ids := r.PostForm["id"]
+	if id != "" {
+		ids = []string{id}
+	}
+
+	decide, done := ui.queue.Approve, "approved"
+	switch action {
+	case "approve":
+	case "reject":
+		decide, done = ui.queue.Reject, "rejected"
+	default:
+		http.Error(w, "invalid action", http.StatusBadRequest)
+		return
+	}
+
+	moderator := moderatorFrom(r)
+
+	var decided, failed int
+	for _, id := range ids {
+		if _, err := decide(r.Context(), id, moderator); err != nil {
+			failed++
+			continue
+		}
+		decided++
+	}
+
+	back := r.PostFormValue("back")
+	if !strings.HasPrefix(back, "./") {
+		back = "./"
+	}
+
+	u, _ := url.Parse(back)
+	query := u.Query()
+	query.Set("done", done)
+	query.Set("n", strconv.Itoa(decided))
+	query.Set("failed", strconv.Itoa(failed))
+	u.RawQuery = query.Encode()
+
+	// Unlike http.Redirect, leave the URL relative so that
+	// it resolves correctly wherever the UI is mounted.
+	w.Header().Set("Location", u.String())
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+func pageURL(state State, page int) string {
+	return "./?" + url.Values{
+		"state": {string(state)},
+		"page":  {strconv.Itoa(page)},
+	}.Encode()
+}
+
+func render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+var templateFuncs = template.FuncMap{
+	"value": func(it *Item, key string) string {
+		return it.Values[key]
+	},
+	"status": func(it *Item) string {
+		if it.Result == nil {
+			return "unknown"
+		}
+		return it.Result.Status.String()
+	},
+	"header": func(it *Item, key string) string {
+		return it.Result.Header.Get(key)
+	},
+	"truncate": func(s string, n int) string {
+		r := []rune(s)
+		if len(r) <= n {
+			return s
+		}
+		return string(r[:n]) + "…"
+	},
+}
+
+const layout = `
+{{define "head"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Moderation</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.4em; text-align: left; vertical-align: top; }
+.spam { color: #b00; }
+.msg { background: #ffd; padding: 0.5em; }
+</style>
+</head>
+<body>
+<p>Signed in as {{.Moderator}}</p>
+{{end}}
+{{define "foot"}}</body>
+</html>
+{{end}}`
+
+var listTemplate = template.Must(template.New("list").Funcs(templateFuncs).Parse(layout + `
+{{template "head" .}}
+<h1>Moderation queue</h1>
+<p>{{range .States}}<a href="./?state={{.}}">{{.}}</a> {{end}}</p>
+{{with .Message}}<p class="msg">{{.}}</p>{{end}}
+<form method="post" action="./decide">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<input type="hidden" name="back" value="./?state={{.State}}&page={{.Page}}">
+<table>
+<tr><th></th><th>Created</th><th>Author</th><th>IP</th><th>Content</th><th>Status</th><th>Rules</th><th>State</th><th></th></tr>
+{{range .Items}}
+<tr>
+<td>{{if eq .State "pending"}}<input type="checkbox" name="id" value="{{.ID}}">{{end}}</td>
+<td>{{.Created.Format "2006-01-02 15:04"}}</td>
+<td>{{value . "comment_author"}}<br>{{value . "comment_author_email"}}</td>
+<td>{{value . "user_ip"}}</td>
+<td><a href="./item?id={{.ID}}">{{truncate (value . "comment_content") 140}}</a></td>
+<td class="{{status .}}">{{status .}}</td>
+<td>{{range .Rules}}{{.}}<br>{{end}}</td>
+<td>{{.State}}{{with .DecidedBy}} by {{.}}{{end}}</td>
+<td>{{if eq .State "pending"}}
+<button name="decide" value="approve:{{.ID}}">Approve</button>
+<button name="decide" value="reject:{{.ID}}">Reject</button>
+{{end}}</td>
+</tr>
+{{else}}
+<tr><td colspan="9">No items.</td></tr>
+{{end}}
+</table>
+<p>
+<button name="decide" value="approve">Approve selected</button>
+<button name="decide" value="reject">Reject selected</button>
+</p>
+</form>
+<p>{{.Total}} item(s). {{with .Prev}}<a href="{{.}}">Previous</a>{{end}} {{with .Next}}<a href="{{.}}">Next</a>{{end}}</p>
+{{template "foot" .}}`))
+
+var itemTemplate = template.Must(template.New("item").Funcs(templateFuncs).Parse(layout + `
+{{template "head" .}}
+{{with .Item}}
+<p><a href="./?state={{.State}}">Back to queue</a></p>
+<h1>Item {{.ID}}</h1>
+<table>
+<tr><th>Status</th><td class="{{status .}}">{{status .}}</td></tr>
+<tr><th>State</th><td>{{.State}}{{with .DecidedBy}} by {{.}}{{end}}{{if not .DecidedAt.IsZero}} at {{.DecidedAt.Format "2006-01-02 15:04:05"}}{{end}}{{if .Reported}} (reported){{end}}</td></tr>
+<tr><th>Created</th><td>{{.Created.Format "2006-01-02 15:04:05"}}</td></tr>
+{{with .Result}}{{with .GUID}}<tr><th>GUID</th><td>{{.}}</td></tr>{{end}}{{end}}
+<tr><th>Rules</th><td>{{range .Rules}}{{.}}<br>{{else}}none{{end}}</td></tr>
+</table>
+{{end}}
+<h2>Values</h2>
+<table>
+{{range .Keys}}<tr><th>{{.}}</th><td>{{value $.Item .}}</td></tr>
+{{end}}
+</table>
+<h2>Akismet headers</h2>
+<table>
+{{range .Headers}}<tr><th>{{.}}</th><td>{{header $.Item .}}</td></tr>
+{{else}}<tr><td>none</td></tr>
+{{end}}
+</table>
+{{if eq .Item.State "pending"}}
+<form method="post" action="./decide">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<input type="hidden" name="back" value="./item?id={{.Item.ID}}">
+<button name="decide" value="approve:{{.Item.ID}}">Approve</button>
+<button name="decide" value="reject:{{.Item.ID}}">Reject</button>
+</form>
+{{end}}
+{{template "foot" .}}`))
@@ -0,0 +1,297 @@
+package moderation_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepilla/gokismet"
+	"github.com/deepilla/gokismet/moderation"
+)
+
+var reCSRF = regexp.MustCompile(`name="csrf" value="([0-9a-f]+\.[0-9a-f]+)"`)
+
+const testCSRFKey = "csrf-secret"
+
+// newTestUI returns a moderation UI over a Queue containing
+// a single Item, authorised for the moderator "alice".
+func newTestUI(t *testing.T) (http.Handler, *moderation.Queue, *gokismet.Fake, *moderation.Item) {
+
+	fake := &gokismet.Fake{}
+	q := moderation.NewQueue(fake, nil)
+
+	values := map[string]string{
+		"comment_author":  "A. Spammer",
+		"user_ip":         "203.0.113.7",
+		"comment_content": "Buy <b>cheap</b> pills",
+	}
+
+	v := &gokismet.Verdict{
+		Status:    gokismet.StatusProbableSpam,
+		DecidedBy: "rules",
+		Reason:    "pills: comment_content contains \"pills\"",
+		Trail: []gokismet.StageOutcome{
+			{
+				Stage:       "rules",
+				Status:      gokismet.StatusProbableSpam,
+				Reason:      "pills: comment_content contains \"pills\"",
+				Annotations: map[string]string{"rule": "pills"},
+			},
+		},
+	}
+
+	it, err := q.AddVerdict(context.Background(), values, v)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	ui := moderation.NewUI(q, &moderation.UIOptions{
+		Authorize: func(r *http.Request) (string, bool) {
+			return "alice", r.Header.Get("Authorization") == "let-me-in"
+		},
+		CSRFKey: []byte(testCSRFKey),
+	})
+
+	return ui, q, fake, it
+}
+
+func serve(h http.Handler, method, target string, form url.Values) *httptest.ResponseRecorder {
+
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, target, nil)
+	}
+	req.Header.Set("Authorization", "let-me-in")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// TestUI_Pages verifies the content of the moderation pages.
+func TestUI_Pages(t *testing.T) {
+
+	ui, _, _, it := newTestUI(t)
+
+	for _, target := range []string{"/", "/item?id=" + it.ID} {
+
+		rec := serve(ui, "GET", target, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: Expected HTTP Status %d, got %d", target, http.StatusOK, rec.Code)
+		}
+
+		body := rec.Body.String()
+
+		for _, s := range []string{
+			"A. Spammer",
+			"203.0.113.7",
+			// Content is escaped.
+			"Buy &lt;b&gt;cheap&lt;/b&gt; pills",
+			"probable-spam",
+			"pills: comment_content contains &#34;pills&#34;",
+		} {
+			if !strings.Contains(body, s) {
+				t.Errorf("%s: Expected page to contain %q", target, s)
+			}
+		}
+	}
+
+	if rec := serve(ui, "GET", "/item?id=missing", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected HTTP Status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+// TestUI_Authorize verifies that unauthorised requests are
+// refused.
+func TestUI_Authorize(t *testing.T) {
+
+	ui, _, _, _ := newTestUI(t)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	ui.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected HTTP Status %d, got %d", http.StatusForbidden, rec.Code)
+	}
+
+	// A nil Authorize function refuses everything.
+	q := moderation.NewQueue(&gokismet.Fake{}, nil)
+	if rec := serve(moderation.NewUI(q, nil), "GET", "/", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected HTTP Status %d, got %d", http.StatusForbidden, rec.Code)
+	}
+}
+
+// TestUI_Decide verifies that moderators can approve and
+// reject Items, and that decisions require a CSRF token.
+func TestUI_Decide(t *testing.T) {
+
+	ui, q, fake, it := newTestUI(t)
+	ctx := context.Background()
+
+	m := reCSRF.FindStringSubmatch(serve(ui, "GET", "/", nil).Body.String())
+	if m == nil {
+		t.Fatal("Expected a CSRF token in the page")
+	}
+	token := m[1]
+
+	// No token, no decision.
+	rec := serve(ui, "POST", "/decide", url.Values{
+		"decide": {"approve:" + it.ID},
+	})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected HTTP Status %d, got %d", http.StatusForbidden, rec.Code)
+	}
+
+	rec = serve(ui, "POST", "/decide", url.Values{
+		"csrf":   {token},
+		"decide": {"approve:" + it.ID},
+	})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected HTTP Status %d, got %d", http.StatusSeeOther, rec.Code)
+	}
+
+	got, _ := q.Get(ctx, it.ID)
+	if got.State != moderation.StateApproved || got.DecidedBy != "alice" {
+		t.Errorf("Expected item approved by alice, got %+v", got)
+	}
+
+	if reports := fake.Reports(); len(reports) != 1 || reports[0].Spam {
+		t.Errorf("Expected 1 ham report, got %+v", reports)
+	}
+
+	// Bulk decisions apply to all checked items.
+	var ids []string
+	for _, content := range []string{"one", "two"} {
+		values := map[string]string{"comment_content": content}
+		it, _ := q.Add(ctx, values, &gokismet.CheckResult{Status: gokismet.StatusHam, Values: values})
+		ids = append(ids, it.ID)
+	}
+
+	rec = serve(ui, "POST", "/decide", url.Values{
+		"csrf":   {token},
+		"decide": {"reject"},
+		"id":     append(ids, it.ID),
+	})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected HTTP Status %d, got %d", http.StatusSeeOther, rec.Code)
+	}
+
+	loc := rec.Header().Get("Location")
+	if !strings.Contains(loc, "done=rejected&failed=1&n=2") {
+		t.Errorf("Expected a summary in the redirect, got %q", loc)
+	}
+
+	if body := serve(ui, "GET", strings.TrimPrefix(loc, "."), nil).Body.String(); !strings.Contains(body, "2 item(s) rejected, 1 failed") {
+		t.Errorf("Expected the summary to be displayed")
+	}
+
+	page, _ := q.List(ctx, &moderation.Filter{State: moderation.StateRejected}, 0, 0)
+	if page.Total != 2 {
+		t.Errorf("Expected 2 rejected items, got %d", page.Total)
+	}
+
+	if reports := fake.Reports(); len(reports) != 3 {
+		t.Errorf("Expected 3 reports, got %d", len(reports))
+	}
+}
+
+// csrfToken returns a CSRF token for alice issued at the
+// given time.
+func csrfToken(issued time.Time) string {
+	ts := strconv.FormatInt(issued.Unix(), 16)
+	mac := hmac.New(sha256.New, []byte(testCSRFKey))
+	mac.Write([]byte("alice\n" + ts))
+	return ts + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// TestUI_CSRF verifies that CSRF tokens expire and can't be
+// altered.
+func TestUI_CSRF(t *testing.T) {
+
+	ui, q, _, it := newTestUI(t)
+
+	fresh := csrfToken(time.Now())
+	ts, sum, _ := strings.Cut(csrfToken(time.Now().Add(-24*time.Hour)), ".")
+
+	for i, token := range []string{
+		ts + "." + sum,
+		// A new timestamp invalidates the HMAC.
+		strings.SplitN(fresh, ".", 2)[0] + "." + sum,
+		"",
+		"nonsense",
+	} {
+		rec := serve(ui, "POST", "/decide", url.Values{
+			"csrf":   {token},
+			"decide": {"approve:" + it.ID},
+		})
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Token %d: Expected HTTP Status %d, got %d", i+1, http.StatusForbidden, rec.Code)
+		}
+	}
+
+	rec := serve(ui, "POST", "/decide", url.Values{
+		"csrf":   {fresh},
+		"decide": {"approve:" + it.ID},
+	})
+	if rec.Code != http.StatusSeeOther {
+		t.Errorf("Expected HTTP Status %d, got %d", http.StatusSeeOther, rec.Code)
+	}
+
+	if got, _ := q.Get(context.Background(), it.ID); got.State != moderation.StateApproved {
+		t.Errorf("Expected the item to be approved, got %s", got.State)
+	}
+}
+
+// TestUI_Message verifies that only genuine decision
+// summaries are displayed.
+func TestUI_Message(t *testing.T) {
+
+	ui, _, _, _ := newTestUI(t)
+
+	tests := []struct {
+		Query   string
+		Message string
+	}{
+		{
+			Query:   "done=approved&n=3&failed=0",
+			Message: "3 item(s) approved",
+		},
+		{
+			Query: "done=Call+0800-SCAMMER&n=1&failed=0",
+		},
+		{
+			Query: "msg=Your+session+expired.+Log+in+at+evil.example",
+		},
+		{
+			Query: "done=rejected&n=lots&failed=0",
+		},
+	}
+
+	for i, test := range tests {
+
+		body := serve(ui, "GET", "/?"+test.Query, nil).Body.String()
+
+		hasMsg := strings.Contains(body, `class="msg"`)
+		if hasMsg != (test.Message != "") {
+			t.Errorf("Test %d: Expected message %v, got %v", i+1, test.Message != "", hasMsg)
+		}
+
+		if test.Message != "" && !strings.Contains(body, test.Message) {
+			t.Errorf("Test %d: Expected message %q", i+1, test.Message)
+		}
+	}
+}
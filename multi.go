@@ -0,0 +1,294 @@
+package gokismet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// A Strategy determines how a MultiChecker combines the
+// results from its members.
+type Strategy int
+
+const (
+	// StrategyAnySpam flags content as spam if any member
+	// does. The most severe spam status wins.
+	StrategyAnySpam Strategy = iota
+
+	// StrategyMajority flags content as spam if more than
+	// half of the members that responded do. The spam is
+	// definite if more than half of them said so.
+	StrategyMajority
+
+	// StrategyPrimary uses the result of the first member
+	// to respond successfully, in the order the members
+	// are listed. Later members act as fallbacks.
+	StrategyPrimary
+
+	// StrategyWeighted flags content as spam if the weighted
+	// proportion of spam results, among the members that
+	// responded, reaches the MultiChecker's Threshold.
+	StrategyWeighted
+)
+
+// String returns the name of a Strategy, e.g. "any-spam".
+func (s Strategy) String() string {
+	switch s {
+	case StrategyAnySpam:
+		return "any-spam"
+	case StrategyMajority:
+		return "majority"
+	case StrategyPrimary:
+		return "primary-with-fallback"
+	case StrategyWeighted:
+		return "weighted"
+	default:
+		return "unknown"
+	}
+}
+
+// A Member is one of the SpamCheckers consulted by a
+// MultiChecker.
+type Member struct {
+	// A name identifying the member in ProviderVerdicts
+	// and errors.
+	Name string
+	// The SpamChecker to consult.
+	Checker SpamChecker
+	// The member's weight under StrategyWeighted. Defaults
+	// to 1.
+	Weight float64
+}
+
+// A ProviderVerdict records the result of a check by a single
+// MultiChecker member.
+type ProviderVerdict struct {
+	// The member's name.
+	Name string
+	// The member's spam status, or StatusUnknown if the
+	// check failed.
+	Status SpamStatus
+	// The member's full result, if the check succeeded.
+	Result *CheckResult
+	// The error returned by the member, if any.
+	Err error
+}
+
+// A MultiChecker is a SpamChecker that consults several other
+// SpamCheckers concurrently and combines their results. Use it
+// to compare Akismet with a compatible service or a local
+// classifier and decide by policy.
+//
+// A MultiChecker's fields must not be modified once it is in
+// use. It is safe for concurrent use if its members are.
+type MultiChecker struct {
+	// The SpamCheckers to consult, in order of preference.
+	Members []Member
+	// How to combine the members' results.
+	Strategy Strategy
+	// The minimum weighted proportion of spam results needed
+	// to flag content as spam under StrategyWeighted. Defaults
+	// to 0.5.
+	Threshold float64
+}
+
+// MultiChecker implements SpamChecker.
+var _ SpamChecker = (*MultiChecker)(nil)
+
+// Check checks a set of key-value pairs with all of the
+// MultiChecker's members and returns the combined status.
+func (mc *MultiChecker) Check(values map[string]string) (SpamStatus, error) {
+
+	result, err := mc.CheckContext(context.Background(), values)
+	if err != nil {
+		return StatusUnknown, err
+	}
+
+	return result.Status, nil
+}
+
+// CheckContext is like Check except that it takes a Context
+// and returns a CheckResult. The result's Verdicts field
+// contains the individual result from each member, and its
+// GUID is taken from the first member to respond successfully.
+// CheckContext returns an error only if every member fails.
+func (mc *MultiChecker) CheckContext(ctx context.Context, values map[string]string) (*CheckResult, error) {
+
+	verdicts := make([]ProviderVerdict, len(mc.Members))
+
+	var wg sync.WaitGroup
+
+	for i, m := range mc.Members {
+		wg.Add(1)
+		go func(i int, m Member) {
+			defer wg.Done()
+
+			v := ProviderVerdict{Name: m.Name}
+			v.Result, v.Err = m.Checker.CheckContext(ctx, values)
+			if v.Err == nil {
+				v.Status = v.Result.Status
+			}
+
+			verdicts[i] = v
+		}(i, m)
+	}
+
+	wg.Wait()
+
+	var errs []error
+	result := &CheckResult{
+		Values:   values,
+		Verdicts: verdicts,
+	}
+
+	for _, v := range verdicts {
+		if v.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", v.Name, v.Err))
+			continue
+		}
+		if result.GUID == "" {
+			result.GUID = v.Result.GUID
+		}
+	}
+
+	if len(errs) == len(verdicts) {
+		if len(errs) == 0 {
+			return nil, errors.New("no members to check with")
+		}
+		return nil, errors.Join(errs...)
+	}
+
+	result.Status = mc.combine(verdicts)
+
+	return result, nil
+}
+
+// combine applies the MultiChecker's Strategy to the
+// results of its members. At least one member must have
+// responded successfully.
+func (mc *MultiChecker) combine(verdicts []ProviderVerdict) SpamStatus {
+
+	switch mc.Strategy {
+
+	case StrategyPrimary:
+		for _, v := range verdicts {
+			if v.Err == nil {
+				return v.Status
+			}
+		}
+
+	case StrategyMajority:
+		var responded, spam, definite int
+		for _, v := range verdicts {
+			if v.Err != nil {
+				continue
+			}
+			responded++
+			switch v.Status {
+			case StatusDefiniteSpam:
+				definite++
+				spam++
+			case StatusProbableSpam:
+				spam++
+			}
+		}
+		switch {
+		case definite*2 > responded:
+			return StatusDefiniteSpam
+		case spam*2 > responded:
+			return StatusProbableSpam
+		}
+
+	case StrategyWeighted:
+		threshold := mc.Threshold
+		if threshold <= 0 {
+			threshold = 0.5
+		}
+		var total, spam, definite float64
+		for i, v := range verdicts {
+			if v.Err != nil {
+				continue
+			}
+			w := mc.Members[i].Weight
+			if w <= 0 {
+				w = 1
+			}
+			total += w
+			switch v.Status {
+			case StatusDefiniteSpam:
+				definite += w
+				spam += w
+			case StatusProbableSpam:
+				spam += w
+			}
+		}
+		switch {
+		case definite/total >= threshold:
+			return StatusDefiniteSpam
+		case spam/total >= threshold:
+			return StatusProbableSpam
+		}
+
+	default:
+		status := StatusHam
+		for _, v := range verdicts {
+			if v.Err == nil && v.Status > status {
+				status = v.Status
+			}
+		}
+		return status
+	}
+
+	return StatusHam
+}
+
+// ReportHam reports legitimate content to all of the
+// MultiChecker's members.
+func (mc *MultiChecker) ReportHam(values map[string]string) error {
+	return mc.ReportHamContext(context.Background(), values)
+}
+
+// ReportHamContext is like ReportHam except that it takes
+// a Context.
+func (mc *MultiChecker) ReportHamContext(ctx context.Context, values map[string]string) error {
+	return mc.report(ctx, values, SpamChecker.ReportHamContext)
+}
+
+// ReportSpam reports spam to all of the MultiChecker's
+// members.
+func (mc *MultiChecker) ReportSpam(values map[string]string) error {
+	return mc.ReportSpamContext(context.Background(), values)
+}
+
+// ReportSpamContext is like ReportSpam except that it takes
+// a Context.
+func (mc *MultiChecker) ReportSpamContext(ctx context.Context, values map[string]string) error {
+	return mc.report(ctx, values, SpamChecker.ReportSpamContext)
+}
+
+// report sends a report to every member concurrently. Members
+// that don't support reports are skipped. The returned error
+// combines the errors from any members that failed.
+func (mc *MultiChecker) report(ctx context.Context, values map[string]string, fn func(SpamChecker, context.Context, map[string]string) error) error {
+
+	errs := make([]error, len(mc.Members))
+
+	var wg sync.WaitGroup
+
+	for i, m := range mc.Members {
+		wg.Add(1)
+		go func(i int, m Member) {
+			defer wg.Done()
+
+			err := fn(m.Checker, ctx, values)
+			if err != nil && !errors.Is(err, ErrUnsupported) {
+				errs[i] = fmt.Errorf("%s: %w", m.Name, err)
+			}
+		}(i, m)
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,221 @@
+package gokismet_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/deepilla/gokismet"
+)
+
+// fakeWith returns a Fake that returns the given status and
+// error for all content.
+func fakeWith(status gokismet.SpamStatus, err error) *gokismet.Fake {
+	return &gokismet.Fake{
+		Rules: []gokismet.FakeRule{
+			{Key: "comment_content", Status: status, Err: err},
+		},
+	}
+}
+
+// TestMultiChecker verifies that MultiCheckers combine their
+// members' results according to their Strategy.
+func TestMultiChecker(t *testing.T) {
+
+	errDown := errors.New("service unavailable")
+
+	ham := fakeWith(gokismet.StatusHam, nil)
+	probable := fakeWith(gokismet.StatusProbableSpam, nil)
+	definite := fakeWith(gokismet.StatusDefiniteSpam, nil)
+	broken := fakeWith(gokismet.StatusUnknown, errDown)
+
+	tests := []struct {
+		Strategy  gokismet.Strategy
+		Members   []gokismet.SpamChecker
+		Weights   []float64
+		Threshold float64
+		Status    gokismet.SpamStatus
+		IsError   bool
+	}{
+		{
+			Strategy: gokismet.StrategyAnySpam,
+			Members:  []gokismet.SpamChecker{ham, ham},
+			Status:   gokismet.StatusHam,
+		},
+		{
+			Strategy: gokismet.StrategyAnySpam,
+			Members:  []gokismet.SpamChecker{ham, probable, definite},
+			Status:   gokismet.StatusDefiniteSpam,
+		},
+		{
+			// Failed members are ignored...
+			Strategy: gokismet.StrategyAnySpam,
+			Members:  []gokismet.SpamChecker{broken, probable},
+			Status:   gokismet.StatusProbableSpam,
+		},
+		{
+			// ...unless they all fail.
+			Strategy: gokismet.StrategyAnySpam,
+			Members:  []gokismet.SpamChecker{broken, broken},
+			Status:   gokismet.StatusUnknown,
+			IsError:  true,
+		},
+		{
+			Strategy: gokismet.StrategyMajority,
+			Members:  []gokismet.SpamChecker{ham, probable, ham},
+			Status:   gokismet.StatusHam,
+		},
+		{
+			Strategy: gokismet.StrategyMajority,
+			Members:  []gokismet.SpamChecker{ham, probable, definite},
+			Status:   gokismet.StatusProbableSpam,
+		},
+		{
+			Strategy: gokismet.StrategyMajority,
+			Members:  []gokismet.SpamChecker{definite, broken, definite, ham},
+			Status:   gokismet.StatusDefiniteSpam,
+		},
+		{
+			// Ties go to ham.
+			Strategy: gokismet.StrategyMajority,
+			Members:  []gokismet.SpamChecker{ham, probable},
+			Status:   gokismet.StatusHam,
+		},
+		{
+			Strategy: gokismet.StrategyPrimary,
+			Members:  []gokismet.SpamChecker{ham, definite},
+			Status:   gokismet.StatusHam,
+		},
+		{
+			Strategy: gokismet.StrategyPrimary,
+			Members:  []gokismet.SpamChecker{broken, definite},
+			Status:   gokismet.StatusDefiniteSpam,
+		},
+		{
+			Strategy: gokismet.StrategyWeighted,
+			Members:  []gokismet.SpamChecker{ham, probable},
+			Weights:  []float64{3, 1},
+			Status:   gokismet.StatusHam,
+		},
+		{
+			Strategy: gokismet.StrategyWeighted,
+			Members:  []gokismet.SpamChecker{ham, probable},
+			Weights:  []float64{1, 3},
+			Status:   gokismet.StatusProbableSpam,
+		},
+		{
+			Strategy:  gokismet.StrategyWeighted,
+			Members:   []gokismet.SpamChecker{ham, definite, ham},
+			Threshold: 0.3,
+			Status:    gokismet.StatusDefiniteSpam,
+		},
+	}
+
+	for i, test := range tests {
+
+		mc := &gokismet.MultiChecker{
+			Strategy:  test.Strategy,
+			Threshold: test.Threshold,
+		}
+
+		for j, sc := range test.Members {
+			m := gokismet.Member{Name: string(rune('a' + j)), Checker: sc}
+			if test.Weights != nil {
+				m.Weight = test.Weights[j]
+			}
+			mc.Members = append(mc.Members, m)
+		}
+
+		result, err := mc.CheckContext(context.Background(), map[string]string{"comment_content": "Hello"})
+
+		if isErr := err != nil; isErr != test.IsError {
+			t.Errorf("Test %d (%s): Expected error %v, got %v", i+1, test.Strategy, test.IsError, err)
+		}
+
+		if err != nil {
+			if !errors.Is(err, errDown) {
+				t.Errorf("Test %d (%s): Expected error to wrap %v, got %v", i+1, test.Strategy, errDown, err)
+			}
+			continue
+		}
+
+		if result.Status != test.Status {
+			t.Errorf("Test %d (%s): Expected Spam Status %q, got %q", i+1, test.Strategy,
+				statusToString(test.Status), statusToString(result.Status))
+		}
+
+		if len(result.Verdicts) != len(test.Members) {
+			t.Errorf("Test %d (%s): Expected %d verdicts, got %d", i+1, test.Strategy,
+				len(test.Members), len(result.Verdicts))
+		}
+	}
+}
+
+// TestMultiChecker_Verdicts verifies that MultiCheckers
+// record each member's result.
+func TestMultiChecker_Verdicts(t *testing.T) {
+
+	errDown := errors.New("service unavailable")
+
+	mc := &gokismet.MultiChecker{
+		Members: []gokismet.Member{
+			{Name: "broken", Checker: fakeWith(gokismet.StatusUnknown, errDown)},
+			{Name: "primary", Checker: fakeWith(gokismet.StatusHam, nil)},
+			{Name: "secondary", Checker: fakeWith(gokismet.StatusProbableSpam, nil)},
+		},
+	}
+
+	result, err := mc.CheckContext(context.Background(), map[string]string{"comment_content": "Hello"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	exp := []struct {
+		Name   string
+		Status gokismet.SpamStatus
+		Err    error
+	}{
+		{"broken", gokismet.StatusUnknown, errDown},
+		{"primary", gokismet.StatusHam, nil},
+		{"secondary", gokismet.StatusProbableSpam, nil},
+	}
+
+	for i, e := range exp {
+		v := result.Verdicts[i]
+		if v.Name != e.Name || v.Status != e.Status || v.Err != e.Err {
+			t.Errorf("Verdict %d: Expected %s/%s/%v, got %s/%s/%v", i+1,
+				e.Name, e.Status, e.Err, v.Name, v.Status, v.Err)
+		}
+	}
+
+	if result.GUID != result.Verdicts[1].Result.GUID {
+		t.Errorf("Expected GUID %q from the first successful member, got %q",
+			result.Verdicts[1].Result.GUID, result.GUID)
+	}
+}
+
+// TestMultiChecker_Report verifies that MultiCheckers send
+// reports to all of their members.
+func TestMultiChecker_Report(t *testing.T) {
+
+	f1 := &gokismet.Fake{}
+	f2 := &gokismet.Fake{}
+
+	mc := &gokismet.MultiChecker{
+		Members: []gokismet.Member{
+			{Name: "one", Checker: f1},
+			{Name: "two", Checker: f2},
+		},
+	}
+
+	if err := mc.ReportSpam(map[string]string{"comment_content": "spam"}); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	for i, f := range []*gokismet.Fake{f1, f2} {
+		reports := f.Reports()
+		if len(reports) != 1 || !reports[0].Spam {
+			t.Errorf("Member %d: Expected 1 spam report, got %+v", i+1, reports)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package gokismet
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// An Observer is notified at the start and end of every
+// Akismet call made by a Checker. Use an Observer to collect
+// metrics, traces or logs. Observer methods are called
+// synchronously so implementations should return quickly.
+// They must also be safe for concurrent use.
+type Observer interface {
+	// CallStarted is called before a Checker makes
+	// an Akismet call.
+	CallStarted(info *CallInfo)
+	// CallFinished is called after an Akismet call
+	// completes. The CallInfo is the one passed to
+	// CallStarted, updated with the outcome of the
+	// call.
+	CallFinished(info *CallInfo)
+}
+
+// An ErrorClass broadly categorises the errors returned by
+// the Checker methods.
+type ErrorClass string
+
+// Error classes reported to Observers.
+const (
+	// ErrorClassNone means that no error occurred.
+	ErrorClassNone ErrorClass = ""
+
+	// ErrorClassNetwork means that the HTTP request
+	// failed, e.g. due to a network problem.
+	ErrorClassNetwork ErrorClass = "network"
+
+	// ErrorClassCanceled means that the call's Context
+	// was cancelled or timed out.
+	ErrorClassCanceled ErrorClass = "canceled"
+
+	// ErrorClassHTTP means that Akismet returned a
+	// non-200 HTTP status.
+	ErrorClassHTTP ErrorClass = "http"
+
+	// ErrorClassResponse means that Akismet returned an
+	// unexpected response (see ValError).
+	ErrorClassResponse ErrorClass = "response"
+
+	// ErrorClassKey means that Akismet failed to verify
+	// the API key (see KeyError).
+	ErrorClassKey ErrorClass = "key"
+)
+
+// A CallInfo describes a single Akismet call.
+type CallInfo struct {
+	// The Akismet method, e.g. "comment-check".
+	Method string
+	// The time the call started.
+	Start time.Time
+	// The duration of the call. Set on completion.
+	Duration time.Duration
+	// The number of HTTP requests made, including retries.
+	// Zero for results served from a Cache.
+	Attempts int
+	// Was the result served from a Cache?
+	Cached bool
+	// The HTTP status of the last response from Akismet,
+	// or zero if no response was received.
+	HTTPStatus int
+	// The result of a comment-check call. StatusUnknown for
+	// other methods and failed checks.
+	Status SpamStatus
+	// The error returned by the call, if any.
+	Err error
+	// The category of the error, if any.
+	ErrorClass ErrorClass
+}
+
+// retryable reports whether a failed call can be retried.
+// Network errors and server errors are retried. Anything else
+// is unlikely to succeed the second time around.
+func (info *CallInfo) retryable() bool {
+	switch info.ErrorClass {
+	case ErrorClassNetwork:
+		return true
+	case ErrorClassHTTP:
+		return info.HTTPStatus >= http.StatusInternalServerError
+	default:
+		return false
+	}
+}
+
+// WithObserver returns an Option that notifies the given
+// Observer of every Akismet call made by a Checker.
+func WithObserver(o Observer) Option {
+	return func(ch *Checker) {
+		ch.observer = o
+	}
+}
+
+// WithRetries returns an Option that retries Akismet calls
+// that fail due to network errors or server errors, up to
+// the given number of times. The delay between attempts
+// starts at backoff and doubles after each failure.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(ch *Checker) {
+		ch.retries = retries
+		ch.backoff = backoff
+	}
+}
+
+// startCall notifies a Checker's Observer that an Akismet call
+// is starting.
+func (ch *Checker) startCall(method string) *CallInfo {
+
+	info := &CallInfo{
+		Method: method,
+		Start:  time.Now(),
+	}
+
+	if ch.observer != nil {
+		ch.observer.CallStarted(info)
+	}
+
+	return info
+}
+
+// finishCall notifies a Checker's Observer and Logger that an
+// Akismet call has finished.
+func (ch *Checker) finishCall(ctx context.Context, info *CallInfo, status SpamStatus, err error) {
+
+	info.Duration = time.Since(info.Start)
+	info.Status = status
+	info.Err = err
+
+	var keyErr *KeyError
+	var valErr *ValError
+
+	switch {
+	case err == nil:
+		info.ErrorClass = ErrorClassNone
+	case errors.As(err, &keyErr):
+		info.ErrorClass = ErrorClassKey
+	case errors.As(err, &valErr):
+		info.ErrorClass = ErrorClassResponse
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		info.ErrorClass = ErrorClassCanceled
+	case info.ErrorClass == ErrorClassNone:
+		info.ErrorClass = ErrorClassNetwork
+	}
+
+	ch.logCall(ctx, info)
+
+	if ch.observer != nil {
+		ch.observer.CallFinished(info)
+	}
+}
@@ -0,0 +1,156 @@
+package gokismet_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deepilla/gokismet"
+)
+
+// A recordingObserver is an Observer that keeps a copy of
+// every finished call.
+type recordingObserver struct {
+	mu       sync.Mutex
+	started  int
+	finished []gokismet.CallInfo
+}
+
+func (o *recordingObserver) CallStarted(info *gokismet.CallInfo) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.started++
+}
+
+func (o *recordingObserver) CallFinished(info *gokismet.CallInfo) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.finished = append(o.finished, *info)
+}
+
+// A flakyResponder is a mock Client that fails a number of
+// times before handing off to a Responder.
+type flakyResponder struct {
+	failures int
+	*Responder
+}
+
+func (r *flakyResponder) Do(req *http.Request) (*http.Response, error) {
+	if r.failures > 0 {
+		r.failures--
+		return NewResponse(&ResponseInfo{StatusCode: http.StatusServiceUnavailable}), nil
+	}
+	return r.Responder.Do(req)
+}
+
+// TestObserver verifies that Observers receive the correct
+// details of each Akismet call.
+func TestObserver(t *testing.T) {
+
+	client := &flakyResponder{
+		failures: 1,
+		Responder: &Responder{
+			Responses: map[string]*ResponseInfo{
+				"verify-key": {
+					Body:       "valid",
+					StatusCode: http.StatusOK,
+				},
+				"comment-check": {
+					Body:       "true",
+					StatusCode: http.StatusOK,
+					HeaderItems: map[string]string{
+						"X-akismet-pro-tip": "discard",
+					},
+				},
+				"submit-ham": {
+					Body:       "invalid",
+					StatusCode: http.StatusOK,
+				},
+			},
+		},
+	}
+
+	obs := &recordingObserver{}
+
+	ch := gokismet.NewCheckerClient(TestAPIKey, TestSite, client,
+		gokismet.WithObserver(obs),
+		gokismet.WithRetries(2, time.Millisecond),
+		gokismet.WithCache(gokismet.NewLRUCache(10, 0), nil),
+	)
+
+	values := map[string]string{
+		"comment_author": "viagra-test-123",
+	}
+
+	ch.CheckContext(context.Background(), values)
+	ch.CheckContext(context.Background(), values)
+	ch.ReportHam(values)
+
+	expected := []gokismet.CallInfo{
+		{
+			// The first verify-key request fails with
+			// a 503 and is retried.
+			Method:     "verify-key",
+			Attempts:   2,
+			HTTPStatus: http.StatusOK,
+		},
+		{
+			Method:     "comment-check",
+			Attempts:   1,
+			HTTPStatus: http.StatusOK,
+			Status:     gokismet.StatusDefiniteSpam,
+		},
+		{
+			Method: "comment-check",
+			Cached: true,
+			Status: gokismet.StatusDefiniteSpam,
+		},
+		{
+			Method:     "submit-ham",
+			Attempts:   1,
+			HTTPStatus: http.StatusOK,
+			ErrorClass: gokismet.ErrorClassResponse,
+		},
+	}
+
+	if obs.started != len(expected) {
+		t.Errorf("Expected %d started calls, got %d", len(expected), obs.started)
+	}
+
+	if len(obs.finished) != len(expected) {
+		t.Fatalf("Expected %d finished calls, got %d", len(expected), len(obs.finished))
+	}
+
+	for i, exp := range expected {
+
+		got := obs.finished[i]
+
+		if got.Method != exp.Method {
+			t.Errorf("Call %d: Expected Method %q, got %q", i+1, exp.Method, got.Method)
+		}
+		if got.Attempts != exp.Attempts {
+			t.Errorf("Call %d: Expected %d attempt(s), got %d", i+1, exp.Attempts, got.Attempts)
+		}
+		if got.Cached != exp.Cached {
+			t.Errorf("Call %d: Expected Cached to be %v, got %v", i+1, exp.Cached, got.Cached)
+		}
+		if got.HTTPStatus != exp.HTTPStatus {
+			t.Errorf("Call %d: Expected HTTP Status %d, got %d", i+1, exp.HTTPStatus, got.HTTPStatus)
+		}
+		if got.Status != exp.Status {
+			t.Errorf("Call %d: Expected Spam Status %q, got %q", i+1,
+				statusToString(exp.Status), statusToString(got.Status))
+		}
+		if got.ErrorClass != exp.ErrorClass {
+			t.Errorf("Call %d: Expected Error Class %q, got %q", i+1, exp.ErrorClass, got.ErrorClass)
+		}
+		if isErr := got.Err != nil; isErr != (exp.ErrorClass != gokismet.ErrorClassNone) {
+			t.Errorf("Call %d: Unexpected error %v", i+1, got.Err)
+		}
+		if got.Start.IsZero() {
+			t.Errorf("Call %d: Expected a start time", i+1)
+		}
+	}
+}
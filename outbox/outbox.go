@@ -0,0 +1,466 @@
+/*
+Package outbox provides durable delivery of spam reports.
+
+Reports to Akismet are feedback: nothing waits for them, so
+a failed ReportHam or ReportSpam call is easily lost. An
+Outbox persists reports in a store.Store and delivers them
+in the background, retrying failures with exponential
+backoff:
+
+	ob := outbox.New(checker, s, nil)
+	go ob.Run(ctx)
+
+	// Returns as soon as the report is stored.
+	err := ob.ReportSpam(ctx, values)
+
+Repeat reports of the same content are ignored while an
+earlier report is pending or delivered.
+*/
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/deepilla/gokismet"
+	"github.com/deepilla/gokismet/internal/retry"
+	"github.com/deepilla/gokismet/store"
+)
+
+// Collection is the name of the store collection that holds
+// an Outbox's reports.
+const Collection = "outbox"
+
+// A Kind is the type of a Report.
+type Kind string
+
+const (
+	// KindHam is a report of legitimate content (ReportHam).
+	KindHam Kind = "ham"
+	// KindSpam is a report of spam (ReportSpam).
+	KindSpam Kind = "spam"
+)
+
+// A State is the delivery state of a Report.
+type State string
+
+const (
+	// StatePending means the Report is awaiting delivery.
+	StatePending State = "pending"
+	// StateDelivered means the Report was accepted.
+	StateDelivered State = "delivered"
+	// StateFailed means the Report could not be delivered
+	// and will not be retried automatically.
+	StateFailed State = "failed"
+)
+
+// ErrNotFound is returned when no Report has the given ID.
+var ErrNotFound = errors.New("outbox: report not found")
+
+// A Report is a ReportHam or ReportSpam request held in an
+// Outbox.
+type Report struct {
+	// The Report's ID, derived from its kind and values.
+	ID string `json:"id"`
+	// Ham or spam.
+	Kind Kind `json:"kind"`
+	// The key-value pairs to report.
+	Values map[string]string `json:"values"`
+	// The Report's delivery state.
+	State State `json:"state"`
+	// The number of delivery attempts made.
+	Attempts int `json:"attempts"`
+	// The error from the last failed attempt, if any.
+	LastError string `json:"last_error,omitempty"`
+	// When the Report was added.
+	Created time.Time `json:"created"`
+	// When the next delivery attempt is due.
+	NextAttempt time.Time `json:"next_attempt"`
+	// When the Report was delivered.
+	Delivered time.Time `json:"delivered"`
+}
+
+// Options configures an Outbox.
+type Options struct {
+	// The maximum number of delivery attempts before a
+	// Report is marked as failed. Defaults to 10.
+	MaxAttempts int
+	// The delay before the first retry. The delay doubles
+	// after each failed attempt. Defaults to 30 seconds.
+	Backoff time.Duration
+	// The maximum delay between attempts. Defaults to 1 hour.
+	MaxBackoff time.Duration
+	// How often Run looks for due reports. Defaults to 10
+	// seconds.
+	PollInterval time.Duration
+	// How long delivered reports are kept for
+	// deduplication. Defaults to 7 days.
+	Retention time.Duration
+	// The keys used to identify repeat reports of the same
+	// content (see gokismet.HashValues). If nil, all keys
+	// are used.
+	KeyFilter *gokismet.KeyFilter
+	// Called with any error that stops Run from reading or
+	// updating the Outbox's Store. Delivery errors are not
+	// passed to OnError; they are kept in each Report's
+	// LastError. If nil, storage errors are ignored and Run
+	// tries again at the next poll.
+	OnError func(err error)
+	// Returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Stats summarises the contents of an Outbox.
+type Stats struct {
+	Pending   int
+	Delivered int
+	Failed    int
+}
+
+// An Outbox stores reports and delivers them in the
+// background. It is safe for concurrent use, but only one
+// Outbox should use a given Store.
+type Outbox struct {
+	checker gokismet.SpamChecker
+	store   store.Store
+	opts    Options
+	backoff retry.Backoff
+	poller  *retry.Poller
+
+	// mu guards the read-modify-write cycle of each Report,
+	// so that a delivery and a manual Retry of the same
+	// Report can't overwrite each other.
+	mu sync.Mutex
+}
+
+// New returns an Outbox that stores reports in the given
+// Store and delivers them to the given SpamChecker. If s is
+// nil, reports are kept in memory. If opts is nil, default
+// options are used.
+func New(checker gokismet.SpamChecker, s store.Store, opts *Options) *Outbox {
+
+	if s == nil {
+		s = store.NewMemory()
+	}
+
+	o := &Outbox{
+		checker: checker,
+		store:   s,
+		poller:  retry.NewPoller(),
+	}
+
+	if opts != nil {
+		o.opts = *opts
+	}
+	if o.opts.MaxAttempts <= 0 {
+		o.opts.MaxAttempts = 10
+	}
+	if o.opts.Backoff <= 0 {
+		o.opts.Backoff = 30 * time.Second
+	}
+	if o.opts.MaxBackoff <= 0 {
+		o.opts.MaxBackoff = time.Hour
+	}
+	if o.opts.PollInterval <= 0 {
+		o.opts.PollInterval = 10 * time.Second
+	}
+	if o.opts.Retention <= 0 {
+		o.opts.Retention = 7 * 24 * time.Hour
+	}
+	if o.opts.Now == nil {
+		o.opts.Now = time.Now
+	}
+
+	o.backoff = retry.Backoff{
+		Base: o.opts.Backoff,
+		Max:  o.opts.MaxBackoff,
+	}
+
+	return o
+}
+
+// ReportHam adds a ham report to the Outbox.
+func (o *Outbox) ReportHam(ctx context.Context, values map[string]string) error {
+	return o.add(ctx, KindHam, values)
+}
+
+// ReportSpam adds a spam report to the Outbox.
+func (o *Outbox) ReportSpam(ctx context.Context, values map[string]string) error {
+	return o.add(ctx, KindSpam, values)
+}
+
+// add stores a new Report, unless the same content has
+// already been reported. A repeat of a failed Report is
+// retried.
+func (o *Outbox) add(ctx context.Context, kind Kind, values map[string]string) error {
+
+	id := string(kind) + "-" + gokismet.HashValues(values, o.opts.KeyFilter)
+	now := o.opts.Now()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	r, err := o.Get(ctx, id)
+	switch {
+	case err == ErrNotFound:
+		r = &Report{
+			ID:      id,
+			Kind:    kind,
+			Values:  values,
+			Created: now,
+		}
+	case err != nil:
+		return err
+	case r.State != StateFailed:
+		return nil
+	}
+
+	r.State = StatePending
+	r.Attempts = 0
+	r.NextAttempt = now
+
+	if err := o.put(ctx, r); err != nil {
+		return err
+	}
+
+	o.poller.Wake()
+	return nil
+}
+
+// Run delivers reports in the background until ctx is done.
+// It flushes the Outbox every PollInterval and as soon as a
+// report is added or retried. It returns ctx.Err().
+func (o *Outbox) Run(ctx context.Context) error {
+	return o.poller.Run(ctx, o.opts.PollInterval, func(ctx context.Context) error {
+		_, err := o.Flush(ctx)
+		return err
+	}, o.opts.OnError)
+}
+
+// Flush attempts to deliver all pending reports that are due,
+// and removes delivered reports that are past their retention
+// period. It returns the number of reports delivered.
+func (o *Outbox) Flush(ctx context.Context) (int, error) {
+
+	reports, err := o.List(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+
+	now := o.opts.Now()
+	delivered := 0
+
+	for _, r := range reports {
+
+		if ctx.Err() != nil {
+			return delivered, ctx.Err()
+		}
+
+		switch {
+		case r.State == StatePending && !r.NextAttempt.After(now):
+			ok, err := o.deliver(ctx, r.ID)
+			if err != nil {
+				return delivered, err
+			}
+			if ok {
+				delivered++
+			}
+		case r.State == StateDelivered && now.Sub(r.Delivered) > o.opts.Retention:
+			if err := o.store.Delete(ctx, Collection, r.ID); err != nil {
+				return delivered, err
+			}
+		}
+	}
+
+	return delivered, nil
+}
+
+// deliver makes a delivery attempt for a Report and records
+// the outcome. It returns true if the Report was delivered.
+// The returned error is a storage error; delivery errors are
+// recorded in the Report.
+func (o *Outbox) deliver(ctx context.Context, id string) (bool, error) {
+
+	o.mu.Lock()
+	r, err := o.Get(ctx, id)
+	o.mu.Unlock()
+	if err != nil || r.State != StatePending {
+		return false, err
+	}
+
+	report := o.checker.ReportHamContext
+	if r.Kind == KindSpam {
+		report = o.checker.ReportSpamContext
+	}
+
+	err = report(ctx, r.Values)
+
+	// Don't count attempts cut short by shutdown.
+	if err != nil && ctx.Err() != nil {
+		return false, nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// The Report may have changed during delivery, e.g.
+	// been retried manually.
+	current, getErr := o.Get(ctx, id)
+	if getErr != nil {
+		return false, getErr
+	}
+	if current.State != StatePending {
+		return false, nil
+	}
+	r = current
+
+	now := o.opts.Now()
+	r.Attempts++
+
+	switch {
+	case err == nil:
+		r.State = StateDelivered
+		r.Delivered = now
+		r.LastError = ""
+	case retry.Permanent(err) || r.Attempts >= o.opts.MaxAttempts:
+		r.State = StateFailed
+		r.LastError = err.Error()
+	default:
+		r.LastError = err.Error()
+		r.NextAttempt = now.Add(o.backoff.Delay(r.Attempts))
+	}
+
+	return err == nil, o.put(ctx, r)
+}
+
+// Retry schedules a failed Report for immediate delivery,
+// resetting its attempt count.
+func (o *Outbox) Retry(ctx context.Context, id string) error {
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	r, err := o.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if r.State != StateFailed {
+		return fmt.Errorf("outbox: report %s is %s", id, r.State)
+	}
+
+	r.State = StatePending
+	r.Attempts = 0
+	r.NextAttempt = o.opts.Now()
+
+	if err := o.put(ctx, r); err != nil {
+		return err
+	}
+
+	o.poller.Wake()
+	return nil
+}
+
+// RetryFailed schedules all failed reports for immediate
+// delivery. It returns the number of reports rescheduled.
+func (o *Outbox) RetryFailed(ctx context.Context) (int, error) {
+
+	reports, err := o.List(ctx, StateFailed)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, r := range reports {
+		if err := o.Retry(ctx, r.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// List returns the reports in the given state, oldest first.
+// If state is empty, all reports are returned.
+func (o *Outbox) List(ctx context.Context, state State) ([]*Report, error) {
+
+	records, err := o.store.List(ctx, Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []*Report
+	for _, rec := range records {
+		r := &Report{}
+		if err := json.Unmarshal(rec.Value, r); err != nil {
+			return nil, fmt.Errorf("outbox: report %s: %w", rec.Key, err)
+		}
+		if state == "" || r.State == state {
+			reports = append(reports, r)
+		}
+	}
+
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].Created.Before(reports[j].Created)
+	})
+
+	return reports, nil
+}
+
+// Stats returns the number of reports in each state.
+func (o *Outbox) Stats(ctx context.Context) (*Stats, error) {
+
+	reports, err := o.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{}
+	for _, r := range reports {
+		switch r.State {
+		case StatePending:
+			stats.Pending++
+		case StateDelivered:
+			stats.Delivered++
+		case StateFailed:
+			stats.Failed++
+		}
+	}
+
+	return stats, nil
+}
+
+// Get returns the Report with the given ID.
+func (o *Outbox) Get(ctx context.Context, id string) (*Report, error) {
+
+	b, err := o.store.Get(ctx, Collection, id)
+	if err == store.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("outbox: report %s: %w", id, err)
+	}
+
+	return r, nil
+}
+
+func (o *Outbox) put(ctx context.Context, r *Report) error {
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return o.store.Put(ctx, Collection, r.ID, b)
+}
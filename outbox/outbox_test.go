@@ -0,0 +1,290 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deepilla/gokismet"
+	"github.com/deepilla/gokismet/outbox"
+	"github.com/deepilla/gokismet/store"
+)
+
+// flakyReporter is a SpamChecker whose reports fail a given
+// number of times before succeeding.
+type flakyReporter struct {
+	gokismet.Fake
+
+	mu       sync.Mutex
+	failures int
+	err      error
+	calls    int
+}
+
+func (fr *flakyReporter) ReportSpamContext(ctx context.Context, values map[string]string) error {
+
+	fr.mu.Lock()
+	fr.calls++
+	fail := fr.failures > 0
+	if fail {
+		fr.failures--
+	}
+	fr.mu.Unlock()
+
+	if fail {
+		return fr.err
+	}
+
+	return fr.Fake.ReportSpamContext(ctx, values)
+}
+
+func (fr *flakyReporter) numCalls() int {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return fr.calls
+}
+
+var spam = map[string]string{"comment_content": "Buy pills"}
+
+// clock is a manually advanced time source.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newClock() *clock {
+	return &clock{now: time.Date(2016, time.May, 5, 10, 30, 0, 0, time.UTC)}
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func checkStats(t *testing.T, ob *outbox.Outbox, exp outbox.Stats) {
+	t.Helper()
+
+	stats, err := ob.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if *stats != exp {
+		t.Errorf("Expected stats %+v, got %+v", exp, *stats)
+	}
+}
+
+// TestOutbox_Deliver verifies that reports are delivered and
+// that repeat reports are ignored.
+func TestOutbox_Deliver(t *testing.T) {
+
+	ctx := context.Background()
+	checker := &flakyReporter{}
+	ob := outbox.New(checker, nil, nil)
+
+	for i := 0; i < 3; i++ {
+		if err := ob.ReportSpam(ctx, spam); err != nil {
+			t.Fatalf("Unexpected error %s", err)
+		}
+	}
+
+	ob.ReportHam(ctx, map[string]string{"comment_content": "Hello"})
+
+	checkStats(t, ob, outbox.Stats{Pending: 2})
+
+	n, err := ob.Flush(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if n != 2 {
+		t.Errorf("Expected 2 deliveries, got %d", n)
+	}
+
+	checkStats(t, ob, outbox.Stats{Delivered: 2})
+
+	// Content that has already been reported is ignored.
+	ob.ReportSpam(ctx, spam)
+	ob.Flush(ctx)
+
+	reports := checker.Reports()
+	if len(reports) != 2 {
+		t.Errorf("Expected 2 reports, got %d", len(reports))
+	}
+}
+
+// TestOutbox_Retry verifies that failed deliveries are retried
+// with backoff.
+func TestOutbox_Retry(t *testing.T) {
+
+	ctx := context.Background()
+	c := newClock()
+
+	checker := &flakyReporter{
+		failures: 2,
+		err:      errors.New("connection reset"),
+	}
+
+	ob := outbox.New(checker, nil, &outbox.Options{
+		Backoff: time.Minute,
+		Now:     c.Now,
+	})
+
+	ob.ReportSpam(ctx, spam)
+
+	ob.Flush(ctx)
+
+	// The retry isn't due yet.
+	c.Advance(59 * time.Second)
+	ob.Flush(ctx)
+
+	if calls := checker.numCalls(); calls != 1 {
+		t.Errorf("Expected 1 delivery attempt, got %d", calls)
+	}
+
+	reports, _ := ob.List(ctx, outbox.StatePending)
+	if len(reports) != 1 || reports[0].Attempts != 1 || reports[0].LastError != "connection reset" {
+		t.Fatalf("Expected a pending report after 1 attempt, got %+v", reports)
+	}
+
+	c.Advance(time.Second)
+	ob.Flush(ctx)
+
+	// The second retry waits twice as long.
+	c.Advance(time.Minute)
+	ob.Flush(ctx)
+
+	if calls := checker.numCalls(); calls != 2 {
+		t.Errorf("Expected 2 delivery attempts, got %d", calls)
+	}
+
+	c.Advance(time.Minute)
+	ob.Flush(ctx)
+
+	checkStats(t, ob, outbox.Stats{Delivered: 1})
+}
+
+// TestOutbox_Failed verifies that reports fail after too many
+// attempts, or immediately on permanent errors, and that they
+// can be retried manually.
+func TestOutbox_Failed(t *testing.T) {
+
+	ctx := context.Background()
+
+	checker := &flakyReporter{
+		failures: 1,
+		err:      &gokismet.ValError{Method: "submit-spam", Response: "nope"},
+	}
+
+	c := newClock()
+
+	ob := outbox.New(checker, nil, &outbox.Options{
+		MaxAttempts: 3,
+		Backoff:     time.Minute,
+		Now:         c.Now,
+	})
+
+	ob.ReportSpam(ctx, spam)
+	ob.Flush(ctx)
+
+	checkStats(t, ob, outbox.Stats{Failed: 1})
+
+	n, err := ob.RetryFailed(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 retry, got %d, %v", n, err)
+	}
+
+	ob.Flush(ctx)
+
+	checkStats(t, ob, outbox.Stats{Delivered: 1})
+
+	if err := ob.Retry(ctx, "missing"); err != outbox.ErrNotFound {
+		t.Errorf("Expected error %v, got %v", outbox.ErrNotFound, err)
+	}
+
+	// Transient errors fail after MaxAttempts.
+	checker.failures = 10
+	checker.err = errors.New("connection reset")
+
+	other := map[string]string{"comment_content": "More pills"}
+	ob.ReportSpam(ctx, other)
+
+	for i := 0; i < 3; i++ {
+		ob.Flush(ctx)
+		c.Advance(time.Hour)
+	}
+
+	checkStats(t, ob, outbox.Stats{Delivered: 1, Failed: 1})
+}
+
+// TestOutbox_Run verifies that Run delivers new reports
+// promptly.
+func TestOutbox_Run(t *testing.T) {
+
+	checker := &flakyReporter{}
+	ob := outbox.New(checker, nil, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- ob.Run(ctx)
+	}()
+
+	ob.ReportSpam(ctx, spam)
+
+	deadline := time.Now().Add(time.Second)
+	for len(checker.Reports()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if len(checker.Reports()) != 1 {
+		t.Errorf("Expected 1 report, got %d", len(checker.Reports()))
+	}
+
+	cancel()
+
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected error %v, got %v", context.Canceled, err)
+	}
+}
+
+// TestOutbox_RunError verifies that Run passes storage errors
+// to the OnError option.
+func TestOutbox_RunError(t *testing.T) {
+
+	st := store.NewMemory()
+	st.Close()
+
+	errc := make(chan error, 1)
+	ob := outbox.New(&flakyReporter{}, st, &outbox.Options{
+		OnError: func(err error) {
+			select {
+			case errc <- err:
+			default:
+			}
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go ob.Run(ctx)
+
+	select {
+	case err := <-errc:
+		if err != store.ErrClosed {
+			t.Errorf("Expected error %v, got %v", store.ErrClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for an error")
+	}
+}
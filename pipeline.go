@@ -0,0 +1,208 @@
+package gokismet
+
+import (
+	"context"
+	"time"
+)
+
+// A Stage is one step in a Pipeline. Each Stage examines the
+// key-value pairs being checked and either decides their spam
+// status, or defers to later stages, optionally annotating the
+// content along the way.
+type Stage interface {
+	// Evaluate examines a set of key-value pairs. To decide
+	// the outcome of the Pipeline, return a StageResult with
+	// a Status other than StatusUnknown. To defer, return a
+	// StageResult with a status of StatusUnknown (or a nil
+	// StageResult).
+	Evaluate(ctx context.Context, values map[string]string) (*StageResult, error)
+}
+
+// A StageFunc converts a standalone function into a Stage.
+type StageFunc func(ctx context.Context, values map[string]string) (*StageResult, error)
+
+// Evaluate calls a StageFunc's underlying function.
+func (f StageFunc) Evaluate(ctx context.Context, values map[string]string) (*StageResult, error) {
+	return f(ctx, values)
+}
+
+// A StageResult is the outcome of a single Stage.
+type StageResult struct {
+	// The Stage's decision, or StatusUnknown to defer to
+	// later stages.
+	Status SpamStatus
+	// An explanation of the decision (may be empty).
+	Reason string
+	// Additional information about the content, e.g. a
+	// reputation score. Annotations are collected in the
+	// Verdict's trail.
+	Annotations map[string]string
+	// The result of an Akismet check, if the Stage made one.
+	CheckResult *CheckResult
+}
+
+// Evaluate implements the Stage interface, allowing a Checker
+// to be used in a Pipeline. The Checker always decides, unless
+// the spam check fails.
+func (ch *Checker) Evaluate(ctx context.Context, values map[string]string) (*StageResult, error) {
+	return evaluateCheck(ctx, ch, values)
+}
+
+// CheckStage returns a Stage that checks content with the
+// given SpamChecker. Like a Checker, the Stage always decides
+// unless the spam check fails.
+func CheckStage(sc SpamChecker) Stage {
+	return StageFunc(func(ctx context.Context, values map[string]string) (*StageResult, error) {
+		return evaluateCheck(ctx, sc, values)
+	})
+}
+
+// evaluateCheck runs a spam check as a Pipeline stage.
+func evaluateCheck(ctx context.Context, sc SpamChecker, values map[string]string) (*StageResult, error) {
+
+	result, err := sc.CheckContext(ctx, values)
+	if err != nil {
+		return nil, err
+	}
+
+	sr := &StageResult{
+		Status:      result.Status,
+		CheckResult: result,
+	}
+
+	if result.Cached {
+		sr.Annotations = map[string]string{"cached": "true"}
+	}
+
+	return sr, nil
+}
+
+// A Pipeline runs a series of Stages in order until one of
+// them decides the spam status of the content. A typical
+// Pipeline consists of local stages (allowlists, rules,
+// reputation) followed by a Checker. A Pipeline is safe for
+// concurrent use once all of its stages have been added.
+type Pipeline struct {
+	stages []namedStage
+}
+
+type namedStage struct {
+	name  string
+	stage Stage
+}
+
+// NewPipeline returns an empty Pipeline.
+func NewPipeline() *Pipeline {
+	return &Pipeline{}
+}
+
+// Add appends a Stage to a Pipeline. The name identifies the
+// Stage in the Verdict's trail. Add returns the Pipeline so
+// that calls can be chained.
+func (p *Pipeline) Add(name string, stage Stage) *Pipeline {
+	p.stages = append(p.stages, namedStage{name, stage})
+	return p
+}
+
+// A Verdict is the final outcome of a Pipeline.
+type Verdict struct {
+	// The spam status decided by the Pipeline. If no stage
+	// reached a decision, the status is StatusUnknown.
+	Status SpamStatus
+	// The name of the deciding stage, if any.
+	DecidedBy string
+	// The deciding stage's explanation, if any.
+	Reason string
+	// The result of the Akismet check, if the deciding stage
+	// made one.
+	CheckResult *CheckResult
+	// The stages that ran, in order.
+	Trail []StageOutcome
+}
+
+// A StageOutcome records what happened when a Stage ran.
+type StageOutcome struct {
+	// The name of the Stage.
+	Stage string
+	// The Stage's decision, or StatusUnknown if it
+	// deferred or failed.
+	Status SpamStatus
+	// The Stage's explanation, if any.
+	Reason string
+	// The Stage's annotations, if any.
+	Annotations map[string]string
+	// The error returned by the Stage, if any.
+	Err error
+	// How long the Stage took to run.
+	Duration time.Duration
+}
+
+// Run runs the Pipeline's stages in order until one of them
+// decides. A failed stage is recorded in the trail and the
+// Pipeline moves on to the next stage. If no stage decides,
+// the Verdict has a status of StatusUnknown and Run returns
+// the last error encountered (if any).
+func (p *Pipeline) Run(ctx context.Context, values map[string]string) (*Verdict, error) {
+
+	v := &Verdict{}
+
+	var lastErr error
+
+	for _, ns := range p.stages {
+
+		start := time.Now()
+		sr, err := ns.stage.Evaluate(ctx, values)
+
+		outcome := StageOutcome{
+			Stage:    ns.name,
+			Err:      err,
+			Duration: time.Since(start),
+		}
+
+		if err != nil {
+			lastErr = err
+			v.Trail = append(v.Trail, outcome)
+
+			// There's no point trying further stages if
+			// the caller has given up.
+			if ctx.Err() != nil {
+				return v, ctx.Err()
+			}
+			continue
+		}
+
+		if sr != nil {
+			outcome.Status = sr.Status
+			outcome.Reason = sr.Reason
+			outcome.Annotations = sr.Annotations
+		}
+
+		v.Trail = append(v.Trail, outcome)
+
+		if sr != nil && sr.Status != StatusUnknown {
+			v.Status = sr.Status
+			v.DecidedBy = ns.name
+			v.Reason = sr.Reason
+			v.CheckResult = sr.CheckResult
+			return v, nil
+		}
+	}
+
+	return v, lastErr
+}
+
+// Evaluate implements the Stage interface, allowing one
+// Pipeline to be nested inside another.
+func (p *Pipeline) Evaluate(ctx context.Context, values map[string]string) (*StageResult, error) {
+
+	v, err := p.Run(ctx, values)
+	if err != nil && v.Status == StatusUnknown {
+		return nil, err
+	}
+
+	return &StageResult{
+		Status:      v.Status,
+		Reason:      v.Reason,
+		CheckResult: v.CheckResult,
+	}, nil
+}
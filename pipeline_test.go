@@ -0,0 +1,204 @@
+package gokismet_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/deepilla/gokismet"
+)
+
+// decide returns a Stage that always returns the given
+// status and reason.
+func decide(status gokismet.SpamStatus, reason string) gokismet.Stage {
+	return gokismet.StageFunc(func(ctx context.Context, values map[string]string) (*gokismet.StageResult, error) {
+		return &gokismet.StageResult{
+			Status: status,
+			Reason: reason,
+		}, nil
+	})
+}
+
+// annotate returns a Stage that defers with an annotation.
+func annotate(key, value string) gokismet.Stage {
+	return gokismet.StageFunc(func(ctx context.Context, values map[string]string) (*gokismet.StageResult, error) {
+		return &gokismet.StageResult{
+			Annotations: map[string]string{key: value},
+		}, nil
+	})
+}
+
+// fail returns a Stage that always returns the given error.
+func fail(err error) gokismet.Stage {
+	return gokismet.StageFunc(func(ctx context.Context, values map[string]string) (*gokismet.StageResult, error) {
+		return nil, err
+	})
+}
+
+// TestPipeline verifies that Pipelines run their stages in
+// order and stop at the first decision.
+func TestPipeline(t *testing.T) {
+
+	errStage := errors.New("stage failed")
+
+	type stage struct {
+		Name  string
+		Stage gokismet.Stage
+	}
+
+	tests := []struct {
+		Stages    []stage
+		Status    gokismet.SpamStatus
+		DecidedBy string
+		Reason    string
+		Trail     []string
+		Err       error
+	}{
+		{
+			// An empty Pipeline doesn't decide.
+			Status: gokismet.StatusUnknown,
+		},
+		{
+			Stages: []stage{
+				{"annotate", annotate("score", "0.5")},
+				{"defer", gokismet.StageFunc(func(ctx context.Context, values map[string]string) (*gokismet.StageResult, error) {
+					return nil, nil
+				})},
+				{"spam", decide(gokismet.StatusDefiniteSpam, "blocked")},
+				{"ham", decide(gokismet.StatusHam, "never reached")},
+			},
+			Status:    gokismet.StatusDefiniteSpam,
+			DecidedBy: "spam",
+			Reason:    "blocked",
+			Trail:     []string{"annotate", "defer", "spam"},
+		},
+		{
+			// Failed stages are skipped.
+			Stages: []stage{
+				{"broken", fail(errStage)},
+				{"ham", decide(gokismet.StatusHam, "allowed")},
+			},
+			Status:    gokismet.StatusHam,
+			DecidedBy: "ham",
+			Reason:    "allowed",
+			Trail:     []string{"broken", "ham"},
+		},
+		{
+			// If nothing decides, the last error is returned.
+			Stages: []stage{
+				{"broken", fail(errStage)},
+				{"annotate", annotate("score", "0.5")},
+			},
+			Status: gokismet.StatusUnknown,
+			Trail:  []string{"broken", "annotate"},
+			Err:    errStage,
+		},
+	}
+
+	for i, test := range tests {
+
+		p := gokismet.NewPipeline()
+		for _, s := range test.Stages {
+			p.Add(s.Name, s.Stage)
+		}
+
+		v, err := p.Run(context.Background(), map[string]string{"comment_content": "Hello world"})
+
+		if err != test.Err {
+			t.Errorf("Test %d: Expected error %v, got %v", i+1, test.Err, err)
+		}
+
+		if v.Status != test.Status {
+			t.Errorf("Test %d: Expected Spam Status %q, got %q", i+1,
+				statusToString(test.Status), statusToString(v.Status))
+		}
+
+		if v.DecidedBy != test.DecidedBy {
+			t.Errorf("Test %d: Expected DecidedBy %q, got %q", i+1, test.DecidedBy, v.DecidedBy)
+		}
+
+		if v.Reason != test.Reason {
+			t.Errorf("Test %d: Expected Reason %q, got %q", i+1, test.Reason, v.Reason)
+		}
+
+		if len(v.Trail) != len(test.Trail) {
+			t.Errorf("Test %d: Expected %d stage(s) in trail, got %d", i+1, len(test.Trail), len(v.Trail))
+			continue
+		}
+
+		for j, name := range test.Trail {
+			if v.Trail[j].Stage != name {
+				t.Errorf("Test %d: Expected stage %d to be %q, got %q", i+1, j+1, name, v.Trail[j].Stage)
+			}
+		}
+	}
+}
+
+// TestPipeline_Annotations verifies that stage annotations
+// and errors are recorded in the trail.
+func TestPipeline_Annotations(t *testing.T) {
+
+	errStage := errors.New("stage failed")
+
+	v, _ := gokismet.NewPipeline().
+		Add("annotate", annotate("score", "0.5")).
+		Add("broken", fail(errStage)).
+		Add("spam", decide(gokismet.StatusProbableSpam, "suspicious")).
+		Run(context.Background(), nil)
+
+	if got := v.Trail[0].Annotations["score"]; got != "0.5" {
+		t.Errorf("Expected annotation %q, got %q", "0.5", got)
+	}
+
+	if v.Trail[1].Err != errStage {
+		t.Errorf("Expected error %v, got %v", errStage, v.Trail[1].Err)
+	}
+
+	if v.Trail[2].Status != gokismet.StatusProbableSpam {
+		t.Errorf("Expected Spam Status %q, got %q",
+			statusToString(gokismet.StatusProbableSpam), statusToString(v.Trail[2].Status))
+	}
+}
+
+// TestPipeline_Checker verifies that a Checker can be used
+// as a Pipeline stage.
+func TestPipeline_Checker(t *testing.T) {
+
+	client := &Responder{
+		Responses: map[string]*ResponseInfo{
+			"comment-check": {
+				Body:       "true",
+				StatusCode: http.StatusOK,
+				HeaderItems: map[string]string{
+					"X-akismet-guid": "abc123",
+				},
+			},
+		},
+	}
+	client.AddResponses(verifyingResponder)
+
+	ch := gokismet.NewCheckerClient(TestAPIKey, TestSite, client)
+
+	v, err := gokismet.NewPipeline().
+		Add("annotate", annotate("score", "0.5")).
+		Add("akismet", ch).
+		Run(context.Background(), map[string]string{"comment_content": "Hello world"})
+
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if v.Status != gokismet.StatusProbableSpam {
+		t.Errorf("Expected Spam Status %q, got %q",
+			statusToString(gokismet.StatusProbableSpam), statusToString(v.Status))
+	}
+
+	if v.DecidedBy != "akismet" {
+		t.Errorf("Expected DecidedBy %q, got %q", "akismet", v.DecidedBy)
+	}
+
+	if v.CheckResult == nil || v.CheckResult.GUID != "abc123" {
+		t.Errorf("Expected a CheckResult with GUID %q, got %v", "abc123", v.CheckResult)
+	}
+}
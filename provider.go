@@ -0,0 +1,135 @@
+package gokismet
+
+import (
+	"errors"
+	"strings"
+)
+
+// An AuthScheme determines how a Checker presents its API
+// key to a Provider.
+type AuthScheme int
+
+const (
+	// AuthKeyHost qualifies the API hostname with the key,
+	// e.g. https://123456789abc.rest.akismet.com/. Key
+	// verification calls use the unqualified hostname and
+	// pass the key as a parameter. This is Akismet's scheme.
+	AuthKeyHost AuthScheme = iota
+
+	// AuthKeyParam passes the key as a "key" parameter on
+	// every call.
+	AuthKeyParam
+
+	// AuthBearer passes the key in an "Authorization: Bearer"
+	// request header on every call.
+	AuthBearer
+)
+
+// A Provider describes a service that implements the Akismet
+// REST API. Providers allow a Checker to talk to Akismet-
+// compatible services that use a different host layout,
+// authentication scheme or response format.
+type Provider struct {
+	// A short name for the service, e.g. "akismet".
+	Name string
+
+	// The URL prefix for API calls, including the scheme and
+	// trailing slash, e.g. "https://rest.akismet.com/1.1/".
+	BaseURL string
+
+	// How the API key is presented to the service.
+	Auth AuthScheme
+
+	// The paths of the API methods, relative to BaseURL.
+	// An empty path means the method is not supported. If
+	// VerifyPath is empty, keys are not verified. Calls to
+	// other unsupported methods return ErrUnsupported.
+	VerifyPath     string
+	CheckPath      string
+	ReportHamPath  string
+	ReportSpamPath string
+
+	// The expected response bodies. Any other responses
+	// trigger an error.
+	ResponseVerified string
+	ResponseHam      string
+	ResponseSpam     string
+	ResponseReported string
+
+	// The names of the response headers containing debug
+	// information, spam-handling tips and submission GUIDs.
+	// Empty names are ignored.
+	HeaderDebugHelp string
+	HeaderProTip    string
+	HeaderGUID      string
+
+	// The pro tip value that indicates definite spam.
+	ProTipDiscard string
+}
+
+// AkismetProvider describes the Akismet service. It is the
+// default Provider for Checkers.
+var AkismetProvider = &Provider{
+	Name:             "akismet",
+	BaseURL:          "https://rest.akismet.com/1.1/",
+	Auth:             AuthKeyHost,
+	VerifyPath:       methodVerify,
+	CheckPath:        methodCheck,
+	ReportHamPath:    methodReportHam,
+	ReportSpamPath:   methodReportSpam,
+	ResponseVerified: responseVerified,
+	ResponseHam:      responseHam,
+	ResponseSpam:     responseSpam,
+	ResponseReported: responseReported,
+	HeaderDebugHelp:  headerDebugHelp,
+	HeaderProTip:     headerProTip,
+	HeaderGUID:       headerGUID,
+	ProTipDiscard:    proTipDiscard,
+}
+
+// ErrUnsupported is returned by the Checker methods when the
+// Checker's Provider does not support the requested API call.
+var ErrUnsupported = errors.New("method not supported by provider")
+
+// WithProvider configures a Checker to use an alternative
+// Akismet-compatible service. If p is nil, AkismetProvider
+// is used.
+func WithProvider(p *Provider) Option {
+	return func(ch *Checker) {
+		if p == nil {
+			p = AkismetProvider
+		}
+		ch.provider = p
+	}
+}
+
+// path returns the Provider's path for the given API method.
+func (p *Provider) path(method string) string {
+	switch method {
+	case methodVerify:
+		return p.VerifyPath
+	case methodCheck:
+		return p.CheckPath
+	case methodReportHam:
+		return p.ReportHamPath
+	case methodReportSpam:
+		return p.ReportSpamPath
+	default:
+		return ""
+	}
+}
+
+// url returns the endpoint URL for the given API method.
+// If the Provider uses AuthKeyHost and a non-empty API key
+// is provided, the hostname is qualified with the key.
+func (p *Provider) url(method string, key string) string {
+
+	s := p.BaseURL
+	if p.Auth == AuthKeyHost && key != "" {
+		if i := strings.Index(s, "://"); i >= 0 {
+			s = s[:i+3] + key + "." + s[i+3:]
+		}
+	}
+
+	return s + p.path(method)
+}
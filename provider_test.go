@@ -0,0 +1,195 @@
+package gokismet_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/deepilla/gokismet"
+)
+
+// TestProvider verifies that Checkers build their requests
+// and interpret responses according to their Provider.
+func TestProvider(t *testing.T) {
+
+	provider := &gokismet.Provider{
+		Name:             "antispam",
+		BaseURL:          "http://antispam.internal/api/",
+		Auth:             gokismet.AuthBearer,
+		CheckPath:        "check",
+		ReportSpamPath:   "spam",
+		ResponseHam:      "ham",
+		ResponseSpam:     "spam",
+		ResponseReported: "ok",
+		HeaderProTip:     "X-Antispam-Tip",
+		HeaderGUID:       "X-Antispam-Id",
+		ProTipDiscard:    "drop",
+	}
+
+	var reqs []*http.Request
+	var bodies []string
+
+	client := gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+
+		b, _ := io.ReadAll(req.Body)
+		reqs = append(reqs, req)
+		bodies = append(bodies, string(b))
+
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Header:     make(http.Header),
+		}
+
+		switch req.URL.Path {
+		case "/api/check":
+			resp.Header.Set("X-Antispam-Tip", "drop")
+			resp.Header.Set("X-Antispam-Id", "xyz789")
+			resp.Body = io.NopCloser(strings.NewReader("spam"))
+		case "/api/spam":
+			resp.Body = io.NopCloser(strings.NewReader("ok"))
+		default:
+			resp.StatusCode = http.StatusNotFound
+			resp.Status = "404 Not Found"
+			resp.Body = io.NopCloser(strings.NewReader(""))
+		}
+
+		return resp, nil
+	})
+
+	ch := gokismet.NewCheckerClient(TestAPIKey, TestSite, client, gokismet.WithProvider(provider))
+
+	values := map[string]string{"comment_content": "Hello"}
+
+	result, err := ch.CheckContext(context.Background(), values)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if result.Status != gokismet.StatusDefiniteSpam {
+		t.Errorf("Expected Spam Status %q, got %q",
+			statusToString(gokismet.StatusDefiniteSpam), statusToString(result.Status))
+	}
+
+	if result.GUID != "xyz789" {
+		t.Errorf("Expected GUID %q, got %q", "xyz789", result.GUID)
+	}
+
+	// The provider doesn't support verification, so the
+	// check should be the first request.
+	if len(reqs) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(reqs))
+	}
+
+	if got := reqs[0].URL.String(); got != "http://antispam.internal/api/check" {
+		t.Errorf("Expected URL %q, got %q", "http://antispam.internal/api/check", got)
+	}
+
+	if got := reqs[0].Header.Get("Authorization"); got != "Bearer "+TestAPIKey {
+		t.Errorf("Expected Authorization header %q, got %q", "Bearer "+TestAPIKey, got)
+	}
+
+	if strings.Contains(bodies[0], "key=") {
+		t.Errorf("Expected no key parameter, got %q", bodies[0])
+	}
+
+	if err := ch.ReportSpam(values); err != nil {
+		t.Errorf("Unexpected error %s", err)
+	}
+
+	if err := ch.ReportHam(values); err != gokismet.ErrUnsupported {
+		t.Errorf("Expected error %v, got %v", gokismet.ErrUnsupported, err)
+	}
+
+	if len(reqs) != 2 {
+		t.Errorf("Expected 2 requests, got %d", len(reqs))
+	}
+}
+
+// TestProvider_KeyParam verifies that providers using
+// AuthKeyParam receive the API key as a parameter.
+func TestProvider_KeyParam(t *testing.T) {
+
+	provider := *gokismet.AkismetProvider
+	provider.BaseURL = "http://spam.example.com/1.1/"
+	provider.Auth = gokismet.AuthKeyParam
+
+	var urls, bodies []string
+
+	client := gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+
+		b, _ := io.ReadAll(req.Body)
+		urls = append(urls, req.URL.String())
+		bodies = append(bodies, string(b))
+
+		body := "false"
+		if strings.HasSuffix(req.URL.Path, "verify-key") {
+			body = "valid"
+		}
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Header:     make(http.Header),
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}, nil
+	})
+
+	ch := gokismet.NewCheckerClient(TestAPIKey, TestSite, client, gokismet.WithProvider(&provider))
+
+	status, err := ch.Check(map[string]string{"comment_content": "Hello"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if status != gokismet.StatusHam {
+		t.Errorf("Expected Spam Status %q, got %q",
+			statusToString(gokismet.StatusHam), statusToString(status))
+	}
+
+	expURLs := []string{
+		"http://spam.example.com/1.1/verify-key",
+		"http://spam.example.com/1.1/comment-check",
+	}
+
+	if len(urls) != len(expURLs) {
+		t.Fatalf("Expected %d requests, got %d", len(expURLs), len(urls))
+	}
+
+	for i, exp := range expURLs {
+		if urls[i] != exp {
+			t.Errorf("Request %d: Expected URL %q, got %q", i+1, exp, urls[i])
+		}
+		if !strings.Contains(bodies[i], "key="+TestAPIKey) {
+			t.Errorf("Request %d: Expected key parameter, got %q", i+1, bodies[i])
+		}
+	}
+}
+
+// TestProvider_Unsupported verifies that methods without a
+// path return ErrUnsupported without making a request.
+func TestProvider_Unsupported(t *testing.T) {
+
+	provider := *gokismet.AkismetProvider
+	provider.CheckPath = ""
+	provider.ReportHamPath = ""
+
+	client := &RequestStore{}
+	ch := gokismet.NewCheckerClient(TestAPIKey, TestSite, client, gokismet.WithProvider(&provider))
+
+	values := map[string]string{"comment_content": "Hello"}
+
+	if _, err := ch.Check(values); err != gokismet.ErrUnsupported {
+		t.Errorf("Check: Expected error %v, got %v", gokismet.ErrUnsupported, err)
+	}
+
+	if err := ch.ReportHam(values); err != gokismet.ErrUnsupported {
+		t.Errorf("ReportHam: Expected error %v, got %v", gokismet.ErrUnsupported, err)
+	}
+
+	if len(client.Requests) != 0 {
+		t.Errorf("Expected no requests, got %d", len(client.Requests))
+	}
+}
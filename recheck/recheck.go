@@ -0,0 +1,366 @@
+/*
+Package recheck schedules content to be checked for spam
+again later.
+
+Akismet supports rechecking content that has already been
+checked, e.g. after it is edited, or when an earlier check
+failed. A Scheduler stores content with a due time and, when
+it falls due, checks it with the recheck_reason parameter
+set. Failed checks are retried with exponential backoff.
+When a final verdict arrives, or the Scheduler gives up, it
+calls a callback:
+
+	s := recheck.New(checker, st, func(ctx context.Context, job *recheck.Job, result *gokismet.CheckResult, err error) {
+		// Update the content's status.
+	}, nil)
+	go s.Run(ctx)
+
+	s.Schedule(ctx, commentID, values, recheck.ReasonEdit, time.Now())
+*/
+package recheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/deepilla/gokismet"
+	"github.com/deepilla/gokismet/internal/retry"
+	"github.com/deepilla/gokismet/store"
+)
+
+// Collection is the name of the store collection that holds
+// a Scheduler's jobs.
+const Collection = "recheck"
+
+// paramRecheckReason is the Akismet parameter that explains
+// why content is being checked again.
+const paramRecheckReason = "recheck_reason"
+
+// Common recheck reasons.
+const (
+	// ReasonEdit means the content was edited.
+	ReasonEdit = "edit"
+	// ReasonQueueRetry means an earlier check failed.
+	ReasonQueueRetry = "queue-retry"
+)
+
+// Errors returned by a Scheduler.
+var (
+	// ErrNotFound means that no Job has the given ID.
+	ErrNotFound = errors.New("recheck: job not found")
+	// ErrNoID means that content was scheduled without an
+	// ID.
+	ErrNoID = errors.New("recheck: no content ID")
+)
+
+// A Job is content waiting to be rechecked.
+type Job struct {
+	// The caller's identifier for the content, e.g. a
+	// comment ID.
+	ID string `json:"id"`
+	// The key-value pairs to check.
+	Values map[string]string `json:"values"`
+	// The recheck_reason to send with the check.
+	Reason string `json:"reason"`
+	// The number of failed checks so far.
+	Attempts int `json:"attempts"`
+	// The error from the last failed check, if any.
+	LastError string `json:"last_error,omitempty"`
+	// When the Job was last scheduled.
+	Created time.Time `json:"created"`
+	// When the next check is due.
+	Due time.Time `json:"due"`
+}
+
+// A Callback receives the outcome of a Job: either the final
+// CheckResult or, if the Scheduler gave up, the last error.
+type Callback func(ctx context.Context, job *Job, result *gokismet.CheckResult, err error)
+
+// Options configures a Scheduler.
+type Options struct {
+	// The maximum number of checks before a Job is given up.
+	// Defaults to 10.
+	MaxAttempts int
+	// The delay after the first failed check. The delay
+	// doubles after each failure. Defaults to 1 minute.
+	Backoff time.Duration
+	// The maximum delay between checks. Defaults to 1 hour.
+	MaxBackoff time.Duration
+	// How often Run looks for due jobs. Defaults to 10
+	// seconds.
+	PollInterval time.Duration
+	// Called when Run can't load or save Jobs. Check errors
+	// don't go here: they are retried, and the Callback
+	// receives the last one if the Scheduler gives up. If
+	// nil, Run ignores storage errors and carries on.
+	OnError func(err error)
+	// Returns the current time, which decides when Jobs are
+	// due. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// A Scheduler stores Jobs and checks them when they fall due.
+// It is safe for concurrent use, but only one Scheduler should
+// use a given Store.
+type Scheduler struct {
+	checker  gokismet.SpamChecker
+	store    store.Store
+	callback Callback
+	opts     Options
+	backoff  retry.Backoff
+	poller   *retry.Poller
+
+	// mu makes scheduling a Job and recording the outcome of
+	// its check atomic, so that a check never overwrites a
+	// Job that was rescheduled or cancelled in the meantime.
+	mu sync.Mutex
+}
+
+// New returns a Scheduler that stores Jobs in the given Store
+// and checks them with the given SpamChecker. If s is nil,
+// Jobs are kept in memory. The callback, which may be nil,
+// receives the outcome of each Job. If opts is nil, default
+// options are used.
+func New(checker gokismet.SpamChecker, s store.Store, callback Callback, opts *Options) *Scheduler {
+
+	if s == nil {
+		s = store.NewMemory()
+	}
+
+	sc := &Scheduler{
+		checker:  checker,
+		store:    s,
+		callback: callback,
+		poller:   retry.NewPoller(),
+	}
+
+	if opts != nil {
+		sc.opts = *opts
+	}
+	if sc.opts.MaxAttempts <= 0 {
+		sc.opts.MaxAttempts = 10
+	}
+	if sc.opts.Backoff <= 0 {
+		sc.opts.Backoff = time.Minute
+	}
+	if sc.opts.MaxBackoff <= 0 {
+		sc.opts.MaxBackoff = time.Hour
+	}
+	if sc.opts.PollInterval <= 0 {
+		sc.opts.PollInterval = 10 * time.Second
+	}
+	if sc.opts.Now == nil {
+		sc.opts.Now = time.Now
+	}
+
+	sc.backoff = retry.Backoff{
+		Base: sc.opts.Backoff,
+		Max:  sc.opts.MaxBackoff,
+	}
+
+	return sc
+}
+
+// Schedule adds content to be rechecked at the given time.
+// The id identifies the content, not a particular version of
+// it. If content with the same id is already scheduled, e.g.
+// because it has been edited again, its Job is replaced, and
+// the outcome of any check of the earlier version that is
+// under way is discarded.
+func (sc *Scheduler) Schedule(ctx context.Context, id string, values map[string]string, reason string, due time.Time) (*Job, error) {
+
+	if id == "" {
+		return nil, ErrNoID
+	}
+
+	job := &Job{
+		ID:      id,
+		Values:  values,
+		Reason:  reason,
+		Created: sc.opts.Now(),
+		Due:     due,
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if err := sc.put(ctx, job); err != nil {
+		return nil, err
+	}
+
+	sc.poller.Wake()
+	return job, nil
+}
+
+// Cancel removes a scheduled Job.
+func (sc *Scheduler) Cancel(ctx context.Context, id string) error {
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if _, err := sc.Get(ctx, id); err != nil {
+		return err
+	}
+
+	return sc.store.Delete(ctx, Collection, id)
+}
+
+// Run checks Jobs as they fall due until ctx is done. It
+// looks for due Jobs every PollInterval, and straight away
+// when a Job is scheduled. It returns ctx.Err().
+func (sc *Scheduler) Run(ctx context.Context) error {
+	return sc.poller.Run(ctx, sc.opts.PollInterval, func(ctx context.Context) error {
+		_, err := sc.Flush(ctx)
+		return err
+	}, sc.opts.OnError)
+}
+
+// Flush checks all Jobs that are due. It returns the number
+// of Jobs that reached a final verdict.
+func (sc *Scheduler) Flush(ctx context.Context) (int, error) {
+
+	jobs, err := sc.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := sc.opts.Now()
+	n := 0
+
+	for _, job := range jobs {
+
+		if ctx.Err() != nil {
+			return n, ctx.Err()
+		}
+
+		if job.Due.After(now) {
+			continue
+		}
+
+		ok, err := sc.run(ctx, job)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// run checks a single Job and records the outcome. It returns
+// true if the Job reached a final verdict. The returned error
+// is a storage error; check errors are recorded in the Job.
+func (sc *Scheduler) run(ctx context.Context, job *Job) (bool, error) {
+
+	values := make(map[string]string, len(job.Values)+1)
+	for k, v := range job.Values {
+		values[k] = v
+	}
+	if job.Reason != "" {
+		values[paramRecheckReason] = job.Reason
+	}
+
+	result, err := sc.checker.CheckContext(ctx, values)
+
+	// Don't count checks cut short by shutdown.
+	if err != nil && ctx.Err() != nil {
+		return false, nil
+	}
+
+	sc.mu.Lock()
+
+	// The Job may have been replaced or cancelled while it
+	// was being checked.
+	current, getErr := sc.Get(ctx, job.ID)
+	if getErr != nil || !current.Created.Equal(job.Created) {
+		sc.mu.Unlock()
+		if getErr == ErrNotFound {
+			getErr = nil
+		}
+		return false, getErr
+	}
+
+	job = current
+	job.Attempts++
+
+	if err != nil && !retry.Permanent(err) && job.Attempts < sc.opts.MaxAttempts {
+		job.LastError = err.Error()
+		job.Due = sc.opts.Now().Add(sc.backoff.Delay(job.Attempts))
+		err = sc.put(ctx, job)
+		sc.mu.Unlock()
+		return false, err
+	}
+
+	delErr := sc.store.Delete(ctx, Collection, job.ID)
+	sc.mu.Unlock()
+
+	if delErr != nil {
+		return false, delErr
+	}
+
+	if sc.callback != nil {
+		sc.callback(ctx, job, result, err)
+	}
+
+	return err == nil, nil
+}
+
+// Get returns the Job with the given ID.
+func (sc *Scheduler) Get(ctx context.Context, id string) (*Job, error) {
+
+	b, err := sc.store.Get(ctx, Collection, id)
+	if err == store.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{}
+	if err := json.Unmarshal(b, job); err != nil {
+		return nil, fmt.Errorf("recheck: job %s: %w", id, err)
+	}
+
+	return job, nil
+}
+
+// List returns the scheduled Jobs, soonest first.
+func (sc *Scheduler) List(ctx context.Context) ([]*Job, error) {
+
+	records, err := sc.store.List(ctx, Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(records))
+	for _, rec := range records {
+		job := &Job{}
+		if err := json.Unmarshal(rec.Value, job); err != nil {
+			return nil, fmt.Errorf("recheck: job %s: %w", rec.Key, err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].Due.Before(jobs[j].Due)
+	})
+
+	return jobs, nil
+}
+
+func (sc *Scheduler) put(ctx context.Context, job *Job) error {
+
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return sc.store.Put(ctx, Collection, job.ID, b)
+}
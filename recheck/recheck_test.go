@@ -0,0 +1,377 @@
+package recheck_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deepilla/gokismet"
+	"github.com/deepilla/gokismet/recheck"
+	"github.com/deepilla/gokismet/store"
+)
+
+// flakyChecker is a SpamChecker whose checks fail a given
+// number of times before succeeding. It records the values
+// of each check.
+type flakyChecker struct {
+	gokismet.Fake
+
+	mu       sync.Mutex
+	failures int
+	err      error
+	values   []map[string]string
+}
+
+func (fc *flakyChecker) CheckContext(ctx context.Context, values map[string]string) (*gokismet.CheckResult, error) {
+
+	fc.mu.Lock()
+	fc.values = append(fc.values, values)
+	fail := fc.failures > 0
+	if fail {
+		fc.failures--
+	}
+	fc.mu.Unlock()
+
+	if fail {
+		return nil, fc.err
+	}
+
+	return fc.Fake.CheckContext(ctx, values)
+}
+
+func (fc *flakyChecker) calls() []map[string]string {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return append([]map[string]string(nil), fc.values...)
+}
+
+// outcome records the arguments to a Callback.
+type outcome struct {
+	Job    *recheck.Job
+	Result *gokismet.CheckResult
+	Err    error
+}
+
+type outcomes struct {
+	mu   sync.Mutex
+	list []outcome
+}
+
+func (o *outcomes) callback(ctx context.Context, job *recheck.Job, result *gokismet.CheckResult, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.list = append(o.list, outcome{job, result, err})
+}
+
+func (o *outcomes) get() []outcome {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]outcome(nil), o.list...)
+}
+
+var spam = map[string]string{"comment_author": "viagra-test-123"}
+
+// clock is a manually advanced time source.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newClock() *clock {
+	return &clock{now: time.Date(2016, time.May, 5, 10, 30, 0, 0, time.UTC)}
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// TestScheduler verifies that due Jobs are checked with a
+// recheck_reason and that the final verdict is passed to the
+// callback.
+func TestScheduler(t *testing.T) {
+
+	ctx := context.Background()
+	checker := &flakyChecker{}
+	var out outcomes
+
+	sc := recheck.New(checker, nil, out.callback, nil)
+
+	job, err := sc.Schedule(ctx, "1", spam, recheck.ReasonEdit, time.Now())
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	// Jobs that aren't due are left alone.
+	later := map[string]string{"comment_content": "Hello"}
+	sc.Schedule(ctx, "2", later, recheck.ReasonQueueRetry, time.Now().Add(time.Hour))
+
+	n, err := sc.Flush(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if n != 1 {
+		t.Errorf("Expected 1 verdict, got %d", n)
+	}
+
+	calls := checker.calls()
+	if len(calls) != 1 || calls[0]["recheck_reason"] != "edit" || calls[0]["comment_author"] != "viagra-test-123" {
+		t.Errorf("Expected a check with recheck_reason edit, got %v", calls)
+	}
+
+	if _, ok := spam["recheck_reason"]; ok {
+		t.Error("Expected the scheduled values to be unchanged")
+	}
+
+	got := out.get()
+	if len(got) != 1 {
+		t.Fatalf("Expected 1 callback, got %d", len(got))
+	}
+
+	if got[0].Job.ID != job.ID || got[0].Err != nil || got[0].Result.Status != gokismet.StatusDefiniteSpam {
+		t.Errorf("Expected a spam verdict for job %s, got %+v", job.ID, got[0])
+	}
+
+	jobs, err := sc.List(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if len(jobs) != 1 || jobs[0].Reason != recheck.ReasonQueueRetry {
+		t.Errorf("Expected 1 remaining job, got %+v", jobs)
+	}
+
+	if err := sc.Cancel(ctx, jobs[0].ID); err != nil {
+		t.Errorf("Unexpected error %s", err)
+	}
+
+	if err := sc.Cancel(ctx, jobs[0].ID); err != recheck.ErrNotFound {
+		t.Errorf("Expected error %v, got %v", recheck.ErrNotFound, err)
+	}
+}
+
+// TestScheduler_Backoff verifies that failed checks are
+// retried with exponential backoff.
+func TestScheduler_Backoff(t *testing.T) {
+
+	ctx := context.Background()
+	c := newClock()
+
+	checker := &flakyChecker{
+		failures: 2,
+		err:      errors.New("connection reset"),
+	}
+	var out outcomes
+
+	sc := recheck.New(checker, nil, out.callback, &recheck.Options{
+		Backoff: time.Minute,
+		Now:     c.Now,
+	})
+
+	sc.Schedule(ctx, "1", spam, recheck.ReasonQueueRetry, c.Now())
+
+	sc.Flush(ctx)
+
+	// The retry isn't due yet.
+	c.Advance(59 * time.Second)
+	sc.Flush(ctx)
+
+	if calls := len(checker.calls()); calls != 1 {
+		t.Errorf("Expected 1 check, got %d", calls)
+	}
+
+	jobs, _ := sc.List(ctx)
+	if len(jobs) != 1 || jobs[0].Attempts != 1 || jobs[0].LastError != "connection reset" {
+		t.Fatalf("Expected a job after 1 attempt, got %+v", jobs)
+	}
+
+	c.Advance(time.Second)
+	sc.Flush(ctx)
+
+	// The second retry waits twice as long.
+	c.Advance(time.Minute)
+	sc.Flush(ctx)
+
+	if calls := len(checker.calls()); calls != 2 {
+		t.Errorf("Expected 2 checks, got %d", calls)
+	}
+
+	if len(out.get()) != 0 {
+		t.Errorf("Expected no callbacks before a verdict")
+	}
+
+	c.Advance(time.Minute)
+	sc.Flush(ctx)
+
+	got := out.get()
+	if len(got) != 1 || got[0].Err != nil || got[0].Job.Attempts != 3 {
+		t.Errorf("Expected a verdict after 3 attempts, got %+v", got)
+	}
+}
+
+// TestScheduler_GiveUp verifies that the callback receives
+// the error when a Job fails permanently or too many times.
+func TestScheduler_GiveUp(t *testing.T) {
+
+	ctx := context.Background()
+
+	valErr := &gokismet.ValError{Method: "comment-check", Response: "nope"}
+	checker := &flakyChecker{
+		failures: 1,
+		err:      valErr,
+	}
+	var out outcomes
+
+	c := newClock()
+
+	sc := recheck.New(checker, nil, out.callback, &recheck.Options{
+		MaxAttempts: 3,
+		Backoff:     time.Minute,
+		Now:         c.Now,
+	})
+
+	// Permanent errors are not retried.
+	sc.Schedule(ctx, "1", spam, recheck.ReasonEdit, c.Now())
+	sc.Flush(ctx)
+
+	got := out.get()
+	if len(got) != 1 || got[0].Err != valErr || got[0].Result != nil {
+		t.Fatalf("Expected a callback with error %v, got %+v", valErr, got)
+	}
+
+	// Transient errors give up after MaxAttempts.
+	checker.mu.Lock()
+	checker.failures = 10
+	checker.err = errors.New("connection reset")
+	checker.mu.Unlock()
+
+	sc.Schedule(ctx, "2", spam, recheck.ReasonEdit, c.Now())
+
+	for i := 0; i < 3; i++ {
+		sc.Flush(ctx)
+		c.Advance(time.Hour)
+	}
+
+	got = out.get()
+	if len(got) != 2 || got[1].Err == nil || got[1].Job.Attempts != 3 {
+		t.Errorf("Expected a failure after 3 attempts, got %+v", got)
+	}
+
+	if jobs, _ := sc.List(ctx); len(jobs) != 0 {
+		t.Errorf("Expected no remaining jobs, got %d", len(jobs))
+	}
+}
+
+// TestScheduler_Run verifies that Run checks Jobs as they are
+// scheduled, and that Jobs survive in the Store.
+func TestScheduler_Run(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	st := store.NewMemory()
+
+	// Jobs scheduled by an earlier Scheduler are picked up.
+	recheck.New(&flakyChecker{}, st, nil, nil).Schedule(ctx, "1", spam, recheck.ReasonEdit, time.Now())
+
+	done := make(chan outcome, 2)
+	sc := recheck.New(&flakyChecker{}, st, func(ctx context.Context, job *recheck.Job, result *gokismet.CheckResult, err error) {
+		done <- outcome{job, result, err}
+	}, &recheck.Options{PollInterval: time.Hour})
+
+	errc := make(chan error, 1)
+	go func() { errc <- sc.Run(ctx) }()
+
+	for i, values := range []map[string]string{spam, {"comment_content": "Hello"}} {
+
+		if i > 0 {
+			sc.Schedule(ctx, "2", values, recheck.ReasonEdit, time.Now())
+		}
+
+		select {
+		case o := <-done:
+			if o.Job.Values["comment_content"] != values["comment_content"] {
+				t.Errorf("Job %d: Expected values %v, got %v", i+1, values, o.Job.Values)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Job %d: Timed out waiting for a verdict", i+1)
+		}
+	}
+
+	cancel()
+
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("Expected error %v, got %v", context.Canceled, err)
+	}
+}
+
+// TestScheduler_Edit verifies that rescheduling content
+// replaces the Job for its earlier version.
+func TestScheduler_Edit(t *testing.T) {
+
+	ctx := context.Background()
+	checker := &flakyChecker{}
+	var out outcomes
+
+	sc := recheck.New(checker, nil, out.callback, nil)
+
+	sc.Schedule(ctx, "42", map[string]string{"comment_content": "First draft"}, recheck.ReasonEdit, time.Now())
+	sc.Schedule(ctx, "42", map[string]string{"comment_content": "Second draft"}, recheck.ReasonEdit, time.Now())
+
+	if _, err := sc.Schedule(ctx, "", spam, recheck.ReasonEdit, time.Now()); err != recheck.ErrNoID {
+		t.Errorf("Expected error %v, got %v", recheck.ErrNoID, err)
+	}
+
+	sc.Flush(ctx)
+
+	calls := checker.calls()
+	if len(calls) != 1 || calls[0]["comment_content"] != "Second draft" {
+		t.Errorf("Expected a single check of the latest version, got %v", calls)
+	}
+
+	if got := out.get(); len(got) != 1 || got[0].Job.ID != "42" {
+		t.Errorf("Expected 1 callback for job 42, got %+v", got)
+	}
+}
+
+// TestScheduler_RunError verifies that Run passes storage
+// errors to the OnError option.
+func TestScheduler_RunError(t *testing.T) {
+
+	st := store.NewMemory()
+	st.Close()
+
+	errc := make(chan error, 1)
+	sc := recheck.New(&flakyChecker{}, st, nil, &recheck.Options{
+		OnError: func(err error) {
+			select {
+			case errc <- err:
+			default:
+			}
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go sc.Run(ctx)
+
+	select {
+	case err := <-errc:
+		if err != store.ErrClosed {
+			t.Errorf("Expected error %v, got %v", store.ErrClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for an error")
+	}
+}
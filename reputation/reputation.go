@@ -0,0 +1,461 @@
+/*
+Package reputation tracks how trustworthy commenters are
+based on the outcome of earlier spam checks.
+
+A Tracker records ham and spam outcomes for each identity in
+a submission: the author's email address, their IP address
+and their user ID. Outcomes decay over time, so old
+behaviour counts for less than recent behaviour. Each
+identity gets a trust score between -1 (always spam) and 1
+(always ham).
+
+Wrap a SpamChecker to record the outcome of each check and
+report, and add the Tracker's Stage to a Pipeline ahead of
+Akismet to skip checks for trusted users and hold content
+from repeat offenders. The wrapped SpamChecker is added to
+the Pipeline with gokismet.CheckStage:
+
+	tracker := reputation.New(st, nil)
+	checker := tracker.Wrap(akismet)
+
+	pipeline := gokismet.NewPipeline().
+		Add("reputation", tracker.Stage()).
+		Add("akismet", gokismet.CheckStage(checker))
+*/
+package reputation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deepilla/gokismet"
+	"github.com/deepilla/gokismet/store"
+)
+
+// Collection is the name of the store collection that holds
+// a Tracker's scores.
+const Collection = "reputation"
+
+// Akismet parameters that identify a commenter.
+const (
+	paramEmail  = "comment_author_email"
+	paramIP     = "user_ip"
+	paramUserID = "user_id"
+)
+
+// A Kind is a type of commenter identity.
+type Kind string
+
+// Supported identity kinds.
+const (
+	// KindEmail identifies commenters by email address.
+	KindEmail Kind = "email"
+	// KindIP identifies commenters by IP address.
+	KindIP Kind = "ip"
+	// KindUser identifies commenters by user ID.
+	KindUser Kind = "user"
+)
+
+// An Outcome is a verdict on a piece of content.
+type Outcome int
+
+// Supported outcomes.
+const (
+	// OutcomeHam means the content was legitimate.
+	OutcomeHam Outcome = iota
+	// OutcomeSpam means the content was spam.
+	OutcomeSpam
+)
+
+// A Score is the reputation of a single identity.
+type Score struct {
+	// The kind of identity.
+	Kind Kind `json:"kind"`
+	// The identity, e.g. an email address.
+	ID string `json:"id"`
+	// The decayed weight of ham outcomes.
+	Ham float64 `json:"ham"`
+	// The decayed weight of spam outcomes.
+	Spam float64 `json:"spam"`
+	// When the Score was last updated.
+	Updated time.Time `json:"updated"`
+	// The trust score, from -1 to 1. Identities with no
+	// history have a trust score of 0.
+	Trust float64 `json:"-"`
+}
+
+// Options configures a Tracker.
+type Options struct {
+	// The time it takes for an outcome's weight to halve.
+	// Defaults to 30 days.
+	HalfLife time.Duration
+	// The weight of an outcome reported by a person, as
+	// opposed to a spam check. Defaults to 5.
+	ReportWeight float64
+	// The number of imaginary neutral outcomes added to
+	// each identity's history, so that a few outcomes don't
+	// produce an extreme score. Defaults to 5.
+	Prior float64
+	// The trust score at or above which the Stage approves
+	// content. Defaults to 0.8.
+	TrustedThreshold float64
+	// The trust score at or below which the Stage holds
+	// content. Defaults to -0.5.
+	OffenderThreshold float64
+	// Allows the Stage to approve content from trusted email
+	// addresses. Email addresses are supplied by commenters
+	// and not verified, so anyone who knows a trusted
+	// commenter's address can use it to skip spam checks.
+	// Only enable this if your site verifies email addresses
+	// itself. By default, only user IDs can approve content.
+	TrustEmail bool
+	// Receives errors from recording the outcome of checks
+	// made by wrapped SpamCheckers. If nil, the errors are
+	// ignored.
+	OnError func(err error)
+	// Returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// A Tracker records outcomes and computes trust scores. It
+// is safe for concurrent use, but only one Tracker should use
+// a given Store.
+type Tracker struct {
+	store store.Store
+	opts  Options
+
+	// mu serialises updates to Scores.
+	mu sync.Mutex
+}
+
+// New returns a Tracker that stores Scores in the given
+// Store. If s is nil, Scores are kept in memory. If opts is
+// nil, default options are used.
+func New(s store.Store, opts *Options) *Tracker {
+
+	if s == nil {
+		s = store.NewMemory()
+	}
+
+	t := &Tracker{
+		store: s,
+	}
+
+	if opts != nil {
+		t.opts = *opts
+	}
+	if t.opts.HalfLife <= 0 {
+		t.opts.HalfLife = 30 * 24 * time.Hour
+	}
+	if t.opts.ReportWeight <= 0 {
+		t.opts.ReportWeight = 5
+	}
+	if t.opts.Prior <= 0 {
+		t.opts.Prior = 5
+	}
+	if t.opts.TrustedThreshold == 0 {
+		t.opts.TrustedThreshold = 0.8
+	}
+	if t.opts.OffenderThreshold == 0 {
+		t.opts.OffenderThreshold = -0.5
+	}
+	if t.opts.Now == nil {
+		t.opts.Now = time.Now
+	}
+
+	return t
+}
+
+// Record adds an outcome to the history of each identity in
+// a set of key-value pairs.
+func (t *Tracker) Record(ctx context.Context, values map[string]string, outcome Outcome) error {
+	return t.record(ctx, values, outcome, 1)
+}
+
+func (t *Tracker) record(ctx context.Context, values map[string]string, outcome Outcome, weight float64) error {
+
+	ids := identities(values)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.opts.Now()
+
+	for _, id := range ids {
+
+		score, err := t.Get(ctx, id.kind, id.id)
+		if err != nil {
+			return err
+		}
+
+		switch outcome {
+		case OutcomeHam:
+			score.Ham += weight
+		case OutcomeSpam:
+			score.Spam += weight
+		}
+		score.Updated = now
+
+		b, err := json.Marshal(score)
+		if err != nil {
+			return err
+		}
+
+		if err := t.store.Put(ctx, Collection, key(id.kind, id.id), b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get returns the current Score for an identity. Identities
+// with no history have an empty Score.
+func (t *Tracker) Get(ctx context.Context, kind Kind, id string) (*Score, error) {
+
+	id = normalize(kind, id)
+	k := key(kind, id)
+
+	score := &Score{
+		Kind: kind,
+		ID:   id,
+	}
+
+	b, err := t.store.Get(ctx, Collection, k)
+	if err != nil && err != store.ErrNotFound {
+		return nil, err
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(b, score); err != nil {
+			return nil, fmt.Errorf("reputation: score %s: %w", k, err)
+		}
+		t.decay(score)
+	}
+
+	score.Trust = (score.Ham - score.Spam) / (score.Ham + score.Spam + t.opts.Prior)
+
+	return score, nil
+}
+
+// Scores returns the current Score for each identity in a
+// set of key-value pairs.
+func (t *Tracker) Scores(ctx context.Context, values map[string]string) ([]*Score, error) {
+
+	ids := identities(values)
+	scores := make([]*Score, 0, len(ids))
+
+	for _, id := range ids {
+		score, err := t.Get(ctx, id.kind, id.id)
+		if err != nil {
+			return nil, err
+		}
+		scores = append(scores, score)
+	}
+
+	return scores, nil
+}
+
+// decay reduces the weight of a Score's outcomes according
+// to the time since it was last updated.
+func (t *Tracker) decay(score *Score) {
+
+	now := t.opts.Now()
+
+	elapsed := now.Sub(score.Updated)
+	if elapsed <= 0 {
+		return
+	}
+
+	f := math.Pow(0.5, float64(elapsed)/float64(t.opts.HalfLife))
+	score.Ham *= f
+	score.Spam *= f
+	score.Updated = now
+}
+
+// Stage returns a Pipeline stage that decides content based
+// on the reputation of its author. Content is held as
+// probable spam if any of its identities is a repeat
+// offender. Otherwise, it is approved as ham if the author's
+// user ID is trusted. IP addresses are often shared, and
+// email addresses are unverified, so they can hold content
+// but not approve it (see Options.TrustEmail). In all other
+// cases, the stage defers to later stages.
+func (t *Tracker) Stage() gokismet.Stage {
+	return gokismet.StageFunc(func(ctx context.Context, values map[string]string) (*gokismet.StageResult, error) {
+
+		scores, err := t.Scores(ctx, values)
+		if err != nil {
+			return nil, err
+		}
+
+		var trusted *Score
+
+		for _, score := range scores {
+			if score.Trust <= t.opts.OffenderThreshold {
+				return result(gokismet.StatusProbableSpam, score, "a repeat offender"), nil
+			}
+			if t.canApprove(score.Kind) && score.Trust >= t.opts.TrustedThreshold && trusted == nil {
+				trusted = score
+			}
+		}
+
+		if trusted != nil {
+			return result(gokismet.StatusHam, trusted, "trusted"), nil
+		}
+
+		return nil, nil
+	})
+}
+
+// canApprove reports whether a trusted identity of the given
+// kind is enough to approve content.
+func (t *Tracker) canApprove(kind Kind) bool {
+	return kind == KindUser || kind == KindEmail && t.opts.TrustEmail
+}
+
+func result(status gokismet.SpamStatus, score *Score, desc string) *gokismet.StageResult {
+
+	trust := fmt.Sprintf("%.2f", score.Trust)
+
+	return &gokismet.StageResult{
+		Status: status,
+		Reason: fmt.Sprintf("%s %s is %s (trust %s)", score.Kind, score.ID, desc, trust),
+		Annotations: map[string]string{
+			"reputation": string(score.Kind) + ":" + score.ID,
+			"trust":      trust,
+		},
+	}
+}
+
+// An identity is a single commenter identity.
+type identity struct {
+	kind Kind
+	id   string
+}
+
+// identities returns the commenter identities in a set of
+// key-value pairs.
+func identities(values map[string]string) []identity {
+
+	var ids []identity
+
+	for _, p := range []struct {
+		kind  Kind
+		param string
+	}{
+		{KindEmail, paramEmail},
+		{KindUser, paramUserID},
+		{KindIP, paramIP},
+	} {
+		if id := normalize(p.kind, values[p.param]); id != "" {
+			ids = append(ids, identity{p.kind, id})
+		}
+	}
+
+	return ids
+}
+
+// normalize returns the canonical form of an identity.
+func normalize(kind Kind, id string) string {
+
+	id = strings.TrimSpace(id)
+	if kind == KindEmail {
+		id = strings.ToLower(id)
+	}
+
+	return id
+}
+
+func key(kind Kind, id string) string {
+	return string(kind) + ":" + id
+}
+
+// Wrap returns a SpamChecker that records the outcome of each
+// check and report made with sc. Reports carry the weight
+// given in the Tracker's Options.
+func (t *Tracker) Wrap(sc gokismet.SpamChecker) gokismet.SpamChecker {
+	return &checker{
+		SpamChecker: sc,
+		tracker:     t,
+	}
+}
+
+// A checker records the outcomes of a SpamChecker's checks
+// and reports.
+type checker struct {
+	gokismet.SpamChecker
+	tracker *Tracker
+}
+
+func (c *checker) Check(values map[string]string) (gokismet.SpamStatus, error) {
+
+	result, err := c.CheckContext(context.Background(), values)
+	if err != nil {
+		return gokismet.StatusUnknown, err
+	}
+
+	return result.Status, nil
+}
+
+func (c *checker) CheckContext(ctx context.Context, values map[string]string) (*gokismet.CheckResult, error) {
+
+	result, err := c.SpamChecker.CheckContext(ctx, values)
+	if err != nil {
+		return nil, err
+	}
+
+	outcome := OutcomeSpam
+	if result.Status == gokismet.StatusHam {
+		outcome = OutcomeHam
+	}
+
+	c.handle(c.tracker.record(ctx, values, outcome, 1))
+
+	return result, nil
+}
+
+func (c *checker) ReportHam(values map[string]string) error {
+	return c.ReportHamContext(context.Background(), values)
+}
+
+func (c *checker) ReportHamContext(ctx context.Context, values map[string]string) error {
+	return c.report(ctx, values, OutcomeHam, c.SpamChecker.ReportHamContext)
+}
+
+func (c *checker) ReportSpam(values map[string]string) error {
+	return c.ReportSpamContext(context.Background(), values)
+}
+
+func (c *checker) ReportSpamContext(ctx context.Context, values map[string]string) error {
+	return c.report(ctx, values, OutcomeSpam, c.SpamChecker.ReportSpamContext)
+}
+
+func (c *checker) report(ctx context.Context, values map[string]string, outcome Outcome, fn func(context.Context, map[string]string) error) error {
+
+	err := fn(ctx, values)
+
+	// Unsupported reports are still a person's verdict.
+	if err != nil && !errors.Is(err, gokismet.ErrUnsupported) {
+		return err
+	}
+
+	c.handle(c.tracker.record(ctx, values, outcome, c.tracker.opts.ReportWeight))
+
+	return err
+}
+
+func (c *checker) handle(err error) {
+	if err != nil && c.tracker.opts.OnError != nil {
+		c.tracker.opts.OnError(err)
+	}
+}
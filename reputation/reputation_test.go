@@ -0,0 +1,302 @@
+package reputation_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/deepilla/gokismet"
+	"github.com/deepilla/gokismet/reputation"
+)
+
+// clock is a manually advanced time source.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newClock() *clock {
+	return &clock{now: time.Date(2016, time.May, 5, 10, 30, 0, 0, time.UTC)}
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// TestTracker verifies that outcomes are recorded per
+// identity and decay over time.
+func TestTracker(t *testing.T) {
+
+	ctx := context.Background()
+	c := newClock()
+
+	tracker := reputation.New(nil, &reputation.Options{
+		HalfLife: 24 * time.Hour,
+		Now:      c.Now,
+	})
+
+	values := map[string]string{
+		"comment_author_email": " Alice@Example.com",
+		"user_ip":              "192.168.0.1",
+		"user_id":              "42",
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := tracker.Record(ctx, values, reputation.OutcomeHam); err != nil {
+			t.Fatalf("Unexpected error %s", err)
+		}
+	}
+
+	tracker.Record(ctx, map[string]string{"user_ip": "192.168.0.1"}, reputation.OutcomeSpam)
+
+	scores, err := tracker.Scores(ctx, values)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	expected := []struct {
+		Kind reputation.Kind
+		ID   string
+		Ham  float64
+		Spam float64
+	}{
+		{reputation.KindEmail, "alice@example.com", 3, 0},
+		{reputation.KindUser, "42", 3, 0},
+		{reputation.KindIP, "192.168.0.1", 3, 1},
+	}
+
+	if len(scores) != len(expected) {
+		t.Fatalf("Expected %d scores, got %d", len(expected), len(scores))
+	}
+
+	for i, exp := range expected {
+		s := scores[i]
+		if s.Kind != exp.Kind || s.ID != exp.ID || s.Ham != exp.Ham || s.Spam != exp.Spam {
+			t.Errorf("Score %d: Expected %+v, got %+v", i+1, exp, *s)
+		}
+	}
+
+	// Trust is (ham - spam) / (ham + spam + prior).
+	if !approx(scores[0].Trust, 3.0/8) {
+		t.Errorf("Expected trust %v, got %v", 3.0/8, scores[0].Trust)
+	}
+
+	// After one half-life, outcomes count for half as much.
+	c.now = c.now.Add(24 * time.Hour)
+
+	s, err := tracker.Get(ctx, reputation.KindEmail, "ALICE@example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if !approx(s.Ham, 1.5) {
+		t.Errorf("Expected decayed ham %v, got %v", 1.5, s.Ham)
+	}
+
+	// Unknown identities are neutral.
+	s, err = tracker.Get(ctx, reputation.KindEmail, "bob@example.com")
+	if err != nil || s.Trust != 0 || s.Ham != 0 || s.Spam != 0 {
+		t.Errorf("Expected an empty score, got %+v, %v", s, err)
+	}
+}
+
+// TestStage verifies that trusted authors are approved,
+// repeat offenders are held, and everyone else is deferred.
+func TestStage(t *testing.T) {
+
+	ctx := context.Background()
+	tracker := reputation.New(nil, &reputation.Options{
+		Now: newClock().Now,
+	})
+	stage := tracker.Stage()
+
+	trusted := map[string]string{"comment_author_email": "alice@example.com", "user_id": "42", "user_ip": "10.0.0.1"}
+	offender := map[string]string{"comment_author_email": "spammer@example.com", "user_ip": "203.0.113.7"}
+
+	for i := 0; i < 30; i++ {
+		tracker.Record(ctx, trusted, reputation.OutcomeHam)
+	}
+	for i := 0; i < 10; i++ {
+		tracker.Record(ctx, offender, reputation.OutcomeSpam)
+	}
+
+	tests := []struct {
+		Values map[string]string
+		Status gokismet.SpamStatus
+		Reason string
+	}{
+		{
+			Values: trusted,
+			Status: gokismet.StatusHam,
+			Reason: "user 42 is trusted (trust 0.86)",
+		},
+		{
+			// Email addresses are unverified, so they don't
+			// approve content by default.
+			Values: map[string]string{"comment_author_email": "alice@example.com", "user_ip": "10.0.0.1"},
+		},
+		{
+			// Trusted IPs alone don't approve content.
+			Values: map[string]string{"user_ip": "10.0.0.1"},
+		},
+		{
+			// Offending IPs hold content, even from trusted
+			// authors.
+			Values: map[string]string{"user_id": "42", "user_ip": "203.0.113.7"},
+			Status: gokismet.StatusProbableSpam,
+			Reason: "ip 203.0.113.7 is a repeat offender (trust -0.67)",
+		},
+		{
+			Values: map[string]string{"comment_author_email": "bob@example.com"},
+		},
+	}
+
+	for i, test := range tests {
+
+		sr, err := stage.Evaluate(ctx, test.Values)
+		if err != nil {
+			t.Fatalf("Test %d: Unexpected error %s", i+1, err)
+		}
+
+		if test.Status == gokismet.StatusUnknown {
+			if sr != nil {
+				t.Errorf("Test %d: Expected the stage to defer, got %+v", i+1, sr)
+			}
+			continue
+		}
+
+		if sr == nil || sr.Status != test.Status || sr.Reason != test.Reason {
+			t.Errorf("Test %d: Expected %s %q, got %+v", i+1, test.Status, test.Reason, sr)
+		}
+	}
+}
+
+// TestStage_TrustEmail verifies that trusted email addresses
+// approve content when enabled.
+func TestStage_TrustEmail(t *testing.T) {
+
+	ctx := context.Background()
+	tracker := reputation.New(nil, &reputation.Options{
+		TrustEmail: true,
+		Now:        newClock().Now,
+	})
+
+	values := map[string]string{"comment_author_email": "alice@example.com"}
+	for i := 0; i < 30; i++ {
+		tracker.Record(ctx, values, reputation.OutcomeHam)
+	}
+
+	sr, err := tracker.Stage().Evaluate(ctx, values)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if sr == nil || sr.Status != gokismet.StatusHam {
+		t.Errorf("Expected %s, got %+v", gokismet.StatusHam, sr)
+	}
+}
+
+// TestWrap verifies that wrapped SpamCheckers record the
+// outcome of checks and reports.
+func TestWrap(t *testing.T) {
+
+	ctx := context.Background()
+	var errs []error
+	tracker := reputation.New(nil, &reputation.Options{
+		OnError: func(err error) { errs = append(errs, err) },
+		Now:     newClock().Now,
+	})
+
+	fake := &gokismet.Fake{}
+	checker := tracker.Wrap(fake)
+
+	spam := map[string]string{"comment_author": "viagra-test-123", "user_ip": "203.0.113.7"}
+
+	status, err := checker.Check(spam)
+	if err != nil || status != gokismet.StatusDefiniteSpam {
+		t.Fatalf("Expected %s, got %s, %v", gokismet.StatusDefiniteSpam, status, err)
+	}
+
+	// A person's report outweighs the check.
+	if err := checker.ReportHam(spam); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	s, _ := tracker.Get(ctx, reputation.KindIP, "203.0.113.7")
+	if s.Spam != 1 || s.Ham != 5 {
+		t.Errorf("Expected 5 ham and 1 spam, got %v and %v", s.Ham, s.Spam)
+	}
+
+	if len(fake.Reports()) != 1 {
+		t.Errorf("Expected the report to reach the SpamChecker")
+	}
+
+	// Failed checks are not recorded.
+	_, err = checker.CheckContext(canceled(), spam)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected error %v, got %v", context.Canceled, err)
+	}
+
+	s, _ = tracker.Get(ctx, reputation.KindIP, "203.0.113.7")
+	if s.Spam != 1 {
+		t.Errorf("Expected 1 spam, got %v", s.Spam)
+	}
+
+	if len(errs) != 0 {
+		t.Errorf("Unexpected errors %v", errs)
+	}
+}
+
+// TestPipeline verifies that a Tracker's Stage and wrapped
+// SpamChecker work together in a Pipeline.
+func TestPipeline(t *testing.T) {
+
+	ctx := context.Background()
+	tracker := reputation.New(nil, &reputation.Options{
+		Now: newClock().Now,
+	})
+
+	fake := &gokismet.Fake{}
+	checker := tracker.Wrap(fake)
+
+	pipeline := gokismet.NewPipeline().
+		Add("reputation", tracker.Stage()).
+		Add("akismet", gokismet.CheckStage(checker))
+
+	values := map[string]string{"comment_author": "Alice", "user_id": "42"}
+
+	// Until the user is trusted, the wrapped SpamChecker
+	// decides and its outcomes are recorded.
+	for i := 0; i < 30; i++ {
+		v, err := pipeline.Run(ctx, values)
+		if err != nil {
+			t.Fatalf("Unexpected error %s", err)
+		}
+		if v.DecidedBy == "reputation" {
+			break
+		}
+		if v.DecidedBy != "akismet" || v.Status != gokismet.StatusHam {
+			t.Fatalf("Expected akismet to decide %s, got %s from %q", gokismet.StatusHam, v.Status, v.DecidedBy)
+		}
+	}
+
+	v, err := pipeline.Run(ctx, values)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if v.DecidedBy != "reputation" || v.Status != gokismet.StatusHam {
+		t.Errorf("Expected reputation to decide %s, got %s from %q", gokismet.StatusHam, v.Status, v.DecidedBy)
+	}
+}
+
+func canceled() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
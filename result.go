@@ -0,0 +1,97 @@
+package gokismet
+
+import (
+	"context"
+	"errors"
+)
+
+// paramGUID is the report parameter that identifies the
+// original comment-check call.
+const paramGUID = "guid"
+
+// Errors returned when reporting from a CheckResult.
+var (
+	// ErrNoResult means that there is no CheckResult to
+	// report.
+	ErrNoResult = errors.New("no check result to report")
+	// ErrSameVerdict means that a report would agree with
+	// the original verdict instead of correcting it.
+	ErrSameVerdict = errors.New("report agrees with the original verdict")
+)
+
+// ResultValues returns the key-value pairs needed to report
+// on a previous check: the original values, plus the GUID
+// that Akismet returned, if any.
+//
+// Cached and shared results carry the GUID of an earlier or
+// concurrent check, which may have been a different
+// submission (see KeyFilter). Their GUIDs are left out so
+// that reports don't refer to the wrong check.
+func ResultValues(result *CheckResult) map[string]string {
+
+	values := make(map[string]string, len(result.Values)+1)
+	for k, v := range result.Values {
+		values[k] = v
+	}
+
+	if result.GUID != "" && !result.Cached && !result.Shared {
+		values[paramGUID] = result.GUID
+	}
+
+	return values
+}
+
+// ReportHamResult is like ReportHamContext except that it
+// reports content from a CheckResult previously returned by
+// CheckContext. It sends the original key-value pairs plus
+// the GUID of the original check.
+//
+// ReportHam is for content that was incorrectly flagged as
+// spam. If the CheckResult's status is not spam, the report
+// is refused with ErrSameVerdict unless force is true.
+func (ch *Checker) ReportHamResult(ctx context.Context, result *CheckResult, force bool) error {
+
+	if err := checkVerdict(result, false, force); err != nil {
+		return err
+	}
+
+	return ch.ReportHamContext(ctx, ResultValues(result))
+}
+
+// ReportSpamResult is like ReportSpamContext except that it
+// reports content from a CheckResult previously returned by
+// CheckContext. It sends the original key-value pairs plus
+// the GUID of the original check.
+//
+// ReportSpam is for spam that went undetected. If the
+// CheckResult's status is not ham, the report is refused
+// with ErrSameVerdict unless force is true.
+func (ch *Checker) ReportSpamResult(ctx context.Context, result *CheckResult, force bool) error {
+
+	if err := checkVerdict(result, true, force); err != nil {
+		return err
+	}
+
+	return ch.ReportSpamContext(ctx, ResultValues(result))
+}
+
+// checkVerdict reports whether a ham or spam report would
+// correct a CheckResult.
+func checkVerdict(result *CheckResult, spam bool, force bool) error {
+
+	if result == nil {
+		return ErrNoResult
+	}
+
+	if force {
+		return nil
+	}
+
+	flagged := result.Status == StatusProbableSpam || result.Status == StatusDefiniteSpam
+
+	if spam && result.Status != StatusHam || !spam && !flagged {
+		return ErrSameVerdict
+	}
+
+	return nil
+}
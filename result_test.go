@@ -0,0 +1,208 @@
+package gokismet_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepilla/gokismet"
+)
+
+// TestReportResult verifies that reports from a CheckResult
+// resend the original values and GUID, and that reports that
+// don't correct the original verdict are refused.
+func TestReportResult(t *testing.T) {
+
+	var reports []url.Values
+
+	client := gokismet.ClientFunc(func(req *http.Request) (*http.Response, error) {
+
+		b, _ := io.ReadAll(req.Body)
+		params, _ := url.ParseQuery(string(b))
+
+		body := "valid"
+		if !strings.HasSuffix(req.URL.Path, "verify-key") {
+			body = "Thanks for making the web a better place."
+			reports = append(reports, params)
+		}
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Header:     make(http.Header),
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}, nil
+	})
+
+	ch := gokismet.NewCheckerClient(TestAPIKey, TestSite, client)
+
+	spam := &gokismet.CheckResult{
+		Status: gokismet.StatusProbableSpam,
+		Values: map[string]string{
+			"user_ip":         "10.0.0.1",
+			"comment_content": "Hello",
+		},
+		GUID: "abc123",
+	}
+
+	ham := &gokismet.CheckResult{
+		Status: gokismet.StatusHam,
+		Values: map[string]string{
+			"comment_content": "Buy pills",
+		},
+	}
+
+	tests := []struct {
+		Report func(context.Context, *gokismet.CheckResult, bool) error
+		Result *gokismet.CheckResult
+		Force  bool
+		Err    error
+		Params map[string]string
+	}{
+		{
+			Report: ch.ReportHamResult,
+			Result: spam,
+			Params: map[string]string{
+				"blog":            TestSite,
+				"user_ip":         "10.0.0.1",
+				"comment_content": "Hello",
+				"guid":            "abc123",
+			},
+		},
+		{
+			Report: ch.ReportSpamResult,
+			Result: ham,
+			Params: map[string]string{
+				"blog":            TestSite,
+				"comment_content": "Buy pills",
+			},
+		},
+		{
+			Report: ch.ReportSpamResult,
+			Result: spam,
+			Err:    gokismet.ErrSameVerdict,
+		},
+		{
+			Report: ch.ReportHamResult,
+			Result: ham,
+			Err:    gokismet.ErrSameVerdict,
+		},
+		{
+			// Forced reports are always sent.
+			Report: ch.ReportHamResult,
+			Result: ham,
+			Force:  true,
+			Params: map[string]string{
+				"blog":            TestSite,
+				"comment_content": "Buy pills",
+			},
+		},
+		{
+			Report: ch.ReportHamResult,
+			Force:  true,
+			Err:    gokismet.ErrNoResult,
+		},
+	}
+
+	compareValues := compareStringMap("parameter(s)")
+
+	for i, test := range tests {
+
+		reports = nil
+
+		err := test.Report(context.Background(), test.Result, test.Force)
+		if err != test.Err {
+			t.Errorf("Test %d: Expected error %v, got %v", i+1, test.Err, err)
+		}
+
+		if test.Params == nil {
+			if len(reports) != 0 {
+				t.Errorf("Test %d: Expected no reports, got %d", i+1, len(reports))
+			}
+			continue
+		}
+
+		if len(reports) != 1 {
+			t.Fatalf("Test %d: Expected 1 report, got %d", i+1, len(reports))
+		}
+
+		params := make(map[string]string)
+		for k := range reports[0] {
+			params[k] = reports[0].Get(k)
+		}
+
+		for _, err := range compareValues(test.Params, params) {
+			t.Errorf("Test %d: %s", i+1, err)
+		}
+	}
+}
+
+// TestResultValues verifies that CheckResults keep a copy of
+// the checked values, and that cached results don't report
+// the GUID of an earlier check.
+func TestResultValues(t *testing.T) {
+
+	client := &Responder{
+		Responses: map[string]*ResponseInfo{
+			"comment-check": {
+				Body:       "true",
+				StatusCode: http.StatusOK,
+				HeaderItems: map[string]string{
+					"X-akismet-guid": "abc123",
+				},
+			},
+		},
+	}
+	client.AddResponses(verifyingResponder)
+
+	ch := gokismet.NewCheckerClient(TestAPIKey, TestSite, client,
+		gokismet.WithCache(gokismet.NewLRUCache(10, time.Hour), nil))
+
+	values := map[string]string{
+		"comment_content": "Buy pills",
+	}
+
+	result, err := ch.CheckContext(context.Background(), values)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	cached, err := ch.CheckContext(context.Background(), values)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	// Changes to the caller's map don't affect the results.
+	values["comment_content"] = "Edited"
+
+	compareValues := compareStringMap("key-value pair(s)")
+
+	tests := []struct {
+		Result   *gokismet.CheckResult
+		Expected map[string]string
+	}{
+		{
+			Result: result,
+			Expected: map[string]string{
+				"comment_content": "Buy pills",
+				"guid":            "abc123",
+			},
+		},
+		{
+			Result: cached,
+			Expected: map[string]string{
+				"comment_content": "Buy pills",
+			},
+		},
+	}
+
+	for i, test := range tests {
+		for _, err := range compareValues(test.Expected, gokismet.ResultValues(test.Result)) {
+			t.Errorf("Test %d: %s", i+1, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package rules
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+// An Engine evaluates the rules loaded from a file and
+// reloads them when the file changes. It is safe for
+// concurrent use.
+type Engine struct {
+	filename string
+
+	mu      sync.RWMutex
+	rules   *RuleSet
+	modTime time.Time
+}
+
+// Load creates an Engine from the rules in the given JSON
+// file.
+func Load(filename string) (*Engine, error) {
+
+	e := &Engine{
+		filename: filename,
+	}
+
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Evaluate applies the Engine's current rules to a set of
+// key-value pairs.
+func (e *Engine) Evaluate(values map[string]string) *Decision {
+	return e.RuleSet().Evaluate(values)
+}
+
+// RuleSet returns the Engine's current rules.
+func (e *Engine) RuleSet() *RuleSet {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.rules
+}
+
+// Reload reads the Engine's rules file. If the file can't be
+// read or contains invalid rules, the existing rules are kept
+// and an error is returned.
+func (e *Engine) Reload() error {
+
+	f, err := os.Open(e.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	rs, err := Parse(f)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.rules = rs
+	e.modTime = fi.ModTime()
+	e.mu.Unlock()
+
+	return nil
+}
+
+// Watch checks the rules file for changes at the given
+// interval and reloads it when its modification time
+// changes. It blocks until the Context is done. Reload
+// errors are passed to onError, if non-nil, and do not
+// stop the Watch.
+func (e *Engine) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	e.mu.RLock()
+	seen := e.modTime
+	e.mu.RUnlock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		fi, err := os.Stat(e.filename)
+		if err == nil {
+			// Only attempt to reload each version of the
+			// file once, even if it turns out to be invalid.
+			if fi.ModTime().Equal(seen) {
+				continue
+			}
+			seen = fi.ModTime()
+
+			err = e.Reload()
+		}
+
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package rules_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/deepilla/gokismet"
+	"github.com/deepilla/gokismet/rules"
+)
+
+// TestEngine verifies that Engines reload their rules when
+// the rules file changes.
+func TestEngine(t *testing.T) {
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "rules.json")
+
+	write := func(s string, mtime time.Time) {
+		if err := ioutil.WriteFile(filename, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filename, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now().Add(-time.Hour)
+	write(`{"rules": [{"name": "pills", "type": "keyword", "action": "probable-spam", "values": ["viagra"]}]}`, start)
+
+	e, err := rules.Load(filename)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	values := map[string]string{
+		"comment_content": "Buy cialis",
+	}
+
+	if d := e.Evaluate(values); d.Matched() {
+		t.Errorf("Expected no match, got %q", d.Rule)
+	}
+
+	errs := make(chan error, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go e.Watch(ctx, time.Millisecond, func(err error) { errs <- err })
+
+	// An invalid file is reported and ignored.
+	write(`{"rules": [`, start.Add(time.Minute))
+
+	select {
+	case <-errs:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a reload error")
+	}
+
+	if d := e.Evaluate(values); d.Matched() {
+		t.Errorf("Expected the previous rules to be kept")
+	}
+
+	write(`{"rules": [{"name": "pills", "type": "keyword", "action": "probable-spam", "values": ["viagra", "cialis"]}]}`, start.Add(2*time.Minute))
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		d := e.Evaluate(values)
+		if d.Status == gokismet.StatusProbableSpam {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected rules to be reloaded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
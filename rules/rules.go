@@ -0,0 +1,460 @@
+/*
+Package rules implements a local pre-filter for gokismet. A
+RuleSet evaluates the same key-value pairs that Checker.Check
+takes against lists of IP addresses, email addresses, domains,
+keywords and patterns, and flags obvious spam (or obvious ham)
+without a call to Akismet.
+
+Rules are defined in JSON, e.g.
+
+	{
+	  "rules": [
+	    {"name": "office", "type": "ip", "action": "allow", "values": ["10.0.0.0/8"]},
+	    {"name": "bad-domains", "type": "domain", "action": "definite-spam", "values": ["spam.example"]},
+	    {"name": "pills", "type": "keyword", "action": "probable-spam", "values": ["viagra", "cialis"]},
+	    {"name": "too-many-links", "type": "links", "action": "probable-spam", "max": 3}
+	  ]
+	}
+
+Allow rules are evaluated first, so an allowlist always takes
+precedence. The remaining rules are evaluated in order and the
+first match wins.
+
+YAML is not supported, because the standard library has no
+YAML parser and gokismet has no third-party dependencies.
+To keep rules in YAML, decode them into a Config with the
+YAML package of your choice and pass it to Compile.
+*/
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/deepilla/gokismet"
+)
+
+// Akismet keys examined by the rules.
+const (
+	keyUserIP      = "user_ip"
+	keyAuthorEmail = "comment_author_email"
+	keyAuthorSite  = "comment_author_url"
+	keyContent     = "comment_content"
+)
+
+// Rule types.
+const (
+	TypeIP      = "ip"
+	TypeEmail   = "email"
+	TypeDomain  = "domain"
+	TypeKeyword = "keyword"
+	TypeRegex   = "regex"
+	TypeLinks   = "links"
+)
+
+// Rule actions.
+const (
+	ActionAllow        = "allow"
+	ActionProbableSpam = "probable-spam"
+	ActionDefiniteSpam = "definite-spam"
+)
+
+// A Config is the JSON representation of a RuleSet.
+type Config struct {
+	Rules []RuleConfig `json:"rules"`
+}
+
+// A RuleConfig is the JSON representation of a single rule.
+type RuleConfig struct {
+	// A name for the rule, reported when it matches.
+	Name string `json:"name"`
+	// The rule type, e.g. "ip" or "keyword".
+	Type string `json:"type"`
+	// What to do when the rule matches, e.g. "allow".
+	Action string `json:"action"`
+	// The values to match. For ip rules, these are IP
+	// addresses or CIDR ranges. For email rules, email
+	// addresses. For domain rules, domain names (which also
+	// match their subdomains). For keyword rules, words or
+	// phrases, which match whole words regardless of case.
+	// For regex rules, regular expressions.
+	Values []string `json:"values,omitempty"`
+	// The keys examined by keyword and regex rules. Defaults
+	// to comment_content.
+	Fields []string `json:"fields,omitempty"`
+	// The maximum number of links allowed by a links rule.
+	Max int `json:"max,omitempty"`
+}
+
+// A Decision is the outcome of evaluating a RuleSet.
+type Decision struct {
+	// The spam status implied by the matching rule. If no
+	// rule matched, the status is StatusUnknown, meaning
+	// the content should be checked with Akismet.
+	Status gokismet.SpamStatus
+	// The name of the matching rule, if any.
+	Rule string
+	// An explanation of the match, e.g. "user_ip 10.0.0.1
+	// is in 10.0.0.0/8".
+	Reason string
+}
+
+// Matched reports whether a rule matched.
+func (d *Decision) Matched() bool {
+	return d.Rule != ""
+}
+
+// A RuleSet is a compiled, immutable set of rules. It is safe
+// for concurrent use.
+type RuleSet struct {
+	allow []*rule
+	block []*rule
+}
+
+type rule struct {
+	name   string
+	status gokismet.SpamStatus
+	match  func(values map[string]string) (string, bool)
+}
+
+// Parse reads a JSON rules definition and compiles it into a
+// RuleSet.
+func Parse(r io.Reader) (*RuleSet, error) {
+
+	var cfg Config
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("rules: %s", err)
+	}
+
+	return Compile(&cfg)
+}
+
+// Compile compiles a Config into a RuleSet.
+func Compile(cfg *Config) (*RuleSet, error) {
+
+	rs := &RuleSet{}
+
+	for i := range cfg.Rules {
+
+		rc := &cfg.Rules[i]
+
+		r, err := compileRule(rc)
+		if err != nil {
+			name := rc.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			return nil, fmt.Errorf("rules: rule %s: %s", name, err)
+		}
+
+		if r.status == gokismet.StatusHam {
+			rs.allow = append(rs.allow, r)
+		} else {
+			rs.block = append(rs.block, r)
+		}
+	}
+
+	return rs, nil
+}
+
+func compileRule(rc *RuleConfig) (*rule, error) {
+
+	r := &rule{
+		name: rc.Name,
+	}
+
+	if r.name == "" {
+		return nil, errors.New("no name")
+	}
+
+	switch rc.Action {
+	case ActionAllow:
+		r.status = gokismet.StatusHam
+	case ActionProbableSpam:
+		r.status = gokismet.StatusProbableSpam
+	case ActionDefiniteSpam:
+		r.status = gokismet.StatusDefiniteSpam
+	default:
+		return nil, fmt.Errorf("unknown action %q", rc.Action)
+	}
+
+	var err error
+
+	switch rc.Type {
+	case TypeIP:
+		r.match, err = ipMatcher(rc.Values)
+	case TypeEmail:
+		r.match, err = emailMatcher(rc.Values)
+	case TypeDomain:
+		r.match, err = domainMatcher(rc.Values)
+	case TypeKeyword:
+		r.match, err = keywordMatcher(rc.Values, fieldsOrContent(rc.Fields))
+	case TypeRegex:
+		r.match, err = regexMatcher(rc.Values, fieldsOrContent(rc.Fields))
+	case TypeLinks:
+		r.match, err = linksMatcher(rc.Max)
+	default:
+		return nil, fmt.Errorf("unknown type %q", rc.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Evaluate applies the RuleSet to a set of key-value pairs.
+// Allow rules are applied first, followed by the remaining
+// rules in order. The first matching rule determines the
+// Decision. A nil RuleSet matches nothing.
+func (rs *RuleSet) Evaluate(values map[string]string) *Decision {
+
+	if rs != nil {
+		for _, rules := range [][]*rule{rs.allow, rs.block} {
+			for _, r := range rules {
+				if reason, ok := r.match(values); ok {
+					return &Decision{
+						Status: r.status,
+						Rule:   r.name,
+						Reason: reason,
+					}
+				}
+			}
+		}
+	}
+
+	return &Decision{
+		Status: gokismet.StatusUnknown,
+	}
+}
+
+func fieldsOrContent(fields []string) []string {
+	if len(fields) == 0 {
+		return []string{keyContent}
+	}
+	return fields
+}
+
+func ipMatcher(values []string) (func(map[string]string) (string, bool), error) {
+
+	var nets []*net.IPNet
+
+	for _, v := range values {
+
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipnet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %q", v)
+		}
+		nets = append(nets, ipnet)
+	}
+
+	return func(m map[string]string) (string, bool) {
+
+		ip := net.ParseIP(m[keyUserIP])
+		if ip == nil {
+			return "", false
+		}
+
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return fmt.Sprintf("%s %s is in %s", keyUserIP, ip, n), true
+			}
+		}
+
+		return "", false
+	}, nil
+}
+
+func emailMatcher(values []string) (func(map[string]string) (string, bool), error) {
+
+	emails := make(map[string]bool)
+	for _, v := range values {
+		emails[strings.ToLower(strings.TrimSpace(v))] = true
+	}
+
+	return func(m map[string]string) (string, bool) {
+		email := strings.ToLower(strings.TrimSpace(m[keyAuthorEmail]))
+		if email != "" && emails[email] {
+			return fmt.Sprintf("%s %s is listed", keyAuthorEmail, email), true
+		}
+		return "", false
+	}, nil
+}
+
+func domainMatcher(values []string) (func(map[string]string) (string, bool), error) {
+
+	var domains []string
+	for _, v := range values {
+		d := strings.ToLower(strings.Trim(strings.TrimSpace(v), "."))
+		if d == "" {
+			return nil, errors.New("empty domain")
+		}
+		domains = append(domains, d)
+	}
+
+	return func(m map[string]string) (string, bool) {
+		for _, host := range Domains(m) {
+			for _, d := range domains {
+				if host == d || strings.HasSuffix(host, "."+d) {
+					return fmt.Sprintf("domain %s matches %s", host, d), true
+				}
+			}
+		}
+		return "", false
+	}, nil
+}
+
+func keywordMatcher(values []string, fields []string) (func(map[string]string) (string, bool), error) {
+
+	type keyword struct {
+		word string
+		re   *regexp.Regexp
+	}
+
+	var keywords []keyword
+	for _, v := range values {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			// Keywords must not be part of a longer word,
+			// e.g. "cialis" shouldn't match "specialist".
+			re := regexp.MustCompile(`(?i)(?:^|[^\pL\pN_])` + regexp.QuoteMeta(v) + `(?:$|[^\pL\pN_])`)
+			keywords = append(keywords, keyword{v, re})
+		}
+	}
+
+	return func(m map[string]string) (string, bool) {
+		for _, f := range fields {
+			for _, k := range keywords {
+				if k.re.MatchString(m[f]) {
+					return fmt.Sprintf("%s contains %q", f, k.word), true
+				}
+			}
+		}
+		return "", false
+	}, nil
+}
+
+func regexMatcher(values []string, fields []string) (func(map[string]string) (string, bool), error) {
+
+	var patterns []*regexp.Regexp
+	for _, v := range values {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %s", v, err)
+		}
+		patterns = append(patterns, re)
+	}
+
+	return func(m map[string]string) (string, bool) {
+		for _, f := range fields {
+			for _, re := range patterns {
+				if re.MatchString(m[f]) {
+					return fmt.Sprintf("%s matches %s", f, re), true
+				}
+			}
+		}
+		return "", false
+	}, nil
+}
+
+func linksMatcher(max int) (func(map[string]string) (string, bool), error) {
+
+	if max < 0 {
+		return nil, errors.New("max must not be negative")
+	}
+
+	return func(m map[string]string) (string, bool) {
+		if n := len(Links(m[keyContent])); n > max {
+			return fmt.Sprintf("%s contains %d links (max %d)", keyContent, n, max), true
+		}
+		return "", false
+	}, nil
+}
+
+// linkPattern matches URLs in plain text or HTML.
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s"'<>]+`)
+
+// Links returns the URLs found in a piece of content.
+func Links(content string) []string {
+	return linkPattern.FindAllString(content, -1)
+}
+
+// Domains returns the lowercase domain names associated with
+// a set of key-value pairs: the domain of the author's email
+// address, the host of the author's website and the hosts of
+// any links in the content.
+func Domains(values map[string]string) []string {
+
+	var domains []string
+
+	if email := values[keyAuthorEmail]; email != "" {
+		if i := strings.LastIndexByte(email, '@'); i >= 0 {
+			domains = append(domains, strings.ToLower(email[i+1:]))
+		}
+	}
+
+	links := Links(values[keyContent])
+	if site := values[keyAuthorSite]; site != "" {
+		links = append([]string{site}, links...)
+	}
+
+	for _, link := range links {
+		if u, err := url.Parse(link); err == nil && u.Hostname() != "" {
+			domains = append(domains, strings.ToLower(u.Hostname()))
+		}
+	}
+
+	return domains
+}
+
+// An Evaluator evaluates rules against a set of key-value
+// pairs. It is satisfied by both RuleSet and Engine.
+type Evaluator interface {
+	Evaluate(values map[string]string) *Decision
+}
+
+// Stage returns a gokismet.Stage that evaluates rules as part
+// of a gokismet.Pipeline. If a rule matches, the stage decides
+// with the rule's status. Otherwise, it defers.
+func Stage(e Evaluator) gokismet.Stage {
+	return gokismet.StageFunc(func(ctx context.Context, values map[string]string) (*gokismet.StageResult, error) {
+
+		d := e.Evaluate(values)
+		if !d.Matched() {
+			return nil, nil
+		}
+
+		return &gokismet.StageResult{
+			Status: d.Status,
+			Reason: d.Rule + ": " + d.Reason,
+			Annotations: map[string]string{
+				"rule": d.Rule,
+			},
+		}, nil
+	})
+}
@@ -0,0 +1,233 @@
+package rules_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/deepilla/gokismet"
+	"github.com/deepilla/gokismet/rules"
+)
+
+const testRules = `{
+  "rules": [
+    {"name": "bad-ips", "type": "ip", "action": "definite-spam", "values": ["203.0.113.0/24", "2001:db8::1"]},
+    {"name": "office", "type": "ip", "action": "allow", "values": ["10.0.0.0/8"]},
+    {"name": "trusted", "type": "email", "action": "allow", "values": ["Editor@Example.com"]},
+    {"name": "bad-domains", "type": "domain", "action": "definite-spam", "values": ["spam.example"]},
+    {"name": "pills", "type": "keyword", "action": "probable-spam", "values": ["Viagra", "cialis"]},
+    {"name": "crypto", "type": "regex", "action": "probable-spam", "values": ["(?i)bit\\s*coin"], "fields": ["comment_author", "comment_content"]},
+    {"name": "too-many-links", "type": "links", "action": "probable-spam", "max": 2}
+  ]
+}`
+
+// TestRuleSet verifies that RuleSets produce the correct
+// Decisions.
+func TestRuleSet(t *testing.T) {
+
+	rs, err := rules.Parse(strings.NewReader(testRules))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	tests := []struct {
+		Values map[string]string
+		Status gokismet.SpamStatus
+		Rule   string
+		Reason string
+	}{
+		{
+			Values: map[string]string{
+				"user_ip":         "192.168.0.1",
+				"comment_content": "Hello world",
+			},
+			Status: gokismet.StatusUnknown,
+		},
+		{
+			Values: map[string]string{
+				"user_ip": "203.0.113.7",
+			},
+			Status: gokismet.StatusDefiniteSpam,
+			Rule:   "bad-ips",
+			Reason: "user_ip 203.0.113.7 is in 203.0.113.0/24",
+		},
+		{
+			Values: map[string]string{
+				"user_ip": "2001:db8::1",
+			},
+			Status: gokismet.StatusDefiniteSpam,
+			Rule:   "bad-ips",
+			Reason: "user_ip 2001:db8::1 is in 2001:db8::1/128",
+		},
+		{
+			// Allow rules take precedence.
+			Values: map[string]string{
+				"user_ip":         "10.1.2.3",
+				"comment_content": "Buy viagra",
+			},
+			Status: gokismet.StatusHam,
+			Rule:   "office",
+			Reason: "user_ip 10.1.2.3 is in 10.0.0.0/8",
+		},
+		{
+			Values: map[string]string{
+				"comment_author_email": "editor@example.com",
+				"comment_content":      "Buy viagra",
+			},
+			Status: gokismet.StatusHam,
+			Rule:   "trusted",
+			Reason: "comment_author_email editor@example.com is listed",
+		},
+		{
+			Values: map[string]string{
+				"comment_content": `Visit <a href="http://www.Spam.example/pills">here</a>`,
+			},
+			Status: gokismet.StatusDefiniteSpam,
+			Rule:   "bad-domains",
+			Reason: "domain www.spam.example matches spam.example",
+		},
+		{
+			Values: map[string]string{
+				"comment_author_email": "someone@spam.example",
+			},
+			Status: gokismet.StatusDefiniteSpam,
+			Rule:   "bad-domains",
+			Reason: "domain spam.example matches spam.example",
+		},
+		{
+			Values: map[string]string{
+				"comment_content": "Cheap VIAGRA here",
+			},
+			Status: gokismet.StatusProbableSpam,
+			Rule:   "pills",
+			Reason: `comment_content contains "viagra"`,
+		},
+		{
+			// Keywords match whole words only.
+			Values: map[string]string{
+				"comment_content": "Ask a specialist about Viagras",
+			},
+			Status: gokismet.StatusUnknown,
+		},
+		{
+			Values: map[string]string{
+				"comment_content": "Cialis!",
+			},
+			Status: gokismet.StatusProbableSpam,
+			Rule:   "pills",
+			Reason: `comment_content contains "cialis"`,
+		},
+		{
+			Values: map[string]string{
+				"comment_author": "Bit Coin Investor",
+			},
+			Status: gokismet.StatusProbableSpam,
+			Rule:   "crypto",
+			Reason: "comment_author matches (?i)bit\\s*coin",
+		},
+		{
+			Values: map[string]string{
+				"comment_content": "http://a.example http://b.example https://c.example",
+			},
+			Status: gokismet.StatusProbableSpam,
+			Rule:   "too-many-links",
+			Reason: "comment_content contains 3 links (max 2)",
+		},
+	}
+
+	for i, test := range tests {
+
+		d := rs.Evaluate(test.Values)
+
+		if d.Status != test.Status {
+			t.Errorf("Test %d: Expected Spam Status %s, got %s", i+1, test.Status, d.Status)
+		}
+
+		if d.Rule != test.Rule {
+			t.Errorf("Test %d: Expected Rule %q, got %q", i+1, test.Rule, d.Rule)
+		}
+
+		if d.Reason != test.Reason {
+			t.Errorf("Test %d: Expected Reason %q, got %q", i+1, test.Reason, d.Reason)
+		}
+
+		if d.Matched() != (test.Rule != "") {
+			t.Errorf("Test %d: Expected Matched to be %v", i+1, test.Rule != "")
+		}
+	}
+}
+
+// TestParse_Errors verifies that invalid rules are rejected.
+func TestParse_Errors(t *testing.T) {
+
+	tests := []struct {
+		JSON  string
+		Error string
+	}{
+		{
+			JSON:  `{"rules": [{"name": "x", "type": "ip", "action": "allow", "values": ["not-an-ip"]}]}`,
+			Error: `rules: rule x: invalid IP address "not-an-ip"`,
+		},
+		{
+			JSON:  `{"rules": [{"name": "x", "type": "regex", "action": "allow", "values": ["("]}]}`,
+			Error: "rules: rule x: invalid regex",
+		},
+		{
+			JSON:  `{"rules": [{"name": "x", "type": "magic", "action": "allow"}]}`,
+			Error: `rules: rule x: unknown type "magic"`,
+		},
+		{
+			JSON:  `{"rules": [{"name": "x", "type": "links", "action": "delete"}]}`,
+			Error: `rules: rule x: unknown action "delete"`,
+		},
+		{
+			JSON:  `{"rules": [{"type": "links", "action": "allow"}]}`,
+			Error: `rules: rule #1: no name`,
+		},
+		{
+			JSON:  `{"rulez": []}`,
+			Error: `rules: json: unknown field "rulez"`,
+		},
+	}
+
+	for i, test := range tests {
+		_, err := rules.Parse(strings.NewReader(test.JSON))
+		if err == nil || !strings.HasPrefix(err.Error(), test.Error) {
+			t.Errorf("Test %d: Expected error %q, got %v", i+1, test.Error, err)
+		}
+	}
+}
+
+// TestStage verifies that rules can be used as a Pipeline
+// stage.
+func TestStage(t *testing.T) {
+
+	rs, err := rules.Parse(strings.NewReader(testRules))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	stage := rules.Stage(rs)
+
+	sr, err := stage.Evaluate(context.Background(), map[string]string{
+		"user_ip": "192.168.0.1",
+	})
+	if err != nil || sr != nil {
+		t.Errorf("Expected the stage to defer, got %v, %v", sr, err)
+	}
+
+	sr, err = stage.Evaluate(context.Background(), map[string]string{
+		"user_ip": "203.0.113.7",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if sr.Status != gokismet.StatusDefiniteSpam {
+		t.Errorf("Expected Spam Status %v, got %v", gokismet.StatusDefiniteSpam, sr.Status)
+	}
+
+	if sr.Annotations["rule"] != "bad-ips" {
+		t.Errorf("Expected rule %q, got %q", "bad-ips", sr.Annotations["rule"])
+	}
+}
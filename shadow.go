@@ -0,0 +1,201 @@
+package gokismet
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Disagreement records a check for which a ShadowChecker's
+// primary and candidate SpamCheckers returned different spam
+// statuses.
+type Disagreement struct {
+	// The hash of the checked key-value pairs (see
+	// HashValues).
+	Hash string
+	// The status returned by the primary SpamChecker.
+	Primary SpamStatus
+	// The status returned by the candidate SpamChecker.
+	Candidate SpamStatus
+	// When the check was made.
+	Time time.Time
+}
+
+// A DisagreementSink receives Disagreements from a
+// ShadowChecker. Implementations must be safe for concurrent
+// use.
+type DisagreementSink interface {
+	Record(d *Disagreement)
+}
+
+// A DisagreementSinkFunc converts a standalone function into
+// a DisagreementSink.
+type DisagreementSinkFunc func(d *Disagreement)
+
+// Record calls a DisagreementSinkFunc's underlying function.
+func (f DisagreementSinkFunc) Record(d *Disagreement) {
+	f(d)
+}
+
+// A ShadowChecker is a SpamChecker for trialling a new
+// checking configuration alongside an existing one. It always
+// returns the primary SpamChecker's results. The candidate
+// SpamChecker checks the same content in the background, and
+// any disagreements are sent to the Sink for analysis.
+//
+// Reports are only sent to the primary SpamChecker.
+//
+// A ShadowChecker's fields must not be modified once it is in
+// use. It is safe for concurrent use if its SpamCheckers are.
+type ShadowChecker struct {
+	// The SpamChecker whose results are returned.
+	Primary SpamChecker
+	// The SpamChecker being evaluated.
+	Candidate SpamChecker
+	// Receives disagreements between the two SpamCheckers.
+	Sink DisagreementSink
+	// The maximum time allowed for each candidate check.
+	// Defaults to 10 seconds.
+	Budget time.Duration
+	// The maximum number of candidate checks allowed to run
+	// at once. Checks beyond this limit are not shadowed.
+	// Zero means no limit.
+	MaxInFlight int
+	// The keys used to identify content in Disagreements
+	// (see HashValues). If nil, all keys are used.
+	KeyFilter *KeyFilter
+
+	wg       sync.WaitGroup
+	inFlight int32
+	skipped  uint64
+}
+
+// ShadowChecker implements SpamChecker.
+var _ SpamChecker = (*ShadowChecker)(nil)
+
+// Check returns the primary SpamChecker's result, shadowed
+// by the candidate.
+func (sc *ShadowChecker) Check(values map[string]string) (SpamStatus, error) {
+
+	result, err := sc.CheckContext(context.Background(), values)
+	if err != nil {
+		return StatusUnknown, err
+	}
+
+	return result.Status, nil
+}
+
+// CheckContext is like Check except that it takes a Context
+// and returns a CheckResult. The candidate check is not bound
+// by ctx: it continues after CheckContext returns, subject to
+// the ShadowChecker's Budget.
+func (sc *ShadowChecker) CheckContext(ctx context.Context, values map[string]string) (*CheckResult, error) {
+
+	primary := make(chan *CheckResult, 1)
+
+	if sc.acquire() {
+		// The candidate check outlives this call, so it
+		// gets its own copy of the caller's values.
+		shadowValues := mergeStringMaps(values)
+		sc.wg.Add(1)
+		go func() {
+			defer sc.wg.Done()
+			defer sc.release()
+			sc.shadow(ctx, shadowValues, primary)
+		}()
+	}
+
+	result, err := sc.Primary.CheckContext(ctx, values)
+
+	// A nil result tells the candidate not to bother
+	// comparing.
+	primary <- result
+
+	return result, err
+}
+
+// shadow runs a candidate check and compares its result with
+// the primary result.
+func (sc *ShadowChecker) shadow(ctx context.Context, values map[string]string, primary <-chan *CheckResult) {
+
+	budget := sc.Budget
+	if budget <= 0 {
+		budget = 10 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), budget)
+	defer cancel()
+
+	start := time.Now()
+	candidate, err := sc.Candidate.CheckContext(ctx, values)
+
+	want := <-primary
+	if want == nil || err != nil || sc.Sink == nil {
+		return
+	}
+
+	if want.Status != candidate.Status {
+		sc.Sink.Record(&Disagreement{
+			Hash:      HashValues(values, sc.KeyFilter),
+			Primary:   want.Status,
+			Candidate: candidate.Status,
+			Time:      start,
+		})
+	}
+}
+
+// acquire reserves a slot for a candidate check, if one is
+// available.
+func (sc *ShadowChecker) acquire() bool {
+
+	n := atomic.AddInt32(&sc.inFlight, 1)
+	if sc.MaxInFlight > 0 && int(n) > sc.MaxInFlight {
+		atomic.AddInt32(&sc.inFlight, -1)
+		atomic.AddUint64(&sc.skipped, 1)
+		return false
+	}
+
+	return true
+}
+
+// release frees a slot reserved by acquire.
+func (sc *ShadowChecker) release() {
+	atomic.AddInt32(&sc.inFlight, -1)
+}
+
+// Skipped returns the number of checks that were not
+// shadowed because MaxInFlight was reached.
+func (sc *ShadowChecker) Skipped() uint64 {
+	return atomic.LoadUint64(&sc.skipped)
+}
+
+// Wait blocks until all of the ShadowChecker's background
+// checks have finished. Call it before shutting down to make
+// sure that all disagreements are recorded.
+func (sc *ShadowChecker) Wait() {
+	sc.wg.Wait()
+}
+
+// ReportHam reports legitimate content to the primary
+// SpamChecker.
+func (sc *ShadowChecker) ReportHam(values map[string]string) error {
+	return sc.Primary.ReportHam(values)
+}
+
+// ReportHamContext is like ReportHam except that it takes
+// a Context.
+func (sc *ShadowChecker) ReportHamContext(ctx context.Context, values map[string]string) error {
+	return sc.Primary.ReportHamContext(ctx, values)
+}
+
+// ReportSpam reports spam to the primary SpamChecker.
+func (sc *ShadowChecker) ReportSpam(values map[string]string) error {
+	return sc.Primary.ReportSpam(values)
+}
+
+// ReportSpamContext is like ReportSpam except that it takes
+// a Context.
+func (sc *ShadowChecker) ReportSpamContext(ctx context.Context, values map[string]string) error {
+	return sc.Primary.ReportSpamContext(ctx, values)
+}
@@ -0,0 +1,251 @@
+package gokismet_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deepilla/gokismet"
+)
+
+// disagreementRecorder is a DisagreementSink that keeps the
+// Disagreements it receives.
+type disagreementRecorder struct {
+	mu   sync.Mutex
+	recs []*gokismet.Disagreement
+}
+
+func (r *disagreementRecorder) Record(d *gokismet.Disagreement) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recs = append(r.recs, d)
+}
+
+// TestShadowChecker verifies that ShadowCheckers return the
+// primary result and record disagreements.
+func TestShadowChecker(t *testing.T) {
+
+	errDown := errors.New("service unavailable")
+
+	tests := []struct {
+		Primary      *gokismet.Fake
+		Candidate    *gokismet.Fake
+		Status       gokismet.SpamStatus
+		Err          error
+		Disagreement bool
+	}{
+		{
+			Primary:   fakeWith(gokismet.StatusHam, nil),
+			Candidate: fakeWith(gokismet.StatusHam, nil),
+			Status:    gokismet.StatusHam,
+		},
+		{
+			Primary:      fakeWith(gokismet.StatusHam, nil),
+			Candidate:    fakeWith(gokismet.StatusDefiniteSpam, nil),
+			Status:       gokismet.StatusHam,
+			Disagreement: true,
+		},
+		{
+			// Candidate errors are not disagreements.
+			Primary:   fakeWith(gokismet.StatusProbableSpam, nil),
+			Candidate: fakeWith(gokismet.StatusUnknown, errDown),
+			Status:    gokismet.StatusProbableSpam,
+		},
+		{
+			// Primary errors are returned and not compared.
+			Primary:   fakeWith(gokismet.StatusUnknown, errDown),
+			Candidate: fakeWith(gokismet.StatusHam, nil),
+			Status:    gokismet.StatusUnknown,
+			Err:       errDown,
+		},
+	}
+
+	values := map[string]string{"comment_content": "Hello"}
+
+	for i, test := range tests {
+
+		sink := &disagreementRecorder{}
+
+		sc := &gokismet.ShadowChecker{
+			Primary:   test.Primary,
+			Candidate: test.Candidate,
+			Sink:      sink,
+		}
+
+		status, err := sc.Check(values)
+		sc.Wait()
+
+		if status != test.Status {
+			t.Errorf("Test %d: Expected Spam Status %q, got %q", i+1,
+				statusToString(test.Status), statusToString(status))
+		}
+
+		if err != test.Err {
+			t.Errorf("Test %d: Expected error %v, got %v", i+1, test.Err, err)
+		}
+
+		if test.Candidate.Checks() != 1 {
+			t.Errorf("Test %d: Expected 1 candidate check, got %d", i+1, test.Candidate.Checks())
+		}
+
+		if !test.Disagreement {
+			if len(sink.recs) != 0 {
+				t.Errorf("Test %d: Expected no disagreements, got %d", i+1, len(sink.recs))
+			}
+			continue
+		}
+
+		if len(sink.recs) != 1 {
+			t.Fatalf("Test %d: Expected 1 disagreement, got %d", i+1, len(sink.recs))
+		}
+
+		d := sink.recs[0]
+
+		if d.Hash != gokismet.HashValues(values, nil) {
+			t.Errorf("Test %d: Expected hash %q, got %q", i+1, gokismet.HashValues(values, nil), d.Hash)
+		}
+
+		if d.Primary != gokismet.StatusHam || d.Candidate != gokismet.StatusDefiniteSpam {
+			t.Errorf("Test %d: Expected ham/definite-spam, got %s/%s", i+1, d.Primary, d.Candidate)
+		}
+	}
+}
+
+// TestShadowChecker_Budget verifies that slow candidate
+// checks don't delay the primary result and are abandoned
+// when their budget runs out.
+func TestShadowChecker_Budget(t *testing.T) {
+
+	slow := &slowChecker{Fake: fakeWith(gokismet.StatusDefiniteSpam, nil)}
+	sink := &disagreementRecorder{}
+
+	sc := &gokismet.ShadowChecker{
+		Primary:   fakeWith(gokismet.StatusHam, nil),
+		Candidate: slow,
+		Sink:      sink,
+		Budget:    20 * time.Millisecond,
+	}
+
+	start := time.Now()
+
+	if _, err := sc.Check(map[string]string{"comment_content": "Hello"}); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the primary result without waiting for the candidate, took %s", elapsed)
+	}
+
+	sc.Wait()
+
+	if err := slow.err(); err != context.DeadlineExceeded {
+		t.Errorf("Expected candidate error %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	if len(sink.recs) != 0 {
+		t.Errorf("Expected no disagreements, got %d", len(sink.recs))
+	}
+}
+
+// TestShadowChecker_MaxInFlight verifies that ShadowCheckers
+// skip candidate checks when too many are running.
+func TestShadowChecker_MaxInFlight(t *testing.T) {
+
+	slow := &slowChecker{Fake: fakeWith(gokismet.StatusHam, nil)}
+
+	sc := &gokismet.ShadowChecker{
+		Primary:     fakeWith(gokismet.StatusHam, nil),
+		Candidate:   slow,
+		Budget:      50 * time.Millisecond,
+		MaxInFlight: 1,
+	}
+
+	for i := 0; i < 3; i++ {
+		sc.Check(map[string]string{"comment_content": "Hello"})
+	}
+
+	sc.Wait()
+
+	if sc.Skipped() != 2 {
+		t.Errorf("Expected 2 skipped checks, got %d", sc.Skipped())
+	}
+}
+
+// TestShadowChecker_Values verifies that candidate checks are
+// unaffected by changes to the caller's values after the
+// primary check returns.
+func TestShadowChecker_Values(t *testing.T) {
+
+	gated := &gatedChecker{
+		Fake: fakeWith(gokismet.StatusDefiniteSpam, nil),
+		gate: make(chan struct{}),
+	}
+	sink := &disagreementRecorder{}
+
+	sc := &gokismet.ShadowChecker{
+		Primary:   fakeWith(gokismet.StatusHam, nil),
+		Candidate: gated,
+		Sink:      sink,
+	}
+
+	values := map[string]string{"comment_content": "Hello"}
+	hash := gokismet.HashValues(values, nil)
+
+	if _, err := sc.Check(values); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	// Reuse the map while the candidate check is running.
+	values["comment_content"] = "Goodbye"
+	close(gated.gate)
+
+	sc.Wait()
+
+	if len(sink.recs) != 1 {
+		t.Fatalf("Expected 1 disagreement, got %d", len(sink.recs))
+	}
+
+	if sink.recs[0].Hash != hash {
+		t.Errorf("Expected hash %q, got %q", hash, sink.recs[0].Hash)
+	}
+}
+
+// gatedChecker is a SpamChecker whose checks wait for its
+// gate to close.
+type gatedChecker struct {
+	*gokismet.Fake
+	gate chan struct{}
+}
+
+func (gc *gatedChecker) CheckContext(ctx context.Context, values map[string]string) (*gokismet.CheckResult, error) {
+	<-gc.gate
+	return gc.Fake.CheckContext(ctx, values)
+}
+
+// slowChecker is a SpamChecker whose checks block until their
+// Context is done.
+type slowChecker struct {
+	*gokismet.Fake
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+func (sc *slowChecker) CheckContext(ctx context.Context, values map[string]string) (*gokismet.CheckResult, error) {
+
+	<-ctx.Done()
+
+	sc.mu.Lock()
+	sc.lastErr = ctx.Err()
+	sc.mu.Unlock()
+
+	return nil, ctx.Err()
+}
+
+func (sc *slowChecker) err() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.lastErr
+}
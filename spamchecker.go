@@ -0,0 +1,29 @@
+package gokismet
+
+import "context"
+
+// A SpamChecker checks content for spam and accepts reports
+// of incorrect results. It is satisfied by Checker and Fake,
+// allowing applications to substitute fakes, decorators or
+// alternative providers for a Checker.
+type SpamChecker interface {
+	// Check checks a set of key-value pairs for spam.
+	Check(values map[string]string) (SpamStatus, error)
+	// CheckContext is like Check except that it takes a
+	// Context and returns a detailed CheckResult.
+	CheckContext(ctx context.Context, values map[string]string) (*CheckResult, error)
+	// ReportHam reports legitimate content incorrectly
+	// flagged as spam.
+	ReportHam(values map[string]string) error
+	// ReportHamContext is like ReportHam except that it
+	// takes a Context.
+	ReportHamContext(ctx context.Context, values map[string]string) error
+	// ReportSpam reports spam that went undetected.
+	ReportSpam(values map[string]string) error
+	// ReportSpamContext is like ReportSpam except that it
+	// takes a Context.
+	ReportSpamContext(ctx context.Context, values map[string]string) error
+}
+
+// Checker implements SpamChecker.
+var _ SpamChecker = (*Checker)(nil)
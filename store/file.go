@@ -0,0 +1,339 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Operations recorded in a File's log.
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// An entry is a single line in a File's log.
+type entry struct {
+	Op         string `json:"op"`
+	Collection string `json:"c"`
+	Key        string `json:"k"`
+	Value      []byte `json:"v,omitempty"`
+}
+
+// File is a Store backed by an append-only JSON Lines file.
+// Every change is appended to the file and synced to disk
+// before the method returns, and the complete data set is
+// kept in memory for reads.
+//
+// A write interrupted by a crash leaves an incomplete final
+// line, which is discarded when the file is next opened.
+// A write that fails part way is rolled back so that the log
+// stays readable.
+//
+// As obsolete entries accumulate, the file is compacted by
+// rewriting the live records to a temporary file and renaming
+// it over the original. Automatic compaction happens after a
+// write has been synced, so its failure doesn't fail the
+// write. Instead, it is retried later and reported by
+// CompactErr.
+type File struct {
+	path string
+
+	mu          sync.Mutex
+	mem         *Memory
+	f           *os.File
+	entries     int
+	nextCompact int
+	compactErr  error
+}
+
+// compactMin is the minimum number of log entries before a
+// File is compacted automatically. Compaction also requires
+// the log to be more than twice the size of the data set.
+const compactMin = 1000
+
+// OpenFile opens the File store at the given path, creating
+// it if necessary.
+func OpenFile(path string) (*File, error) {
+
+	fs := &File{
+		path: path,
+		mem:  NewMemory(),
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fs.load(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	fs.f = f
+
+	return fs, nil
+}
+
+// load replays the log in f. A torn final line is truncated
+// so that subsequent writes start on a clean line.
+func (fs *File) load(f *os.File) error {
+
+	r := bufio.NewReader(f)
+
+	var offset int64
+
+	for line := 1; ; line++ {
+
+		b, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// Anything after the last newline is the
+			// remains of an interrupted write.
+			if len(b) > 0 {
+				if err := f.Truncate(offset); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var e entry
+		if err := json.Unmarshal(bytes.TrimSpace(b), &e); err != nil {
+			return fmt.Errorf("store: %s line %d: %w", fs.path, line, err)
+		}
+
+		switch e.Op {
+		case opPut:
+			fs.mem.put(e.Collection, e.Key, e.Value)
+		case opDelete:
+			fs.mem.delete(e.Collection, e.Key)
+		default:
+			return fmt.Errorf("store: %s line %d: unknown operation %q", fs.path, line, e.Op)
+		}
+
+		offset += int64(len(b))
+		fs.entries++
+	}
+
+	_, err := f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// Get returns the value stored under a key in a collection.
+func (fs *File) Get(ctx context.Context, collection, key string) ([]byte, error) {
+	return fs.mem.Get(ctx, collection, key)
+}
+
+// List returns the records in a collection, sorted by key.
+func (fs *File) List(ctx context.Context, collection string) ([]Record, error) {
+	return fs.mem.List(ctx, collection)
+}
+
+// Put stores a value under a key in a collection.
+func (fs *File) Put(ctx context.Context, collection, key string, value []byte) error {
+	return fs.append(&entry{
+		Op:         opPut,
+		Collection: collection,
+		Key:        key,
+		Value:      clone(value),
+	})
+}
+
+// Delete removes a key from a collection.
+func (fs *File) Delete(ctx context.Context, collection, key string) error {
+	return fs.append(&entry{
+		Op:         opDelete,
+		Collection: collection,
+		Key:        key,
+	})
+}
+
+// append writes an entry to the log and applies it to the
+// in-memory data set.
+func (fs *File) append(e *entry) error {
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.f == nil {
+		return ErrClosed
+	}
+
+	offset, err := fs.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if err := fs.write(b); err != nil {
+		// Remove any partial entry so that later writes
+		// don't follow a corrupt line.
+		if fs.f.Truncate(offset) == nil {
+			fs.f.Seek(offset, io.SeekStart)
+		}
+		return err
+	}
+
+	fs.mem.mu.Lock()
+	switch e.Op {
+	case opPut:
+		fs.mem.put(e.Collection, e.Key, e.Value)
+	case opDelete:
+		fs.mem.delete(e.Collection, e.Key)
+	}
+	live := fs.mem.len()
+	fs.mem.mu.Unlock()
+
+	fs.entries++
+
+	if fs.entries >= compactMin && fs.entries >= fs.nextCompact && fs.entries > 2*live {
+		fs.compactErr = fs.compact()
+		fs.nextCompact = 0
+		if fs.compactErr != nil {
+			// Try again after another batch of writes.
+			fs.nextCompact = fs.entries + compactMin
+		}
+	}
+
+	return nil
+}
+
+// write writes b to the log and syncs it to disk.
+func (fs *File) write(b []byte) error {
+
+	if _, err := fs.f.Write(b); err != nil {
+		return err
+	}
+
+	return fs.f.Sync()
+}
+
+// CompactErr returns the error from the last automatic
+// compaction, or nil if it succeeded.
+func (fs *File) CompactErr() error {
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.compactErr
+}
+
+// Compact rewrites the log so that it contains only the live
+// records.
+func (fs *File) Compact() error {
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.f == nil {
+		return ErrClosed
+	}
+
+	return fs.compact()
+}
+
+// compact does the work of Compact. The caller must hold
+// fs.mu.
+func (fs *File) compact() error {
+
+	tmp, err := os.CreateTemp(filepath.Dir(fs.path), filepath.Base(fs.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+
+	// Clean up if anything goes wrong before the rename.
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+
+	fs.mem.mu.RLock()
+	entries := 0
+	for c := range fs.mem.collections {
+		for _, r := range fs.mem.list(c) {
+			if err := enc.Encode(&entry{opPut, c, r.Key, r.Value}); err != nil {
+				fs.mem.mu.RUnlock()
+				tmp.Close()
+				return err
+			}
+			entries++
+		}
+	}
+	fs.mem.mu.RUnlock()
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	// Position the temporary file for appending while the
+	// old log is still in place. Nothing may fail after the
+	// rename, or fs.f would be left writing to a file that
+	// is no longer the log.
+	if _, err := tmp.Seek(0, io.SeekEnd); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), fs.path); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	syncDir(filepath.Dir(fs.path))
+
+	// The temporary file is now the log. Keep it open for
+	// appending and close the old one.
+	fs.f.Close()
+	fs.f = tmp
+	fs.entries = entries
+
+	return nil
+}
+
+// Close closes the File. Subsequent calls return ErrClosed.
+func (fs *File) Close() error {
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.f == nil {
+		return ErrClosed
+	}
+
+	err := fs.f.Close()
+	fs.f = nil
+	fs.mem.Close()
+
+	return err
+}
+
+// syncDir flushes a directory entry to disk so that a rename
+// survives a crash. Not all platforms support this, so errors
+// are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,181 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deepilla/gokismet/store"
+)
+
+// TestFile_Reopen verifies that File stores persist their
+// records and recover from interrupted writes.
+func TestFile_Reopen(t *testing.T) {
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.jsonl")
+
+	s, err := store.OpenFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	s.Put(ctx, "items", "a", []byte("one"))
+	s.Put(ctx, "items", "b", []byte("two"))
+	s.Delete(ctx, "items", "a")
+	s.Close()
+
+	// Simulate a crash in the middle of a write.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	f.WriteString(`{"op":"put","c":"items","k":"c"`)
+	f.Close()
+
+	s, err = store.OpenFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if _, err := s.Get(ctx, "items", "a"); err != store.ErrNotFound {
+		t.Errorf("Expected error %v, got %v", store.ErrNotFound, err)
+	}
+
+	if _, err := s.Get(ctx, "items", "c"); err != store.ErrNotFound {
+		t.Errorf("Expected torn write to be discarded, got %v", err)
+	}
+
+	if v, err := s.Get(ctx, "items", "b"); err != nil || string(v) != "two" {
+		t.Errorf("Expected %q, got %q, %v", "two", v, err)
+	}
+
+	// Writes after recovery start on a clean line.
+	s.Put(ctx, "items", "d", []byte("four"))
+	s.Close()
+
+	s, err = store.OpenFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	defer s.Close()
+
+	if v, err := s.Get(ctx, "items", "d"); err != nil || string(v) != "four" {
+		t.Errorf("Expected %q, got %q, %v", "four", v, err)
+	}
+}
+
+// TestFile_Corrupt verifies that corrupt entries before the
+// end of the log are reported.
+func TestFile_Corrupt(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "data.jsonl")
+	os.WriteFile(path, []byte("not json\n{\"op\":\"put\",\"c\":\"items\",\"k\":\"a\"}\n"), 0o600)
+
+	if _, err := store.OpenFile(path); err == nil {
+		t.Error("Expected an error, got nil")
+	}
+}
+
+// TestFile_Compact verifies that File stores discard obsolete
+// entries when compacted.
+func TestFile_Compact(t *testing.T) {
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.jsonl")
+
+	s, err := store.OpenFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		s.Put(ctx, "items", "a", []byte(fmt.Sprint(i)))
+	}
+	s.Put(ctx, "items", "b", []byte("two"))
+
+	if err := s.Compact(); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	b, _ := os.ReadFile(path)
+	if n := bytes.Count(b, []byte("\n")); n != 2 {
+		t.Errorf("Expected 2 entries after compaction, got %d", n)
+	}
+
+	// The store is still writable after compaction.
+	s.Put(ctx, "items", "c", []byte("three"))
+	s.Close()
+
+	s, err = store.OpenFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	defer s.Close()
+
+	records, _ := s.List(ctx, "items")
+	if len(records) != 3 || string(records[0].Value) != "9" {
+		t.Errorf("Expected 3 records starting with a=9, got %v", records)
+	}
+}
+
+// TestFile_AutoCompact verifies that File stores compact
+// themselves as obsolete entries accumulate.
+func TestFile_AutoCompact(t *testing.T) {
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.jsonl")
+
+	s, err := store.OpenFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 1500; i++ {
+		s.Put(ctx, "items", "a", []byte(fmt.Sprint(i)))
+	}
+
+	b, _ := os.ReadFile(path)
+	if n := bytes.Count(b, []byte("\n")); n >= 1000 {
+		t.Errorf("Expected fewer than 1000 entries, got %d", n)
+	}
+}
+
+// TestFile_CompactError verifies that failed automatic
+// compactions don't fail the writes that trigger them.
+func TestFile_CompactError(t *testing.T) {
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := store.OpenFile(filepath.Join(dir, "data.jsonl"))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	defer s.Close()
+
+	// The open log can still be written, but compaction
+	// can't create its temporary file.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	for i := 0; i < 1500; i++ {
+		if err := s.Put(ctx, "items", "a", []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Put %d: Unexpected error %s", i+1, err)
+		}
+	}
+
+	if s.CompactErr() == nil {
+		t.Error("Expected a compaction error, got nil")
+	}
+
+	b, err := s.Get(ctx, "items", "a")
+	if err != nil || string(b) != "1499" {
+		t.Errorf("Expected value %q, got %q, %v", "1499", b, err)
+	}
+}
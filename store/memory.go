@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Memory is a Store that keeps records in memory. Its zero
+// value is not usable; call NewMemory instead.
+type Memory struct {
+	mu          sync.RWMutex
+	collections map[string]map[string][]byte
+	closed      bool
+}
+
+// NewMemory returns an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		collections: make(map[string]map[string][]byte),
+	}
+}
+
+// Get returns the value stored under a key in a collection.
+func (m *Memory) Get(ctx context.Context, collection, key string) ([]byte, error) {
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	v, ok := m.collections[collection][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return clone(v), nil
+}
+
+// Put stores a value under a key in a collection.
+func (m *Memory) Put(ctx context.Context, collection, key string, value []byte) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	m.put(collection, key, clone(value))
+	return nil
+}
+
+// Delete removes a key from a collection.
+func (m *Memory) Delete(ctx context.Context, collection, key string) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	m.delete(collection, key)
+	return nil
+}
+
+// List returns the records in a collection, sorted by key.
+func (m *Memory) List(ctx context.Context, collection string) ([]Record, error) {
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	return m.list(collection), nil
+}
+
+// Close closes the store. Subsequent calls return ErrClosed.
+func (m *Memory) Close() error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	m.collections = nil
+	return nil
+}
+
+// put stores a value without locking or copying.
+func (m *Memory) put(collection, key string, value []byte) {
+
+	c, ok := m.collections[collection]
+	if !ok {
+		c = make(map[string][]byte)
+		m.collections[collection] = c
+	}
+
+	c[key] = value
+}
+
+// delete removes a key without locking.
+func (m *Memory) delete(collection, key string) {
+
+	c := m.collections[collection]
+	delete(c, key)
+
+	if len(c) == 0 {
+		delete(m.collections, collection)
+	}
+}
+
+// list returns a sorted copy of a collection without locking.
+func (m *Memory) list(collection string) []Record {
+
+	c := m.collections[collection]
+
+	records := make([]Record, 0, len(c))
+	for k, v := range c {
+		records = append(records, Record{k, clone(v)})
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Key < records[j].Key
+	})
+
+	return records
+}
+
+// len returns the total number of records without locking.
+func (m *Memory) len() int {
+	n := 0
+	for _, c := range m.collections {
+		n += len(c)
+	}
+	return n
+}
+
+func clone(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
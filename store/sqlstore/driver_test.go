@@ -0,0 +1,236 @@
+package sqlstore_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fakeDB is the state of an in-process database served by
+// fakeDriver. It understands just enough SQL to support
+// sqlstore, and it checks that every statement uses the
+// expected placeholder style.
+type fakeDB struct {
+	// Should statements use $1-style placeholders?
+	numbered bool
+
+	mu         sync.Mutex
+	tables     map[string]bool
+	versions   map[int64]bool
+	records    map[[2]string][]byte
+	statements []string
+}
+
+func newFakeDB(numbered bool) *fakeDB {
+	return &fakeDB{
+		numbered: numbered,
+		tables:   make(map[string]bool),
+		versions: make(map[int64]bool),
+		records:  make(map[[2]string][]byte),
+	}
+}
+
+// open returns a *sql.DB connected to the fakeDB.
+func (db *fakeDB) open() *sql.DB {
+	return sql.OpenDB(&fakeConnector{db})
+}
+
+// executed returns the statements executed so far.
+func (db *fakeDB) executed() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]string(nil), db.statements...)
+}
+
+type fakeConnector struct {
+	db *fakeDB
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{c.db}, nil
+}
+
+func (c *fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, fmt.Errorf("fakeDriver: use a connector")
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{c.db, query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+// fakeTx commits every statement as it runs. That's good
+// enough for testing migrations, which never roll back.
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+var (
+	reSpace      = regexp.MustCompile(`\s+`)
+	reNumbered   = regexp.MustCompile(`\$\d+`)
+	reCreateName = regexp.MustCompile(`^CREATE TABLE IF NOT EXISTS (\w+)`)
+)
+
+// check normalises a query and verifies its placeholders.
+func (s *fakeStmt) check(args []driver.Value) (string, error) {
+
+	q := strings.TrimSpace(reSpace.ReplaceAllString(s.query, " "))
+
+	s.db.statements = append(s.db.statements, q)
+
+	questions := strings.Count(q, "?")
+	numbered := len(reNumbered.FindAllString(q, -1))
+
+	switch {
+	case s.db.numbered && (questions > 0 || numbered != len(args)):
+		return "", fmt.Errorf("fakeDriver: expected %d numbered placeholders in %q", len(args), q)
+	case !s.db.numbered && (numbered > 0 || questions != len(args)):
+		return "", fmt.Errorf("fakeDriver: expected %d question mark placeholders in %q", len(args), q)
+	}
+
+	return q, nil
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	q, err := s.check(args)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasPrefix(q, "CREATE TABLE"):
+		m := reCreateName.FindStringSubmatch(q)
+		if m == nil {
+			return nil, fmt.Errorf("fakeDriver: unsupported statement %q", q)
+		}
+		s.db.tables[m[1]] = true
+
+	case strings.HasPrefix(q, "INSERT INTO gokismet_schema_migrations"):
+		s.db.versions[args[0].(int64)] = true
+
+	case strings.HasPrefix(q, "INSERT INTO gokismet_records"):
+		if !s.db.tables["gokismet_records"] {
+			return nil, fmt.Errorf("fakeDriver: no such table gokismet_records")
+		}
+		s.db.records[[2]string{args[0].(string), args[1].(string)}] = args[2].([]byte)
+
+	case strings.HasPrefix(q, "DELETE FROM gokismet_records"):
+		delete(s.db.records, [2]string{args[0].(string), args[1].(string)})
+
+	default:
+		return nil, fmt.Errorf("fakeDriver: unsupported statement %q", q)
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	q, err := s.check(args)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := &fakeRows{}
+
+	switch {
+	case strings.HasPrefix(q, "SELECT version FROM gokismet_schema_migrations"):
+		rows.columns = []string{"version"}
+		for v := range s.db.versions {
+			rows.values = append(rows.values, []driver.Value{v})
+		}
+
+	case strings.HasPrefix(q, "SELECT value FROM gokismet_records"):
+		rows.columns = []string{"value"}
+		if v, ok := s.db.records[[2]string{args[0].(string), args[1].(string)}]; ok {
+			rows.values = append(rows.values, []driver.Value{v})
+		}
+
+	case strings.HasPrefix(q, "SELECT record_key, value FROM gokismet_records"):
+		rows.columns = []string{"record_key", "value"}
+		var keys []string
+		for k := range s.db.records {
+			if k[0] == args[0].(string) {
+				keys = append(keys, k[1])
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			rows.values = append(rows.values, []driver.Value{k, s.db.records[[2]string{args[0].(string), k}]})
+		}
+
+	default:
+		return nil, fmt.Errorf("fakeDriver: unsupported query %q", q)
+	}
+
+	return rows, nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+
+	return nil
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS gokismet_records (
+	collection VARCHAR(191) NOT NULL,
+	record_key VARCHAR(191) NOT NULL,
+	value LONGBLOB,
+	PRIMARY KEY (collection, record_key)
+);
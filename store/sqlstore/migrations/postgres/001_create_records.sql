@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS gokismet_records (
+	collection TEXT NOT NULL,
+	record_key TEXT NOT NULL,
+	value BYTEA,
+	PRIMARY KEY (collection, record_key)
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS gokismet_records (
+	collection TEXT NOT NULL,
+	record_key TEXT NOT NULL,
+	value BLOB,
+	PRIMARY KEY (collection, record_key)
+);
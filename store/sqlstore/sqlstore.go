@@ -0,0 +1,344 @@
+/*
+Package sqlstore implements store.Store on top of a relational
+database via database/sql.
+
+The package does not import any database drivers. Open a
+*sql.DB with the driver of your choice and pass it to New
+along with the matching Dialect:
+
+	db, err := sql.Open("postgres", dsn)
+	...
+	s := sqlstore.New(db, sqlstore.Postgres)
+	if err := s.Migrate(ctx); err != nil {
+		...
+	}
+
+	q := moderation.NewQueue(checker, s)
+
+The schema is created and upgraded by Migrate, using SQL
+migrations embedded in the package.
+
+Store.List has no paging, so listing a collection reads
+every row in it. Packages that list collections on demand,
+such as moderation, are best kept to a few thousand records
+per collection.
+
+There is no separate schema for audit data. Moderation
+decisions are recorded on each moderation.Item (who decided
+it, when, and whether a report was sent) and are stored like
+any other record until they are removed with Queue.Purge.
+Applications that need a permanent audit log should copy
+decisions into their own tables.
+*/
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/deepilla/gokismet/store"
+)
+
+// A Dialect describes the SQL variations of a database.
+type Dialect struct {
+	// The name of the Dialect, which is also the name of its
+	// migrations directory.
+	Name string
+	// Does the database use numbered placeholders ($1, $2...)
+	// rather than question marks?
+	numbered bool
+	// The statement that inserts or replaces a record.
+	upsert string
+}
+
+// Supported dialects.
+var (
+	SQLite = &Dialect{
+		Name: "sqlite",
+		upsert: "INSERT INTO gokismet_records (collection, record_key, value) VALUES (?, ?, ?) " +
+			"ON CONFLICT (collection, record_key) DO UPDATE SET value = excluded.value",
+	}
+	Postgres = &Dialect{
+		Name:     "postgres",
+		numbered: true,
+		upsert: "INSERT INTO gokismet_records (collection, record_key, value) VALUES (?, ?, ?) " +
+			"ON CONFLICT (collection, record_key) DO UPDATE SET value = excluded.value",
+	}
+	MySQL = &Dialect{
+		Name: "mysql",
+		upsert: "INSERT INTO gokismet_records (collection, record_key, value) VALUES (?, ?, ?) " +
+			"ON DUPLICATE KEY UPDATE value = VALUES(value)",
+	}
+)
+
+// rebind converts a query written with question mark
+// placeholders to the Dialect's placeholder style.
+func (d *Dialect) rebind(query string) string {
+
+	if !d.numbered {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+//go:embed migrations
+var migrations embed.FS
+
+// A migration is a numbered schema change.
+type migration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+// loadMigrations returns the Dialect's migrations in version
+// order.
+func (d *Dialect) loadMigrations() ([]migration, error) {
+
+	dir := path.Join("migrations", d.Name)
+
+	entries, err := fs.ReadDir(migrations, dir)
+	if err != nil {
+		return nil, fmt.Errorf("sqlstore: no migrations for dialect %q", d.Name)
+	}
+
+	var ms []migration
+
+	for _, e := range entries {
+
+		name := e.Name()
+		if !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("sqlstore: migration %s has no version number", name)
+		}
+
+		b, err := fs.ReadFile(migrations, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		ms = append(ms, migration{
+			version:    version,
+			name:       name,
+			statements: splitStatements(string(b)),
+		})
+	}
+
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].version < ms[j].version
+	})
+
+	return ms, nil
+}
+
+// splitStatements splits a migration file into individual
+// statements, since not all drivers accept several at once.
+func splitStatements(s string) []string {
+
+	var stmts []string
+
+	for _, stmt := range strings.Split(s, ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+
+	return stmts
+}
+
+// Store is a store.Store backed by a SQL database. It is safe
+// for concurrent use.
+type Store struct {
+	db      *sql.DB
+	dialect *Dialect
+}
+
+// Store implements store.Store.
+var _ store.Store = (*Store)(nil)
+
+// New returns a Store that uses the given database. Call
+// Migrate before using the Store to make sure that the schema
+// is up to date.
+func New(db *sql.DB, dialect *Dialect) *Store {
+	return &Store{
+		db:      db,
+		dialect: dialect,
+	}
+}
+
+// Migrate applies any outstanding schema migrations. Each
+// migration runs in its own transaction.
+func (s *Store) Migrate(ctx context.Context) error {
+
+	ms, err := s.dialect.loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.db.ExecContext(ctx,
+		"CREATE TABLE IF NOT EXISTS gokismet_schema_migrations (version INTEGER PRIMARY KEY)"); err != nil {
+		return fmt.Errorf("sqlstore: %w", err)
+	}
+
+	applied, err := s.appliedVersions(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range ms {
+		if applied[m.version] {
+			continue
+		}
+		if err := s.apply(ctx, m); err != nil {
+			return fmt.Errorf("sqlstore: migration %s: %w", m.name, err)
+		}
+	}
+
+	return nil
+}
+
+// appliedVersions returns the versions of the migrations
+// that have already been applied.
+func (s *Store) appliedVersions(ctx context.Context) (map[int]bool, error) {
+
+	rows, err := s.db.QueryContext(ctx, "SELECT version FROM gokismet_schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("sqlstore: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("sqlstore: %w", err)
+		}
+		applied[v] = true
+	}
+
+	return applied, rows.Err()
+}
+
+// apply runs a single migration.
+func (s *Store) apply(ctx context.Context, m migration) error {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(
+		"INSERT INTO gokismet_schema_migrations (version) VALUES (?)"), m.version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get returns the value stored under a key in a collection.
+func (s *Store) Get(ctx context.Context, collection, key string) ([]byte, error) {
+
+	var value []byte
+
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind(
+		"SELECT value FROM gokismet_records WHERE collection = ? AND record_key = ?"),
+		collection, key).Scan(&value)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, store.ErrNotFound
+	case err != nil:
+		return nil, fmt.Errorf("sqlstore: %w", err)
+	}
+
+	return value, nil
+}
+
+// Put stores a value under a key in a collection.
+func (s *Store) Put(ctx context.Context, collection, key string, value []byte) error {
+
+	if _, err := s.db.ExecContext(ctx, s.dialect.rebind(s.dialect.upsert), collection, key, value); err != nil {
+		return fmt.Errorf("sqlstore: %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes a key from a collection.
+func (s *Store) Delete(ctx context.Context, collection, key string) error {
+
+	if _, err := s.db.ExecContext(ctx, s.dialect.rebind(
+		"DELETE FROM gokismet_records WHERE collection = ? AND record_key = ?"),
+		collection, key); err != nil {
+		return fmt.Errorf("sqlstore: %w", err)
+	}
+
+	return nil
+}
+
+// List returns the records in a collection, sorted by key.
+func (s *Store) List(ctx context.Context, collection string) ([]store.Record, error) {
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(
+		"SELECT record_key, value FROM gokismet_records WHERE collection = ? ORDER BY record_key"),
+		collection)
+	if err != nil {
+		return nil, fmt.Errorf("sqlstore: %w", err)
+	}
+	defer rows.Close()
+
+	var records []store.Record
+
+	for rows.Next() {
+		var r store.Record
+		if err := rows.Scan(&r.Key, &r.Value); err != nil {
+			return nil, fmt.Errorf("sqlstore: %w", err)
+		}
+		records = append(records, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlstore: %w", err)
+	}
+
+	return records, nil
+}
+
+// Close does nothing. The caller remains responsible for
+// closing the database.
+func (s *Store) Close() error {
+	return nil
+}
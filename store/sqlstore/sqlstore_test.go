@@ -0,0 +1,120 @@
+package sqlstore_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/deepilla/gokismet/store"
+	"github.com/deepilla/gokismet/store/sqlstore"
+)
+
+// TestStore verifies the Store operations in each Dialect.
+func TestStore(t *testing.T) {
+
+	dialects := []*sqlstore.Dialect{
+		sqlstore.SQLite,
+		sqlstore.Postgres,
+		sqlstore.MySQL,
+	}
+
+	for _, d := range dialects {
+		t.Run(d.Name, func(t *testing.T) {
+
+			ctx := context.Background()
+
+			fake := newFakeDB(d == sqlstore.Postgres)
+			db := fake.open()
+			defer db.Close()
+
+			s := sqlstore.New(db, d)
+
+			if err := s.Migrate(ctx); err != nil {
+				t.Fatalf("Unexpected error %s", err)
+			}
+
+			if _, err := s.Get(ctx, "items", "a"); err != store.ErrNotFound {
+				t.Errorf("Expected error %v, got %v", store.ErrNotFound, err)
+			}
+
+			for _, r := range []struct{ c, k, v string }{
+				{"items", "b", "two"},
+				{"items", "a", "one"},
+				{"items", "c", "three"},
+				{"other", "a", "elsewhere"},
+			} {
+				if err := s.Put(ctx, r.c, r.k, []byte(r.v)); err != nil {
+					t.Fatalf("Unexpected error %s", err)
+				}
+			}
+
+			if err := s.Put(ctx, "items", "b", []byte("TWO")); err != nil {
+				t.Fatalf("Unexpected error %s", err)
+			}
+
+			if err := s.Delete(ctx, "items", "c"); err != nil {
+				t.Fatalf("Unexpected error %s", err)
+			}
+
+			v, err := s.Get(ctx, "items", "b")
+			if err != nil || string(v) != "TWO" {
+				t.Errorf("Expected %q, got %q, %v", "TWO", v, err)
+			}
+
+			records, err := s.List(ctx, "items")
+			if err != nil {
+				t.Fatalf("Unexpected error %s", err)
+			}
+
+			if len(records) != 2 || records[0].Key != "a" || records[1].Key != "b" {
+				t.Errorf("Expected records a and b, got %v", records)
+			}
+
+			// Upserts use the dialect's syntax.
+			for _, q := range fake.executed() {
+				if !strings.HasPrefix(q, "INSERT INTO gokismet_records") {
+					continue
+				}
+				conflict := strings.Contains(q, "ON CONFLICT")
+				if conflict == (d == sqlstore.MySQL) {
+					t.Errorf("Unexpected upsert syntax for %s: %q", d.Name, q)
+				}
+				break
+			}
+		})
+	}
+}
+
+// TestStore_Migrate verifies that migrations are applied
+// only once.
+func TestStore_Migrate(t *testing.T) {
+
+	ctx := context.Background()
+
+	fake := newFakeDB(false)
+	db := fake.open()
+	defer db.Close()
+
+	s := sqlstore.New(db, sqlstore.SQLite)
+
+	for i := 0; i < 2; i++ {
+		if err := s.Migrate(ctx); err != nil {
+			t.Fatalf("Migration %d: Unexpected error %s", i+1, err)
+		}
+	}
+
+	n := 0
+	for _, q := range fake.executed() {
+		if strings.HasPrefix(q, "CREATE TABLE IF NOT EXISTS gokismet_records") {
+			n++
+		}
+	}
+
+	if n != 1 {
+		t.Errorf("Expected the records table to be created once, got %d", n)
+	}
+
+	if err := sqlstore.New(db, &sqlstore.Dialect{Name: "oracle"}).Migrate(ctx); err == nil {
+		t.Error("Expected an error for an unknown dialect, got nil")
+	}
+}
@@ -0,0 +1,49 @@
+/*
+Package store provides simple key-value persistence for
+gokismet's higher-level features, such as moderation queues,
+report outboxes and recheck schedules.
+
+A Store holds records in named collections. Two
+implementations are included: Memory, which keeps records in
+memory, and File, which keeps them in an append-only JSON
+Lines file so that they survive restarts without an external
+database.
+*/
+package store
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned by Get when a key does not exist.
+var ErrNotFound = errors.New("store: not found")
+
+// ErrClosed is returned by the methods of a closed Store.
+var ErrClosed = errors.New("store: closed")
+
+// A Store is a collection-based key-value store.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the value stored under a key in a
+	// collection, or ErrNotFound.
+	Get(ctx context.Context, collection, key string) ([]byte, error)
+	// Put stores a value under a key in a collection,
+	// replacing any existing value.
+	Put(ctx context.Context, collection, key string, value []byte) error
+	// Delete removes a key from a collection. Deleting a
+	// missing key is not an error.
+	Delete(ctx context.Context, collection, key string) error
+	// List returns the records in a collection, sorted by
+	// key. It reads the whole collection, so it is suited
+	// to collections of modest size.
+	List(ctx context.Context, collection string) ([]Record, error)
+	// Close releases the Store's resources.
+	Close() error
+}
+
+// A Record is a key-value pair in a Store.
+type Record struct {
+	Key   string
+	Value []byte
+}
@@ -0,0 +1,102 @@
+package store_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/deepilla/gokismet/store"
+)
+
+// TestStores verifies the basic operations of the Store
+// implementations.
+func TestStores(t *testing.T) {
+
+	stores := map[string]func(t *testing.T) store.Store{
+		"Memory": func(t *testing.T) store.Store {
+			return store.NewMemory()
+		},
+		"File": func(t *testing.T) store.Store {
+			s, err := store.OpenFile(filepath.Join(t.TempDir(), "data.jsonl"))
+			if err != nil {
+				t.Fatalf("Unexpected error %s", err)
+			}
+			return s
+		},
+	}
+
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			testStore(t, open(t))
+		})
+	}
+}
+
+func testStore(t *testing.T, s store.Store) {
+
+	ctx := context.Background()
+
+	if _, err := s.Get(ctx, "items", "a"); err != store.ErrNotFound {
+		t.Errorf("Expected error %v, got %v", store.ErrNotFound, err)
+	}
+
+	for _, kv := range []struct{ C, K, V string }{
+		{"items", "b", "two"},
+		{"items", "a", "one"},
+		{"items", "c", "three"},
+		{"other", "a", "elsewhere"},
+		{"items", "b", "TWO"},
+	} {
+		if err := s.Put(ctx, kv.C, kv.K, []byte(kv.V)); err != nil {
+			t.Fatalf("Unexpected error %s", err)
+		}
+	}
+
+	if err := s.Delete(ctx, "items", "c"); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	// Deleting a missing key is not an error.
+	if err := s.Delete(ctx, "items", "missing"); err != nil {
+		t.Errorf("Unexpected error %s", err)
+	}
+
+	v, err := s.Get(ctx, "items", "b")
+	if err != nil || string(v) != "TWO" {
+		t.Errorf("Expected %q, got %q, %v", "TWO", v, err)
+	}
+
+	records, err := s.List(ctx, "items")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	exp := []store.Record{
+		{Key: "a", Value: []byte("one")},
+		{Key: "b", Value: []byte("TWO")},
+	}
+
+	if len(records) != len(exp) {
+		t.Fatalf("Expected %d records, got %d", len(exp), len(records))
+	}
+
+	for i, r := range records {
+		if r.Key != exp[i].Key || string(r.Value) != string(exp[i].Value) {
+			t.Errorf("Record %d: Expected %s=%s, got %s=%s", i+1, exp[i].Key, exp[i].Value, r.Key, r.Value)
+		}
+	}
+
+	// Returned values are copies.
+	v[0] = 'X'
+	if v, _ := s.Get(ctx, "items", "b"); string(v) != "TWO" {
+		t.Errorf("Expected stored value to be unchanged, got %q", v)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Errorf("Unexpected error %s", err)
+	}
+
+	if _, err := s.Get(ctx, "items", "a"); err != store.ErrClosed {
+		t.Errorf("Expected error %v, got %v", store.ErrClosed, err)
+	}
+}
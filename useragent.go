@@ -0,0 +1,113 @@
+package gokismet
+
+import (
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// modulePath is the import path of the gokismet module.
+const modulePath = "github.com/deepilla/gokismet"
+
+// WithApplication returns an Option that identifies your
+// application to Akismet. The Checker's User-Agent header
+// takes the form recommended by Akismet, with the application
+// first and gokismet second, e.g. "YourApp/1.0 | Gokismet/3.0".
+// If version is empty, only the application name is used.
+func WithApplication(name, version string) Option {
+	return func(ch *Checker) {
+
+		app := name
+		if version != "" {
+			app += "/" + version
+		}
+
+		ch.userAgent = app + " | " + libraryUserAgent()
+	}
+}
+
+var (
+	libraryVersionOnce sync.Once
+	libraryVersion     string
+)
+
+// libraryUserAgent returns gokismet's own User-Agent string.
+// The version number is taken from the build info of the
+// running binary. If the binary doesn't include gokismet as a
+// versioned dependency (e.g. during development), the version
+// from the UserAgent constant is used instead.
+func libraryUserAgent() string {
+
+	libraryVersionOnce.Do(func() {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			libraryVersion = versionFromBuildInfo(info)
+		}
+	})
+
+	if libraryVersion == "" {
+		return UserAgent
+	}
+
+	return "Gokismet/" + libraryVersion
+}
+
+// versionFromBuildInfo returns gokismet's module version as
+// recorded in a binary's build info, e.g. "3.1.0", or an
+// empty string if no version is available.
+func versionFromBuildInfo(info *debug.BuildInfo) string {
+
+	modules := append([]*debug.Module{&info.Main}, info.Deps...)
+
+	for _, m := range modules {
+
+		if !isModulePath(m.Path) {
+			continue
+		}
+
+		// A replaced gokismet, e.g. a fork, is identified by
+		// its original path but versioned by its replacement.
+		version := m.Version
+		if m.Replace != nil {
+			version = m.Replace.Version
+		}
+
+		if version == "" || version == "(devel)" {
+			continue
+		}
+
+		return formatModuleVersion(version)
+	}
+
+	return ""
+}
+
+// isModulePath reports whether path is the path of the
+// gokismet module or one of its major versions, e.g.
+// "github.com/deepilla/gokismet/v4".
+func isModulePath(path string) bool {
+
+	if path == modulePath {
+		return true
+	}
+
+	major, ok := strings.CutPrefix(path, modulePath+"/v")
+	if !ok || major == "" {
+		return false
+	}
+
+	for _, r := range major {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// formatModuleVersion converts a module version, e.g.
+// "v3.1.0+incompatible", into a plain version number.
+func formatModuleVersion(v string) string {
+	v = strings.TrimPrefix(v, "v")
+	v = strings.TrimSuffix(v, "+incompatible")
+	return v
+}
@@ -0,0 +1,131 @@
+package gokismet_test
+
+import (
+	"runtime/debug"
+	"testing"
+
+	"github.com/deepilla/gokismet"
+)
+
+// TestWithApplication verifies that WithApplication produces
+// User-Agent headers in Akismet's recommended format.
+func TestWithApplication(t *testing.T) {
+
+	tests := []struct {
+		Name      string
+		Version   string
+		UserAgent string
+	}{
+		{
+			Name:      "YourApp",
+			Version:   "1.0",
+			UserAgent: "YourApp/1.0 | Gokismet/3.0",
+		},
+		{
+			Name:      "YourApp",
+			UserAgent: "YourApp | Gokismet/3.0",
+		},
+	}
+
+	for i, test := range tests {
+
+		client := &RequestStore{}
+		ch := gokismet.NewCheckerClient(TestAPIKey, TestSite, client,
+			gokismet.WithApplication(test.Name, test.Version))
+
+		ch.Verify()
+
+		if len(client.Requests) != 1 {
+			t.Fatalf("Test %d: Expected 1 request, got %d", i+1, len(client.Requests))
+		}
+
+		if got := client.Requests[0].HeaderItems["User-Agent"]; got != test.UserAgent {
+			t.Errorf("Test %d: Expected User-Agent %q, got %q", i+1, test.UserAgent, got)
+		}
+	}
+}
+
+// TestVersionFromBuildInfo verifies that gokismet's version
+// is found in a binary's build info.
+func TestVersionFromBuildInfo(t *testing.T) {
+
+	tests := []struct {
+		Main    debug.Module
+		Deps    []*debug.Module
+		Version string
+	}{
+		{
+			// gokismet as a dependency.
+			Main: debug.Module{Path: "example.com/app", Version: "(devel)"},
+			Deps: []*debug.Module{
+				{Path: "example.com/other", Version: "v1.2.3"},
+				{Path: "github.com/deepilla/gokismet", Version: "v3.1.0"},
+			},
+			Version: "3.1.0",
+		},
+		{
+			// gokismet as the main module.
+			Main:    debug.Module{Path: "github.com/deepilla/gokismet", Version: "v3.1.0"},
+			Version: "3.1.0",
+		},
+		{
+			// Development builds have no version.
+			Main: debug.Module{Path: "github.com/deepilla/gokismet", Version: "(devel)"},
+		},
+		{
+			// Major version suffixes.
+			Deps: []*debug.Module{
+				{Path: "github.com/deepilla/gokismet/v4", Version: "v4.0.1"},
+			},
+			Version: "4.0.1",
+		},
+		{
+			// Pre-module major versions.
+			Deps: []*debug.Module{
+				{Path: "github.com/deepilla/gokismet", Version: "v3.0.0+incompatible"},
+			},
+			Version: "3.0.0",
+		},
+		{
+			// Replaced modules are versioned by their
+			// replacement.
+			Deps: []*debug.Module{
+				{
+					Path:    "github.com/deepilla/gokismet",
+					Version: "v3.1.0",
+					Replace: &debug.Module{Path: "github.com/someone/gokismet", Version: "v3.1.1-fork"},
+				},
+			},
+			Version: "3.1.1-fork",
+		},
+		{
+			// Local replacements have no version.
+			Deps: []*debug.Module{
+				{
+					Path:    "github.com/deepilla/gokismet",
+					Version: "v3.1.0",
+					Replace: &debug.Module{Path: "../gokismet"},
+				},
+			},
+		},
+		{
+			// Other modules under the same path are ignored.
+			Deps: []*debug.Module{
+				{Path: "github.com/deepilla/gokismet/vendor", Version: "v1.0.0"},
+				{Path: "github.com/deepilla/gokismetx", Version: "v1.0.0"},
+			},
+		},
+	}
+
+	for i, test := range tests {
+
+		info := &debug.BuildInfo{
+			Main: test.Main,
+			Deps: test.Deps,
+		}
+
+		if got := gokismet.VersionFromBuildInfo(info); got != test.Version {
+			t.Errorf("Test %d: Expected version %q, got %q", i+1, test.Version, got)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+/*
+Package velocity detects bursts of submissions that are
+suspicious in aggregate, such as floods of comments from a
+single IP address, or the same text posted from many.
+
+A Tracker counts submissions in sliding windows keyed by IP
+address, author email and a fingerprint of the content, and
+flags content that exceeds the configured Limits. Its Stage
+can be added to a Pipeline ahead of Akismet:
+
+	tracker := velocity.New(nil)
+
+	pipeline := gokismet.NewPipeline().
+		Add("velocity", tracker.Stage()).
+		Add("akismet", checker)
+*/
+package velocity
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/deepilla/gokismet"
+)
+
+// Akismet parameters used to key submissions.
+const (
+	paramIP      = "user_ip"
+	paramEmail   = "comment_author_email"
+	paramContent = "comment_content"
+)
+
+// A Key is a property by which submissions are counted.
+type Key string
+
+// Supported keys.
+const (
+	// KeyIP counts submissions per IP address.
+	KeyIP Key = "ip"
+	// KeyEmail counts submissions per author email.
+	KeyEmail Key = "email"
+	// KeyContent counts submissions per content
+	// fingerprint, regardless of who posted them.
+	KeyContent Key = "content"
+)
+
+// A Limit is the maximum number of submissions allowed for a
+// key in a sliding window of time.
+type Limit struct {
+	// The property to count submissions by.
+	Key Key
+	// The length of the sliding window.
+	Window time.Duration
+	// The maximum number of submissions allowed in the
+	// window.
+	Max int
+	// The status of submissions over the limit. Defaults
+	// to StatusProbableSpam.
+	Status gokismet.SpamStatus
+}
+
+// DefaultLimits are used by Trackers with no Limits.
+var DefaultLimits = []Limit{
+	{Key: KeyIP, Window: time.Minute, Max: 10},
+	{Key: KeyEmail, Window: time.Minute, Max: 10},
+	{Key: KeyContent, Window: 10 * time.Minute, Max: 5},
+}
+
+// Options configures a Tracker.
+type Options struct {
+	// The Limits to enforce. If nil, DefaultLimits are
+	// used.
+	Limits []Limit
+	// The minimum length of content, in characters after
+	// normalisation (see Fingerprint), to be counted by
+	// KeyContent Limits. Short, common replies such as
+	// "Thanks!" are legitimately posted by many people.
+	// Defaults to 20.
+	MinContentLength int
+	// Returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// A Result is the outcome of a submission.
+type Result struct {
+	// StatusUnknown if the submission is within all
+	// Limits, otherwise the Status of the exceeded Limit.
+	Status gokismet.SpamStatus
+	// A description of the exceeded Limit.
+	Reason string
+	// The exceeded Limit, if any.
+	Limit *Limit
+	// The number of submissions in the Limit's window,
+	// including this one.
+	Count int
+}
+
+// A Tracker counts submissions and enforces Limits. It keeps
+// its counts in memory. A Tracker is safe for concurrent use.
+type Tracker struct {
+	limits     []Limit
+	windows    map[Key]time.Duration
+	minContent int
+	now        func() time.Time
+
+	mu        sync.Mutex
+	events    map[string][]time.Time
+	lastSweep time.Time
+}
+
+// New returns a Tracker. If opts is nil, default options are
+// used.
+func New(opts *Options) *Tracker {
+
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.Limits == nil {
+		o.Limits = DefaultLimits
+	}
+	if o.MinContentLength <= 0 {
+		o.MinContentLength = 20
+	}
+	if o.Now == nil {
+		o.Now = time.Now
+	}
+
+	t := &Tracker{
+		limits:     make([]Limit, len(o.Limits)),
+		windows:    make(map[Key]time.Duration),
+		minContent: o.MinContentLength,
+		now:        o.Now,
+		events:     make(map[string][]time.Time),
+	}
+
+	copy(t.limits, o.Limits)
+
+	for i := range t.limits {
+		l := &t.limits[i]
+		if l.Status == gokismet.StatusUnknown {
+			l.Status = gokismet.StatusProbableSpam
+		}
+		if l.Window > t.windows[l.Key] {
+			t.windows[l.Key] = l.Window
+		}
+	}
+
+	return t
+}
+
+// Observe records a submission and checks it against the
+// Tracker's Limits. If more than one Limit is exceeded, the
+// Result describes the one with the most severe Status.
+func (t *Tracker) Observe(values map[string]string) *Result {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.sweep(now)
+
+	keys := make(map[Key]string)
+	for k := range t.windows {
+		if v := t.value(k, values); v != "" {
+			keys[k] = v
+			id := string(k) + ":" + v
+			t.events[id] = append(t.prune(id, now), now)
+		}
+	}
+
+	result := &Result{}
+
+	for i := range t.limits {
+
+		l := &t.limits[i]
+
+		v, ok := keys[l.Key]
+		if !ok {
+			continue
+		}
+
+		n := count(t.events[string(l.Key)+":"+v], now.Add(-l.Window))
+		if n <= l.Max || l.Status <= result.Status {
+			continue
+		}
+
+		result.Status = l.Status
+		result.Limit = l
+		result.Count = n
+		result.Reason = fmt.Sprintf("%s %s posted %d times in %s (max %d)", l.Key, display(l.Key, v), n, l.Window, l.Max)
+	}
+
+	return result
+}
+
+// Stage returns a Pipeline stage that records each submission
+// and decides content that exceeds a Limit. Content within all
+// Limits is deferred to later stages.
+func (t *Tracker) Stage() gokismet.Stage {
+	return gokismet.StageFunc(func(ctx context.Context, values map[string]string) (*gokismet.StageResult, error) {
+
+		result := t.Observe(values)
+		if result.Limit == nil {
+			return nil, nil
+		}
+
+		return &gokismet.StageResult{
+			Status: result.Status,
+			Reason: result.Reason,
+			Annotations: map[string]string{
+				"velocity": string(result.Limit.Key),
+			},
+		}, nil
+	})
+}
+
+// prune removes events that have left the window for the
+// given id's key, and returns those that remain.
+func (t *Tracker) prune(id string, now time.Time) []time.Time {
+
+	k, _, _ := strings.Cut(id, ":")
+	cutoff := now.Add(-t.windows[Key(k)])
+
+	events := t.events[id]
+	i := 0
+	for i < len(events) && !events[i].After(cutoff) {
+		i++
+	}
+
+	return events[i:]
+}
+
+// sweep removes expired events for all ids, at most once per
+// the longest window, so that memory use is bounded by the
+// rate of submissions.
+func (t *Tracker) sweep(now time.Time) {
+
+	var longest time.Duration
+	for _, w := range t.windows {
+		if w > longest {
+			longest = w
+		}
+	}
+
+	if now.Sub(t.lastSweep) < longest {
+		return
+	}
+	t.lastSweep = now
+
+	for id := range t.events {
+		if events := t.prune(id, now); len(events) > 0 {
+			t.events[id] = events
+		} else {
+			delete(t.events, id)
+		}
+	}
+}
+
+// count returns the number of events after the cutoff.
+func count(events []time.Time, cutoff time.Time) int {
+
+	n := 0
+	for i := len(events) - 1; i >= 0 && events[i].After(cutoff); i-- {
+		n++
+	}
+
+	return n
+}
+
+// value returns the value of a key for a submission, or an
+// empty string if the submission isn't counted by that key.
+func (t *Tracker) value(k Key, values map[string]string) string {
+	switch k {
+	case KeyIP:
+		return strings.TrimSpace(values[paramIP])
+	case KeyEmail:
+		return strings.ToLower(strings.TrimSpace(values[paramEmail]))
+	case KeyContent:
+		if utf8.RuneCountInString(normalize(values[paramContent])) < t.minContent {
+			return ""
+		}
+		return Fingerprint(values[paramContent])
+	}
+	return ""
+}
+
+// display returns a key value in a form suitable for reasons.
+func display(k Key, v string) string {
+	if k == KeyContent {
+		return v[:12]
+	}
+	return v
+}
+
+// Fingerprint returns a hash of a piece of text that ignores
+// differences in case and whitespace. It returns an empty
+// string for blank text.
+func Fingerprint(text string) string {
+
+	text = normalize(text)
+	if text == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalize lowercases text and collapses its whitespace.
+func normalize(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
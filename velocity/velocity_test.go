@@ -0,0 +1,149 @@
+package velocity_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/deepilla/gokismet"
+	"github.com/deepilla/gokismet/velocity"
+)
+
+// clock is a manually advanced time source.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newClock() *clock {
+	return &clock{now: time.Date(2016, time.May, 5, 10, 30, 0, 0, time.UTC)}
+}
+
+// TestTracker verifies that submissions over a Limit are
+// flagged, and that old submissions leave the window.
+func TestTracker(t *testing.T) {
+
+	c := newClock()
+
+	tracker := velocity.New(&velocity.Options{
+		Limits: []velocity.Limit{
+			{Key: velocity.KeyIP, Window: time.Minute, Max: 2},
+			{Key: velocity.KeyContent, Window: time.Hour, Max: 2, Status: gokismet.StatusDefiniteSpam},
+		},
+		Now: c.Now,
+	})
+
+	post := func(ip, content string) *velocity.Result {
+		return tracker.Observe(map[string]string{
+			"user_ip":         ip,
+			"comment_content": content,
+		})
+	}
+
+	for i, content := range []string{"one", "two"} {
+		if r := post("192.168.0.1", content); r.Status != gokismet.StatusUnknown {
+			t.Errorf("Post %d: Expected no limit, got %+v", i+1, r)
+		}
+	}
+
+	r := post("192.168.0.1", "three")
+	if r.Status != gokismet.StatusProbableSpam || r.Count != 3 || r.Reason != "ip 192.168.0.1 posted 3 times in 1m0s (max 2)" {
+		t.Errorf("Expected the IP limit, got %+v", r)
+	}
+
+	// The window slides.
+	c.now = c.now.Add(time.Minute)
+
+	if r := post("192.168.0.1", "four"); r.Status != gokismet.StatusUnknown {
+		t.Errorf("Expected no limit after a minute, got %+v", r)
+	}
+
+	// Identical content from different IPs, ignoring case
+	// and whitespace.
+	post("10.0.0.1", "Buy  cheap pills online")
+	post("10.0.0.2", "buy cheap pills online")
+
+	r = post("10.0.0.3", "BUY CHEAP PILLS ONLINE\n")
+	if r.Status != gokismet.StatusDefiniteSpam || r.Limit.Key != velocity.KeyContent {
+		t.Errorf("Expected the content limit, got %+v", r)
+	}
+
+	// The most severe Limit wins.
+	post("10.0.0.3", "buy cheap pills online")
+	r = post("10.0.0.3", "buy cheap pills online")
+	if r.Status != gokismet.StatusDefiniteSpam {
+		t.Errorf("Expected %s, got %+v", gokismet.StatusDefiniteSpam, r)
+	}
+
+	// Expired submissions are forgotten.
+	c.now = c.now.Add(2 * time.Hour)
+
+	if r := post("10.0.0.3", "buy cheap pills online"); r.Status != gokismet.StatusUnknown {
+		t.Errorf("Expected no limit after the window, got %+v", r)
+	}
+
+	// Short content isn't counted.
+	for i := 0; i < 5; i++ {
+		if r := post(fmt.Sprintf("10.0.1.%d", i), "Thanks!"); r.Status != gokismet.StatusUnknown {
+			t.Errorf("Reply %d: Expected no limit for short content, got %+v", i+1, r)
+		}
+	}
+}
+
+// TestStage verifies that the Tracker can be used as a
+// Pipeline stage.
+func TestStage(t *testing.T) {
+
+	ctx := context.Background()
+
+	tracker := velocity.New(&velocity.Options{
+		Limits: []velocity.Limit{
+			{Key: velocity.KeyEmail, Window: time.Minute, Max: 1},
+		},
+		Now: newClock().Now,
+	})
+
+	stage := tracker.Stage()
+	values := map[string]string{"comment_author_email": "Bob@Example.com"}
+
+	sr, err := stage.Evaluate(ctx, values)
+	if err != nil || sr != nil {
+		t.Errorf("Expected the stage to defer, got %v, %v", sr, err)
+	}
+
+	sr, err = stage.Evaluate(ctx, map[string]string{"comment_author_email": "bob@example.com "})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if sr == nil || sr.Status != gokismet.StatusProbableSpam || sr.Annotations["velocity"] != "email" {
+		t.Fatalf("Expected the email limit, got %+v", sr)
+	}
+
+	if exp := "email bob@example.com posted 2 times in 1m0s (max 1)"; sr.Reason != exp {
+		t.Errorf("Expected reason %q, got %q", exp, sr.Reason)
+	}
+}
+
+// TestFingerprint verifies that fingerprints ignore case and
+// whitespace.
+func TestFingerprint(t *testing.T) {
+
+	if velocity.Fingerprint(" \n") != "" {
+		t.Error("Expected an empty fingerprint for blank text")
+	}
+
+	a := velocity.Fingerprint("Hello   World")
+	b := velocity.Fingerprint("hello world\n")
+	if a == "" || a != b {
+		t.Errorf("Expected matching fingerprints, got %q and %q", a, b)
+	}
+
+	if a == velocity.Fingerprint("hello there") {
+		t.Error("Expected different fingerprints for different text")
+	}
+}